@@ -1,99 +1,649 @@
 package app
 
 import (
+	"errors"
+	"fmt"
+	"game/camera"
 	"game/device"
 	"game/drawer"
 	"game/gravity"
+	"game/input"
 	"game/model"
 	"game/object"
 	"game/renderer"
+	"game/shader"
+	"game/swapchain"
+	"game/transfer"
 	"game/window"
+	"image"
+	"image/png"
+	"log/slog"
 	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-gl/glfw/v3.3/glfw"
 	"github.com/goki/vulkan"
 )
 
 type App struct {
-	window *window.Window
-	device *device.Device
+	window    *window.Window
+	device    *device.Device
+	offscreen *renderer.Offscreen
+	transfer  *transfer.Manager
+	watcher   *shader.Watcher
 
 	models []*model.Model
 
 	gameObjectsDrawer *drawer.Drawer
 	gravity           *gravity.Gravity
+	camera            *camera.Camera
+	profiler          *device.Profiler
+	logTimings        bool
+
+	input *input.Input
+	// simulation controls driven by the bound actions
+	paused     bool
+	steps      int
+	speed      float64
+	lastFrame  time.Time
+	screenshot bool
+	// stopTime is the simulated time Run and Render end at, zero for none.
+	stopTime float64
 
 	renderer    *renderer.Renderer
 	gameObjects []*object.GameObject
 }
 
-func New() *App {
-	window := window.New()
+type Config struct {
+	Device    device.Config
+	Swapchain swapchain.Config
+	Gravity   gravity.Options
+	// Scene is a catalog file to load the mass bodies from.
+	Scene string
+	// Bodies generates a random scene with this many bodies when no Scene
+	// is given, seeded by Seed.
+	Bodies int
+	Seed   int64
+	// Steps ends Run and Render once the simulated time reaches this many
+	// steps of Gravity.Dt. Zero means no limit.
+	Steps int
+	// Profile logs the GPU pass timings and device memory usage once a
+	// second.
+	Profile bool
+	// ShaderDir loads the SPIR-V from this directory instead of the copies
+	// embedded into the binary.
+	ShaderDir string
+	// Follow keeps the camera on none, com (the centre of mass) or a body
+	// given by index or catalog name.
+	Follow string
+	// Trails lists the bodies, by index or catalog name, that draw a trail
+	// instead of the catalog's choice. Empty keeps every trail the catalog
+	// does not hide.
+	Trails []string
+	// CoRotating rotates the view with the two heaviest bodies.
+	CoRotating bool
+	// Bindings maps the keys of the window to actions.
+	Bindings input.Bindings
+	// WatchShaders recompiles and reloads shaders when their sources in
+	// ShaderDir, or the shaders directory by default, change while the
+	// window is open.
+	WatchShaders bool
+}
+
+// shaderPollInterval is how often the shaders directory is checked for
+// changes when WatchShaders is set.
+const shaderPollInterval = 500 * time.Millisecond
+
+var DefaultConfig = Config{
+	Device:    device.DefaultConfig,
+	Swapchain: swapchain.DefaultConfig,
+	Gravity:   gravity.DefaultOptions,
+	Bindings:  input.DefaultBindings,
+}
+
+type BodyState struct {
+	ID       int
+	Name     string
+	Mass     float32
+	Position [2]float32
+	Velocity [2]float32
+}
 
+func New(config Config) (*App, error) {
+	window, err := window.New()
+	if err != nil {
+		return nil, err
+	}
+
+	a := &App{
+		window:     &window,
+		logTimings: config.Profile,
+	}
+	if err := a.init(config); err != nil {
+		a.Close()
+		return nil, err
+	}
+
+	return a, nil
+}
+
+func (a *App) init(config Config) error {
 	vulkan.SetGetInstanceProcAddr(glfw.GetVulkanGetInstanceProcAddress())
 	if err := vulkan.Init(); err != nil {
-		panic("failed to initialize Vulkan: " + err.Error())
+		return fmt.Errorf("failed to initialize Vulkan: %w", err)
+	}
+
+	var err error
+	if a.device, err = device.New(*a.window, config.Device); err != nil {
+		return err
+	}
+	if err := a.initSimulation(config); err != nil {
+		return err
+	}
+
+	if a.renderer, err = renderer.New(a.device, a.window.Extent, config.Swapchain); err != nil {
+		return err
+	}
+	if err := a.initCamera(config, a.renderer.Extent()); err != nil {
+		return err
+	}
+	if a.gameObjectsDrawer, err = drawer.New(a.device, a.transfer, a.renderer.RenderPass, a.renderer.Samples, a.renderer.ColorSpace(), a.descriptorsLayouts(), a.gameObjects); err != nil {
+		return err
+	}
+	if err := a.transfer.Wait(); err != nil {
+		return err
+	}
+	a.input = input.New(config.Bindings)
+	a.window.SetHandler(a.input)
+	a.speed = 1
+
+	if a.profiler, err = newProfiler(a.device, config.Swapchain.FramesInFlight); err != nil {
+		return err
+	}
+	a.gravity.Profiler = a.profiler
+	a.renderer.Profiler = a.profiler
+
+	if config.WatchShaders {
+		a.watcher = shader.NewWatcher(shader.Dir, shaderPollInterval)
+	}
+
+	return nil
+}
+
+// initSimulation loads the scene and uploads it to the compute pipelines.
+func (a *App) initSimulation(config Config) error {
+	shader.Dir = config.ShaderDir
+	// reloaded shaders have to come from disk
+	if config.WatchShaders && shader.Dir == "" {
+		shader.Dir = "shaders"
+	}
+
+	a.stopTime = float64(config.Steps) * float64(config.Gravity.Dt)
+
+	var err error
+	if a.transfer, err = transfer.New(a.device, transfer.DefaultRingSize); err != nil {
+		return err
+	}
+	a.models, a.gameObjects, err = loadGameObjects(a.device, a.transfer, config)
+	if err != nil {
+		return err
+	}
+
+	if a.gravity, err = gravity.New(a.device, config.Swapchain.FramesInFlight, config.Gravity); err != nil {
+		return err
+	}
+	if err := a.gravity.UploadMassObjects(a.device, a.transfer, a.gameObjects); err != nil {
+		return err
+	}
+	if err := a.gravity.UploadFieldObjects(a.device, a.transfer, a.gameObjects); err != nil {
+		return err
+	}
+	if err := a.gravity.UploadDrawCommands(a.device, a.transfer, a.gameObjects); err != nil {
+		return err
+	}
+
+	return a.transfer.Wait()
+}
+
+// finished reports whether the simulation has run for Config.Steps.
+func (a *App) finished() bool {
+	return a.stopTime > 0 && a.gravity.SimulatedTime() >= a.stopTime
+}
+
+func newProfiler(d *device.Device, framesInFlight int) (*device.Profiler, error) {
+	profiler, err := device.NewProfiler(d, framesInFlight, gravity.PassGravity, gravity.PassField, renderer.PassDraw)
+	if err != nil {
+		return nil, err
+	}
+	if !profiler.Enabled() {
+		slog.Warn("GPU timestamps are not supported, pass timings are disabled")
+	}
+
+	return profiler, nil
+}
+
+// loadVulkan initializes Vulkan from the system loader, without GLFW.
+func loadVulkan() error {
+	if err := vulkan.SetDefaultGetInstanceProcAddr(); err != nil {
+		return fmt.Errorf("failed to load Vulkan: %w", err)
+	}
+	if err := vulkan.Init(); err != nil {
+		return fmt.Errorf("failed to initialize Vulkan: %w", err)
+	}
+
+	return nil
+}
+
+// Devices lists the physical devices the simulation can run on.
+func Devices() ([]device.Info, error) {
+	if err := loadVulkan(); err != nil {
+		return nil, err
+	}
+
+	return device.Enumerate()
+}
+
+// NewHeadless sets up only the compute side of the simulation: no window,
+// surface, swapchain or graphics pipeline is created.
+func NewHeadless(config Config) (*App, error) {
+	if err := loadVulkan(); err != nil {
+		return nil, err
+	}
+
+	a := &App{}
+	var err error
+	if a.device, err = device.NewHeadless(config.Device); err == nil {
+		err = a.initSimulation(config)
+	}
+	if err != nil {
+		a.Close()
+		return nil, err
+	}
+
+	return a, nil
+}
+
+// NewOffscreen sets up rendering into an image of the given size instead
+// of a window, for producing frame sequences.
+func NewOffscreen(config Config, extent vulkan.Extent2D) (*App, error) {
+	a, err := NewHeadless(config)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.initOffscreen(config, extent); err != nil {
+		a.Close()
+		return nil, err
+	}
+
+	return a, nil
+}
+
+func (a *App) initOffscreen(config Config, extent vulkan.Extent2D) error {
+	var err error
+	if a.offscreen, err = renderer.NewOffscreen(a.device, extent, config.Swapchain.FramesInFlight, config.Swapchain.Samples); err != nil {
+		return err
+	}
+	if err := a.initCamera(config, extent); err != nil {
+		return err
+	}
+	if a.gameObjectsDrawer, err = drawer.New(a.device, a.transfer, a.offscreen.RenderPass, a.offscreen.Samples, vulkan.ColorSpaceSrgbNonlinear, a.descriptorsLayouts(), a.gameObjects); err != nil {
+		return err
+	}
+	if err := a.transfer.Wait(); err != nil {
+		return err
+	}
+
+	if a.profiler, err = newProfiler(a.device, config.Swapchain.FramesInFlight); err != nil {
+		return err
+	}
+	a.logTimings = config.Profile
+	a.gravity.Profiler = a.profiler
+	a.offscreen.Profiler = a.profiler
+
+	return nil
+}
+
+// Render draws frames images, advancing the simulation by frameDt simulated
+// seconds between them, and writes them to dir as numbered PNG files. It
+// stops early once Config.Steps have been simulated.
+func (a *App) Render(frames int, frameDt float32, dir string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	for frame := range frames {
+		if a.finished() {
+			break
+		}
+		img, err := a.renderFrame(frameDt)
+		if err != nil {
+			return err
+		}
+
+		if err := writePNG(filepath.Join(dir, fmt.Sprintf("frame_%05d.png", frame)), img); err != nil {
+			return err
+		}
+	}
+
+	if a.logTimings {
+		a.logPassTimings()
+		a.logMemoryStats()
+	}
+
+	return nil
+}
+
+func (a *App) renderFrame(frameDt float32) (*image.RGBA, error) {
+	commandBuffer, frameIdx, err := a.offscreen.BeginFrame()
+	if err != nil {
+		return nil, err
 	}
+	a.gravity.ComputeGravityFor(commandBuffer.ComputeCommandBuffer, frameIdx, frameDt)
+	computeFence, descriptors, err := a.gravity.ComputeGravityField(commandBuffer.ComputeCommandBuffer, frameIdx)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.offscreen.BeginRenderPass(); err != nil {
+		return nil, err
+	}
+	a.camera.Track(a.gravity.Snapshot(), a.gravity.SimulatedTime())
+	a.gameObjectsDrawer.Draw(
+		commandBuffer.GraphicsCommandBuffer,
+		[]vulkan.DescriptorSet{descriptors, a.camera.Update(frameIdx)},
+		a.gravity.DrawBuffer(frameIdx),
+		a.gravity.Trail(),
+	)
+	a.offscreen.EndRenderPass()
+
+	return a.offscreen.EndFrame(computeFence)
+}
 
-	device := device.New(window)
+// Timings returns the rolling average GPU time of every profiled pass.
+func (a *App) Timings() []device.Timing {
+	return a.profiler.Timings()
+}
 
-	models, objects := loadGameObjects(device)
+func (a *App) logPassTimings() {
+	attrs := make([]any, 0, 2*len(a.profiler.Timings()))
+	for _, timing := range a.profiler.Timings() {
+		attrs = append(attrs, slog.Duration(timing.Pass, timing.Average))
+	}
+	slog.Info("GPU pass timings", attrs...)
+}
 
-	gravity := gravity.New(device)
-	gravity.UploadMassObjects(device, objects)
-	gravity.UploadFieldObjects(device, objects)
+func (a *App) logMemoryStats() {
+	for _, stats := range a.device.MemoryStats() {
+		slog.Info("device memory",
+			slog.Int("memory type", int(stats.MemoryType)),
+			slog.Int("blocks", stats.Blocks),
+			slog.Int("allocations", stats.Allocations),
+			slog.Uint64("reserved", stats.Reserved),
+			slog.Uint64("used", stats.Used),
+		)
+	}
+}
 
-	renderer := renderer.New(device, window.Extent)
-	drawer := drawer.New(device, renderer.RenderPass, gravity.DescriptorsLayout)
+func writePNG(path string, img image.Image) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
 
-	return &App{
-		window:            &window,
-		device:            device,
-		gameObjectsDrawer: drawer,
-		gravity:           gravity,
-		renderer:          renderer,
-		models:            models,
-		gameObjects:       objects,
+	if err := png.Encode(file, img); err != nil {
+		file.Close()
+		return err
 	}
+
+	return file.Close()
+}
+
+// Step advances the simulation like Simulate without reading the state back.
+func (a *App) Step(steps int, dt float32) error {
+	return a.gravity.Simulate(steps, dt)
 }
 
-func (a *App) Run() {
-	for !a.window.ShouldClose() {
+func (a *App) DeviceName() string {
+	return a.device.Name()
+}
+
+// Simulate advances the simulation by steps fixed steps of dt and returns
+// the resulting state of every mass body.
+func (a *App) Simulate(steps int, dt float32) ([]BodyState, error) {
+	if err := a.Step(steps, dt); err != nil {
+		return nil, err
+	}
+
+	var bodies []*object.GameObject
+	for _, object := range a.gameObjects {
+		if object.Mass != nil {
+			bodies = append(bodies, object)
+		}
+	}
+
+	states, err := a.gravity.ReadMassObjects()
+	if err != nil {
+		return nil, err
+	}
+	result := make([]BodyState, len(states))
+	for i, state := range states {
+		result[i] = BodyState{
+			ID:       bodies[i].Mass.ID,
+			Name:     bodies[i].Mass.Name,
+			Mass:     state.Mass(),
+			Position: state.Position(),
+			Velocity: state.Velocity(),
+		}
+	}
+
+	return result, nil
+}
+
+func (a *App) Run() error {
+	lastReport := time.Now()
+	a.lastFrame = time.Now()
+	for !a.window.ShouldClose() && !a.finished() {
 		glfw.PollEvents()
+		a.input.Update()
+		if err := a.handleInput(); err != nil {
+			return err
+		}
+
+		if time.Since(lastReport) >= time.Second && a.profiler.Enabled() {
+			lastReport = time.Now()
+			a.window.SetTitle("Game App - " + a.profiler.String())
+			if a.logTimings {
+				a.logPassTimings()
+				a.logMemoryStats()
+			}
+		}
+
+		if err := a.reloadShaders(); err != nil {
+			return err
+		}
 
-		if commandBuffer, frameIdx, err := a.renderer.BeginFrame(); err == nil {
-			a.gravity.ComputeGravity(commandBuffer.ComputeCommandBuffer, frameIdx)
-			computeFence, descriptors := a.gravity.ComputeGravityField(commandBuffer.ComputeCommandBuffer, frameIdx)
-			a.renderer.BeginSwapChainRenderPass()
-			a.gameObjectsDrawer.RenderGameObects(commandBuffer.GraphicsCommandBuffer, descriptors, a.gameObjects)
-			a.renderer.EndSwapChainRenderPass()
-			a.renderer.EndFrame(computeFence)
-
-			if a.window.SizeChanged {
-				for a.window.Extent.Height == 0 || a.window.Extent.Width == 0 {
-					glfw.WaitEvents()
-				}
-				vulkan.DeviceWaitIdle(a.device.LogicalDevice)
-				a.window.SizeChanged = false
-				a.renderer.UpdateSwapchain(a.window.Extent)
+		err := a.drawFrame()
+		if errors.Is(err, swapchain.ErrOutOfDate) {
+			a.window.SizeChanged = true
+		} else if err != nil {
+			return err
+		}
+
+		if a.window.SizeChanged {
+			for a.window.Extent.Height == 0 || a.window.Extent.Width == 0 {
+				glfw.WaitEvents()
+			}
+			if err := device.Check(vulkan.DeviceWaitIdle(a.device.LogicalDevice), "wait for device idle"); err != nil {
+				return err
 			}
+			a.window.SizeChanged = false
+			if err := a.renderer.UpdateSwapchain(a.window.Extent); err != nil {
+				return err
+			}
+			a.camera.SetExtent(a.renderer.Extent())
 		}
 	}
 
-	if err := vulkan.Error(vulkan.DeviceWaitIdle(a.device.LogicalDevice)); err != nil {
-		panic("failed to wait for finish: " + err.Error())
+	return device.Check(vulkan.DeviceWaitIdle(a.device.LogicalDevice), "wait for finish")
+}
+
+// reloadShaders rebuilds the pipelines whose shaders the watcher saw change.
+// A pipeline that fails to build is logged and the old one is kept.
+func (a *App) reloadShaders() error {
+	if a.watcher == nil {
+		return nil
+	}
+	changed := a.watcher.Changed()
+	if len(changed) == 0 {
+		return nil
+	}
+
+	if err := device.Check(vulkan.DeviceWaitIdle(a.device.LogicalDevice), "wait for device idle"); err != nil {
+		return err
+	}
+	if slices.ContainsFunc(changed, a.gameObjectsDrawer.UsesShader) {
+		if err := a.gameObjectsDrawer.Reload(); err != nil {
+			slog.Error("failed to reload draw pipeline", slog.Any("error", err))
+		}
 	}
+	if slices.Contains(changed, gravity.GravityShader) || slices.Contains(changed, gravity.FieldShader) {
+		if err := a.gravity.Reload(); err != nil {
+			slog.Error("failed to reload compute pipelines", slog.Any("error", err))
+		}
+	}
+
+	return nil
+}
+
+func (a *App) drawFrame() error {
+	commandBuffer, frameIdx, err := a.renderer.BeginFrame()
+	if err != nil {
+		return err
+	}
+	if elapsed := a.elapsed(); elapsed > 0 {
+		a.gravity.ComputeGravityFor(commandBuffer.ComputeCommandBuffer, frameIdx, elapsed)
+	}
+	computeFence, descriptors, err := a.gravity.ComputeGravityField(commandBuffer.ComputeCommandBuffer, frameIdx)
+	if err != nil {
+		return err
+	}
+	if err := a.renderer.BeginSwapChainRenderPass(); err != nil {
+		return err
+	}
+	a.camera.Track(a.gravity.Snapshot(), a.gravity.SimulatedTime())
+	a.gameObjectsDrawer.Draw(
+		commandBuffer.GraphicsCommandBuffer,
+		[]vulkan.DescriptorSet{descriptors, a.camera.Update(frameIdx)},
+		a.gravity.DrawBuffer(frameIdx),
+		a.gravity.Trail(),
+	)
+	a.renderer.EndSwapChainRenderPass()
+
+	img, err := a.renderer.EndFrame(computeFence, a.screenshot)
+	if err != nil {
+		return err
+	}
+	if img != nil {
+		a.screenshot = false
+		a.saveScreenshot(img)
+	}
+
+	return nil
 }
 
+// Close releases everything the app owns. It is safe to call on a partially
+// constructed app, as the constructors do on failure.
 func (a *App) Close() {
+	if a.watcher != nil {
+		a.watcher.Close()
+	}
+	if a.device != nil && a.device.LogicalDevice != nil {
+		vulkan.DeviceWaitIdle(a.device.LogicalDevice)
+	}
 	for _, model := range a.models {
 		model.Close()
 	}
-	a.gravity.Close()
-	a.gameObjectsDrawer.Close()
-	a.renderer.Close()
-	a.device.Close()
-	a.window.Close()
+	if a.gravity != nil {
+		a.gravity.Close()
+	}
+	if a.gameObjectsDrawer != nil {
+		a.gameObjectsDrawer.Close()
+	}
+	if a.camera != nil {
+		a.camera.Close()
+	}
+	if a.renderer != nil {
+		a.renderer.Close()
+	}
+	if a.offscreen != nil {
+		a.offscreen.Close()
+	}
+	a.profiler.Close()
+	if a.transfer != nil {
+		a.transfer.Close()
+	}
+	if a.device != nil {
+		a.device.Close()
+	}
+	if a.window != nil {
+		a.window.Close()
+	}
+}
+
+func (a *App) initCamera(config Config, extent vulkan.Extent2D) error {
+	var bodies []*object.GameObject
+	for _, object := range a.gameObjects {
+		if object.Mass != nil {
+			bodies = append(bodies, object)
+		}
+	}
+	follow, err := parseFollow(config.Follow, bodies)
+	if err != nil {
+		return err
+	}
+	follow.CoRotating = config.CoRotating
+	if err := follow.Check(len(bodies)); err != nil {
+		return err
+	}
+
+	if a.camera, err = camera.New(a.device, config.Swapchain.FramesInFlight, extent); err != nil {
+		return err
+	}
+	a.camera.Follow = follow
+
+	return nil
+}
+
+// parseFollow resolves the Follow option against the mass bodies, in upload
+// order.
+func parseFollow(value string, bodies []*object.GameObject) (camera.Follow, error) {
+	switch value {
+	case "", "none":
+		return camera.Follow{}, nil
+	case "com":
+		return camera.Follow{Mode: camera.FollowCenterOfMass}, nil
+	}
+
+	if index, err := strconv.Atoi(value); err == nil {
+		return camera.Follow{Mode: camera.FollowBody, Body: index}, nil
+	}
+	for i, body := range bodies {
+		if strings.EqualFold(body.Mass.Name, value) {
+			return camera.Follow{Mode: camera.FollowBody, Body: i}, nil
+		}
+	}
+
+	return camera.Follow{}, fmt.Errorf("no body named %q to follow", value)
+}
+
+// descriptorsLayouts are the descriptor set layouts of the draw pipelines,
+// the simulation buffers at set 0 and the camera at camera.Set.
+func (a *App) descriptorsLayouts() []vulkan.DescriptorSetLayout {
+	return []vulkan.DescriptorSetLayout{a.gravity.DescriptorsLayout, a.camera.Layout}
 }
 
 func createCircleVertices(numSides int) []model.Vertex {
@@ -122,14 +672,14 @@ func createCircleVertices(numSides int) []model.Vertex {
 	return result
 }
 
-func loadGameObjects(device *device.Device) ([]*model.Model, []*object.GameObject) {
+func loadGameObjects(device *device.Device, t *transfer.Manager, config Config) ([]*model.Model, []*object.GameObject, error) {
 	// triangle := model.New(device, []model.Vertex{
 	// 	{Pos: model.Position{X: 0.0, Y: -0.5}, RGB: [3]float32{1, 0, 0}},
 	// 	{Pos: model.Position{X: 0.5, Y: 0.5}, RGB: [3]float32{0, 1, 0}},
 	// 	{Pos: model.Position{X: -0.5, Y: 0.5}, RGB: [3]float32{0, 0, 1}},
 	// })
 
-	rectangle := model.New(device, []model.Vertex{
+	rectangle, err := model.New(device, t, []model.Vertex{
 		{Pos: model.Position{X: -0.5, Y: -0.5}, RGB: [3]float32{1, 0, 0}},
 		{Pos: model.Position{X: 0.5, Y: 0.5}, RGB: [3]float32{0, 1, 0}},
 		{Pos: model.Position{X: -0.5, Y: 0.5}, RGB: [3]float32{0, 1, 0}},
@@ -137,26 +687,19 @@ func loadGameObjects(device *device.Device) ([]*model.Model, []*object.GameObjec
 		{Pos: model.Position{X: 0.5, Y: -0.5}, RGB: [3]float32{0, 0, 1}},
 		{Pos: model.Position{X: 0.5, Y: 0.5}, RGB: [3]float32{1, 1, 0}},
 	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	circle, err := model.New(device, t, createCircleVertices(64))
+	if err != nil {
+		return []*model.Model{rectangle}, nil, err
+	}
+	models := []*model.Model{rectangle, circle}
 
-	circle := model.New(device, createCircleVertices(64))
-
-	objects := []*object.GameObject{
-		object.New(circle, [3]float32{1.0, 0.0, 0.0}).WithInitialTranforms([]object.Transform{
-			object.NewScale(0.1, 0.1),
-			object.NewTransition(0.5, 0.5),
-		}).WithMass(model.MassModel{
-			ID:       0,
-			Mass:     1,
-			Velocity: [2]float32{-0.5, 0.0},
-		}),
-		object.New(circle, [3]float32{0.0, 0.0, 1.0}).WithInitialTranforms([]object.Transform{
-			object.NewScale(0.1, 0.1),
-			object.NewTransition(-.45, -.25),
-		}).WithMass(model.MassModel{
-			ID:       1,
-			Mass:     1,
-			Velocity: [2]float32{0.5, 0.0},
-		}),
+	objects, err := massObjects(circle, config)
+	if err != nil {
+		return models, nil, err
 	}
 
 	for i := range 40 {
@@ -170,7 +713,7 @@ func loadGameObjects(device *device.Device) ([]*model.Model, []*object.GameObjec
 		}
 	}
 
-	return []*model.Model{rectangle, circle}, objects
+	return models, objects, nil
 }
 
 // func transformVertices(depth int, vertices []model.Vertex) []model.Vertex {
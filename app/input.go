@@ -0,0 +1,107 @@
+package app
+
+import (
+	"fmt"
+	"game/device"
+	"game/input"
+	"image"
+	"log/slog"
+	"math"
+	"time"
+
+	"github.com/goki/vulkan"
+)
+
+// zoomPerScroll is the factor the camera zooms in by per scroll wheel step.
+const zoomPerScroll = 1.1
+
+// stepDuration is the simulated time the step action advances by.
+const stepDuration = float32(1.0 / 60)
+
+// limits of the speed action multipliers
+const (
+	minSpeed = 1.0 / 64
+	maxSpeed = 64
+)
+
+// handleInput applies the input of the last frame to the camera and the
+// simulation controls.
+func (a *App) handleInput() error {
+	if offset, cursor := a.input.ScrollOffset(); offset != 0 {
+		a.camera.ZoomAt(float32(math.Pow(zoomPerScroll, float64(offset))), cursor)
+	}
+	if from, to := a.input.Drag(); from != to {
+		a.camera.Pan(from, to)
+	}
+
+	for _, action := range a.input.Actions() {
+		switch action {
+		case input.ActionPause:
+			a.paused = !a.paused
+			slog.Info("simulation", slog.Bool("paused", a.paused))
+		case input.ActionStep:
+			a.paused = true
+			a.steps++
+		case input.ActionSpeedUp:
+			a.speed = min(a.speed*2, maxSpeed)
+			slog.Info("simulation", slog.Float64("speed", a.speed))
+		case input.ActionSlowDown:
+			a.speed = max(a.speed/2, minSpeed)
+			slog.Info("simulation", slog.Float64("speed", a.speed))
+		case input.ActionReset:
+			if err := a.reset(); err != nil {
+				return err
+			}
+		case input.ActionScreenshot:
+			if err := a.renderer.CheckScreenshot(); err != nil {
+				slog.Error("cannot take screenshot", slog.Any("error", err))
+				continue
+			}
+			a.screenshot = true
+		}
+	}
+
+	return nil
+}
+
+// elapsed returns the simulated time to advance by this frame, the real
+// time since the last frame scaled by the speed, or a step while paused.
+func (a *App) elapsed() float32 {
+	now := time.Now()
+	real := now.Sub(a.lastFrame).Seconds()
+	a.lastFrame = now
+
+	if a.paused {
+		if a.steps > 0 {
+			a.steps--
+			return stepDuration
+		}
+		return 0
+	}
+
+	return float32(real * a.speed)
+}
+
+// reset restarts the simulation from the loaded scene.
+func (a *App) reset() error {
+	if err := device.Check(vulkan.DeviceWaitIdle(a.device.LogicalDevice), "wait for device idle"); err != nil {
+		return err
+	}
+	if err := a.gravity.Reset(a.transfer); err != nil {
+		return err
+	}
+	slog.Info("simulation reset")
+
+	return a.transfer.Wait()
+}
+
+// saveScreenshot writes img to a timestamped PNG in the working directory.
+// Failing to write it does not stop the app.
+func (a *App) saveScreenshot(img *image.RGBA) {
+	path := fmt.Sprintf("screenshot_%s.png", time.Now().Format("20060102_150405.000"))
+	if err := writePNG(path, img); err != nil {
+		slog.Error("failed to save screenshot", slog.Any("error", err))
+		return
+	}
+	slog.Info("saved screenshot", slog.String("path", path))
+}
@@ -0,0 +1,139 @@
+package app
+
+import (
+	"fmt"
+	"game/catalog"
+	"game/model"
+	"game/object"
+	"math"
+	"math/rand"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+var palette = [][3]float32{
+	{1.0, 0.0, 0.0},
+	{0.0, 0.0, 1.0},
+	{1.0, 0.8, 0.0},
+	{0.0, 0.8, 0.2},
+	{0.8, 0.0, 0.8},
+	{0.0, 0.8, 0.8},
+}
+
+func massObjects(circle *model.Model, config Config) ([]*object.GameObject, error) {
+	objects, err := sceneObjects(circle, config)
+	if err != nil {
+		return nil, err
+	}
+	if err := showTrails(objects, config.Trails); err != nil {
+		return nil, err
+	}
+
+	return objects, nil
+}
+
+// showTrails hides the trail of every body not in trails, given by index or
+// name. Empty trails leaves the bodies as they are.
+func showTrails(objects []*object.GameObject, trails []string) error {
+	if len(trails) == 0 {
+		return nil
+	}
+
+	shown := make([]bool, len(objects))
+	for _, value := range trails {
+		value = strings.TrimSpace(value)
+		i, err := strconv.Atoi(value)
+		if err != nil {
+			i = slices.IndexFunc(objects, func(o *object.GameObject) bool {
+				return strings.EqualFold(o.Mass.Name, value)
+			})
+		}
+		if i < 0 || i >= len(objects) {
+			return fmt.Errorf("no body %q to draw a trail for", value)
+		}
+		shown[i] = true
+	}
+	for i, o := range objects {
+		o.Mass.HideTrail = !shown[i]
+	}
+
+	return nil
+}
+
+func sceneObjects(circle *model.Model, config Config) ([]*object.GameObject, error) {
+	switch {
+	case config.Scene != "":
+		return catalogObjects(circle, config.Scene)
+	case config.Bodies > 0:
+		return randomObjects(circle, config.Bodies, config.Seed), nil
+	}
+
+	return []*object.GameObject{
+		object.New(circle, [3]float32{1.0, 0.0, 0.0}).WithInitialTranforms([]object.Transform{
+			object.NewScale(0.1, 0.1),
+			object.NewTransition(0.5, 0.5),
+		}).WithMass(model.MassModel{
+			ID:       0,
+			Mass:     1,
+			Velocity: [2]float32{-0.5, 0.0},
+		}),
+		object.New(circle, [3]float32{0.0, 0.0, 1.0}).WithInitialTranforms([]object.Transform{
+			object.NewScale(0.1, 0.1),
+			object.NewTransition(-.45, -.25),
+		}).WithMass(model.MassModel{
+			ID:       1,
+			Mass:     1,
+			Velocity: [2]float32{0.5, 0.0},
+		}),
+	}, nil
+}
+
+func catalogObjects(circle *model.Model, path string) ([]*object.GameObject, error) {
+	bodies, err := catalog.Load(catalog.DefaultUnits, path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load scene: %w", err)
+	}
+
+	objects := make([]*object.GameObject, len(bodies))
+	for i, body := range bodies {
+		objects[i] = object.New(circle, palette[i%len(palette)]).WithInitialTranforms([]object.Transform{
+			object.NewScale(0.05, 0.05),
+			object.NewTransition(float64(body.Position[0]), float64(body.Position[1])),
+		}).WithMass(body.Mass)
+	}
+
+	return objects, nil
+}
+
+// randomObjects scatters count equal masses over a disk and gives each the
+// circular velocity for the mass enclosed by its orbit.
+func randomObjects(circle *model.Model, count int, seed int64) []*object.GameObject {
+	const (
+		radius    = 0.9
+		totalMass = 2.0
+	)
+	rng := rand.New(rand.NewSource(seed))
+
+	objects := make([]*object.GameObject, count)
+	for i := range objects {
+		r := radius * math.Sqrt(rng.Float64())
+		angle := 2 * math.Pi * rng.Float64()
+		enclosed := totalMass * (r * r) / (radius * radius)
+		speed := math.Sqrt(catalog.SimulationG * enclosed / max(r, 0.05))
+
+		objects[i] = object.New(circle, palette[i%len(palette)]).WithInitialTranforms([]object.Transform{
+			object.NewScale(0.02, 0.02),
+			object.NewTransition(r*math.Cos(angle), r*math.Sin(angle)),
+		}).WithMass(model.MassModel{
+			ID:   i,
+			Mass: float32(totalMass / float64(count)),
+			Velocity: [2]float32{
+				float32(-speed * math.Sin(angle)),
+				float32(speed * math.Cos(angle)),
+			},
+		})
+	}
+
+	return objects
+}
@@ -0,0 +1,187 @@
+package bench
+
+import (
+	"game/app"
+	"runtime"
+	"runtime/debug"
+	"slices"
+	"time"
+)
+
+var StandardSizes = []int{64, 256, 1024, 4096}
+
+// measureTime is how long frames are submitted for per scene, the default
+// of go test -benchtime.
+const measureTime = time.Second
+
+// Options configure a benchmark run. Config.Gravity.Dt is the step length,
+// and Config.Steps, when set, is the number of steps measured per scene
+// instead of submitting frames for a second.
+type Options struct {
+	Config app.Config
+	// Sizes are the body counts of the generated scenes to run; ignored when
+	// Config.Scene names a catalog.
+	Sizes         []int
+	StepsPerFrame int
+}
+
+type Percentiles struct {
+	P50 float64 `json:"p50"`
+	P90 float64 `json:"p90"`
+	P99 float64 `json:"p99"`
+	Max float64 `json:"max"`
+}
+
+type Result struct {
+	Scene                 string      `json:"scene"`
+	Bodies                int         `json:"bodies"`
+	Frames                int         `json:"frames"`
+	StepsPerFrame         int         `json:"steps_per_frame"`
+	StepsPerSecond        float64     `json:"steps_per_second"`
+	InteractionsPerSecond float64     `json:"interactions_per_second"`
+	FrameTimeMs           Percentiles `json:"frame_time_ms"`
+}
+
+type Report struct {
+	Revision  string    `json:"revision,omitempty"`
+	Device    string    `json:"device"`
+	GoVersion string    `json:"go_version"`
+	Time      time.Time `json:"time"`
+	Results   []Result  `json:"results"`
+}
+
+// Frames simulates frames of stepsPerFrame steps for at least d, or exactly
+// frames of them when frames is positive, after a warm-up frame and returns
+// how long each of them took.
+func Frames(a *app.App, stepsPerFrame int, dt float32, d time.Duration, frames int) ([]time.Duration, error) {
+	// first submission pays for pipeline warm-up
+	if err := a.Step(stepsPerFrame, dt); err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	done := func(times []time.Duration) bool {
+		if frames > 0 {
+			return len(times) >= frames
+		}
+		return time.Since(start) >= d
+	}
+
+	var times []time.Duration
+	for !done(times) {
+		frameStart := time.Now()
+		if err := a.Step(stepsPerFrame, dt); err != nil {
+			return nil, err
+		}
+		times = append(times, time.Since(frameStart))
+	}
+
+	return times, nil
+}
+
+func Run(options Options) (Report, error) {
+	report := Report{
+		Revision:  revision(),
+		GoVersion: runtime.Version(),
+		Time:      time.Now().UTC(),
+	}
+
+	configs := []app.Config{options.Config}
+	if options.Config.Scene == "" {
+		configs = configs[:0]
+		for _, size := range options.Sizes {
+			config := options.Config
+			config.Bodies = size
+			configs = append(configs, config)
+		}
+	}
+
+	for _, config := range configs {
+		device, result, err := run(config, options.StepsPerFrame)
+		if err != nil {
+			return report, err
+		}
+		report.Device = device
+		report.Results = append(report.Results, result)
+	}
+
+	return report, nil
+}
+
+func run(config app.Config, stepsPerFrame int) (string, Result, error) {
+	dt := config.Gravity.Dt
+	a, err := app.NewHeadless(config)
+	if err != nil {
+		return "", Result{}, err
+	}
+	defer a.Close()
+
+	states, err := a.Simulate(0, dt)
+	if err != nil {
+		return "", Result{}, err
+	}
+	bodies := len(states)
+
+	frames := (config.Steps + stepsPerFrame - 1) / stepsPerFrame
+	frameTimes, err := Frames(a, stepsPerFrame, dt, measureTime, frames)
+	if err != nil {
+		return "", Result{}, err
+	}
+	var total time.Duration
+	for _, t := range frameTimes {
+		total += t
+	}
+
+	scene := config.Scene
+	if scene == "" {
+		scene = "random"
+	}
+
+	stepsPerSecond := float64(stepsPerFrame*len(frameTimes)) / total.Seconds()
+	return a.DeviceName(), Result{
+		Scene:                 scene,
+		Bodies:                bodies,
+		Frames:                len(frameTimes),
+		StepsPerFrame:         stepsPerFrame,
+		StepsPerSecond:        stepsPerSecond,
+		InteractionsPerSecond: stepsPerSecond * float64(bodies) * float64(bodies),
+		FrameTimeMs:           percentiles(frameTimes),
+	}, nil
+}
+
+func percentiles(durations []time.Duration) Percentiles {
+	if len(durations) == 0 {
+		return Percentiles{}
+	}
+
+	sorted := slices.Clone(durations)
+	slices.Sort(sorted)
+
+	at := func(p float64) float64 {
+		idx := int(p*float64(len(sorted))+0.5) - 1
+		idx = max(0, min(idx, len(sorted)-1))
+		return float64(sorted[idx]) / float64(time.Millisecond)
+	}
+
+	return Percentiles{
+		P50: at(0.50),
+		P90: at(0.90),
+		P99: at(0.99),
+		Max: float64(sorted[len(sorted)-1]) / float64(time.Millisecond),
+	}
+}
+
+func revision() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+
+	for _, setting := range info.Settings {
+		if setting.Key == "vcs.revision" {
+			return setting.Value
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,59 @@
+package bench
+
+import (
+	"fmt"
+	"game/app"
+	"testing"
+	"time"
+)
+
+// BenchmarkGravity simulates frames of 16 steps of the generated scenes of
+// StandardSizes. It is skipped without a Vulkan device.
+func BenchmarkGravity(b *testing.B) {
+	const stepsPerFrame = 16
+
+	for _, size := range StandardSizes {
+		b.Run(fmt.Sprintf("bodies=%d", size), func(b *testing.B) {
+			config := app.DefaultConfig
+			config.Bodies = size
+			config.Seed = 1
+			a, err := app.NewHeadless(config)
+			if err != nil {
+				b.Skipf("no device: %v", err)
+			}
+			defer a.Close()
+
+			// first submission pays for pipeline warm-up
+			dt := config.Gravity.Dt
+			if err := a.Step(stepsPerFrame, dt); err != nil {
+				b.Fatal(err)
+			}
+
+			b.ResetTimer()
+			for range b.N {
+				if err := a.Step(stepsPerFrame, dt); err != nil {
+					b.Fatal(err)
+				}
+			}
+			steps := float64(stepsPerFrame * b.N)
+			b.ReportMetric(steps/b.Elapsed().Seconds(), "steps/s")
+			b.ReportMetric(steps*float64(size*size)/b.Elapsed().Seconds(), "interactions/s")
+		})
+	}
+}
+
+func TestPercentiles(t *testing.T) {
+	var durations []time.Duration
+	for i := range 100 {
+		durations = append(durations, time.Duration(100-i)*time.Millisecond)
+	}
+
+	got := percentiles(durations)
+	want := Percentiles{P50: 50, P90: 90, P99: 99, Max: 100}
+	if got != want {
+		t.Errorf("percentiles = %+v, want %+v", got, want)
+	}
+	if got := percentiles(nil); got != (Percentiles{}) {
+		t.Errorf("percentiles of nothing = %+v", got)
+	}
+}
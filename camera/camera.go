@@ -0,0 +1,246 @@
+// Package camera maps world coordinates to clip space for the scene
+// pipelines, which read the transform from a uniform buffer in descriptor
+// set 1.
+package camera
+
+import (
+	"fmt"
+	"game/device"
+	"game/layout"
+	"game/shader"
+	"math"
+	"unsafe"
+
+	"github.com/goki/vulkan"
+)
+
+// Set is the descriptor set index the camera is bound to.
+const Set = 1
+
+// Uniform is the uniform buffer block at binding 0 of Set.
+type Uniform struct {
+	ViewProjection [16]float32 `layout:"mat4"`
+}
+
+var uniformLayout = layout.Must[Uniform](layout.Std140)
+
+// limits of Zoom, past which float32 world coordinates stop being useful
+const (
+	minZoom = 1e-3
+	maxZoom = 1e4
+)
+
+// Camera shows 2/Zoom world units around Center along the shorter side of
+// the viewport, so that the world keeps its aspect ratio when the window is
+// resized. Center is relative to the reference frame set by Track.
+type Camera struct {
+	Center [2]float32
+	Zoom   float32
+	Follow Follow
+
+	extent vulkan.Extent2D
+	// reference frame, the world position at its origin and its rotation
+	origin [2]float32
+	angle  float32
+
+	device *device.Device
+	// Layout is the layout of the descriptor sets returned by Update.
+	Layout   vulkan.DescriptorSetLayout
+	pool     vulkan.DescriptorPool
+	sets     []vulkan.DescriptorSet
+	buffers  []vulkan.Buffer
+	memories []*device.Allocation
+	mapped   []unsafe.Pointer
+}
+
+// New creates a camera looking at the origin with one uniform buffer per
+// frame in flight.
+func New(d *device.Device, framesInFlight int, extent vulkan.Extent2D) (*Camera, error) {
+	c := &Camera{
+		Zoom:     1,
+		extent:   extent,
+		device:   d,
+		sets:     make([]vulkan.DescriptorSet, framesInFlight),
+		buffers:  make([]vulkan.Buffer, framesInFlight),
+		memories: make([]*device.Allocation, framesInFlight),
+		mapped:   make([]unsafe.Pointer, framesInFlight),
+	}
+	if err := c.init(); err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (c *Camera) init() error {
+	d := c.device
+
+	if err := device.Check(vulkan.CreateDescriptorSetLayout(d.LogicalDevice, &vulkan.DescriptorSetLayoutCreateInfo{
+		SType:        vulkan.StructureTypeDescriptorSetLayoutCreateInfo,
+		BindingCount: 1,
+		PBindings: []vulkan.DescriptorSetLayoutBinding{
+			{
+				Binding:         0,
+				DescriptorCount: 1,
+				DescriptorType:  vulkan.DescriptorTypeUniformBuffer,
+				StageFlags:      vulkan.ShaderStageFlags(vulkan.ShaderStageVertexBit),
+			},
+		},
+	}, nil, &c.Layout), "create camera descriptor set layout"); err != nil {
+		return err
+	}
+
+	if err := device.Check(vulkan.CreateDescriptorPool(d.LogicalDevice, &vulkan.DescriptorPoolCreateInfo{
+		SType:         vulkan.StructureTypeDescriptorPoolCreateInfo,
+		PoolSizeCount: 1,
+		PPoolSizes: []vulkan.DescriptorPoolSize{
+			{
+				Type:            vulkan.DescriptorTypeUniformBuffer,
+				DescriptorCount: uint32(len(c.sets)),
+			},
+		},
+		MaxSets: uint32(len(c.sets)),
+	}, nil, &c.pool), "create camera descriptor pool"); err != nil {
+		return err
+	}
+
+	for i := range c.sets {
+		var err error
+		c.buffers[i], c.memories[i], err = d.CreateBuffer(
+			vulkan.DeviceSize(uniformLayout.Size),
+			vulkan.BufferUsageFlags(vulkan.BufferUsageUniformBufferBit),
+			vulkan.MemoryPropertyFlags(vulkan.MemoryPropertyHostVisibleBit|vulkan.MemoryPropertyHostCoherentBit),
+		)
+		if err != nil {
+			return err
+		}
+		d.NameBuffer(c.buffers[i], fmt.Sprintf("camera[frame %d]", i))
+		if c.mapped[i], err = d.Map(c.memories[i]); err != nil {
+			return err
+		}
+
+		if err := device.Check(vulkan.AllocateDescriptorSets(d.LogicalDevice, &vulkan.DescriptorSetAllocateInfo{
+			SType:              vulkan.StructureTypeDescriptorSetAllocateInfo,
+			DescriptorPool:     c.pool,
+			DescriptorSetCount: 1,
+			PSetLayouts:        []vulkan.DescriptorSetLayout{c.Layout},
+		}, &c.sets[i]), "allocate camera descriptor set"); err != nil {
+			return err
+		}
+		d.NameDescriptorSet(c.sets[i], fmt.Sprintf("camera[frame %d]", i))
+
+		vulkan.UpdateDescriptorSets(d.LogicalDevice, 1, []vulkan.WriteDescriptorSet{
+			{
+				SType:           vulkan.StructureTypeWriteDescriptorSet,
+				DstSet:          c.sets[i],
+				DstBinding:      0,
+				DstArrayElement: 0,
+				DescriptorType:  vulkan.DescriptorTypeUniformBuffer,
+				DescriptorCount: 1,
+				PBufferInfo: []vulkan.DescriptorBufferInfo{
+					{
+						Buffer: c.buffers[i],
+						Offset: 0,
+						Range:  vulkan.DeviceSize(uniformLayout.Size),
+					},
+				},
+			},
+		}, 0, nil)
+	}
+
+	return nil
+}
+
+// SetExtent updates the aspect ratio to the size of the render target.
+func (c *Camera) SetExtent(extent vulkan.Extent2D) {
+	c.extent = extent
+}
+
+// halfSize returns the world distance from the center to the edges of the
+// viewport.
+func (c *Camera) halfSize() [2]float32 {
+	width, height := float32(max(c.extent.Width, 1)), float32(max(c.extent.Height, 1))
+	shorter := min(width, height)
+
+	return [2]float32{width / shorter / c.Zoom, height / shorter / c.Zoom}
+}
+
+// ViewProjection returns the column major world to clip space transform.
+func (c *Camera) ViewProjection() [16]float32 {
+	half := c.halfSize()
+	sin, cos := math.Sincos(float64(c.angle))
+	s, co := float32(sin), float32(cos)
+	// the world is rotated by -angle around origin
+	x := -(co*c.origin[0] + s*c.origin[1]) - c.Center[0]
+	y := (s*c.origin[0] - co*c.origin[1]) - c.Center[1]
+
+	return [16]float32{
+		co / half[0], -s / half[1], 0, 0,
+		s / half[0], co / half[1], 0, 0,
+		0, 0, 1, 0,
+		x / half[0], y / half[1], 0, 1,
+	}
+}
+
+// ToWorld returns the world position at normalized device coordinates.
+func (c *Camera) ToWorld(ndc [2]float32) [2]float32 {
+	half := c.halfSize()
+	x, y := c.Center[0]+ndc[0]*half[0], c.Center[1]+ndc[1]*half[1]
+	sin, cos := math.Sincos(float64(c.angle))
+	s, co := float32(sin), float32(cos)
+
+	return [2]float32{c.origin[0] + co*x - s*y, c.origin[1] + s*x + co*y}
+}
+
+// ZoomAt multiplies Zoom by factor, keeping the world position under ndc in
+// place.
+func (c *Camera) ZoomAt(factor float32, ndc [2]float32) {
+	half := c.halfSize()
+	anchor := [2]float32{c.Center[0] + ndc[0]*half[0], c.Center[1] + ndc[1]*half[1]}
+	c.Zoom = min(max(c.Zoom*factor, minZoom), maxZoom)
+
+	half = c.halfSize()
+	c.Center = [2]float32{anchor[0] - ndc[0]*half[0], anchor[1] - ndc[1]*half[1]}
+}
+
+// Pan moves the view so that the world follows a cursor moved from one
+// position to another, both in normalized device coordinates.
+func (c *Camera) Pan(from, to [2]float32) {
+	half := c.halfSize()
+	c.Center[0] -= (to[0] - from[0]) * half[0]
+	c.Center[1] -= (to[1] - from[1]) * half[1]
+}
+
+// Update writes the current transform to the uniform buffer of frameIdx and
+// returns the descriptor set to bind at Set.
+func (c *Camera) Update(frameIdx uint32) vulkan.DescriptorSet {
+	data := uniformLayout.Encode([]Uniform{{ViewProjection: c.ViewProjection()}})
+	copy(unsafe.Slice((*byte)(c.mapped[frameIdx]), len(data)), data)
+
+	return c.sets[frameIdx]
+}
+
+// CheckLayout compares the camera block of a shader with Uniform.
+func CheckLayout(module *shader.Module) error {
+	b := module.Binding(Set, 0)
+	if b == nil {
+		return fmt.Errorf("no camera uniform buffer at set %d binding 0", Set)
+	}
+	if err := shader.CheckBlock(b.Block, &uniformLayout.Block); err != nil {
+		return fmt.Errorf("camera uniform buffer does not match: %w", err)
+	}
+
+	return nil
+}
+
+// Close releases the uniform buffers and descriptor sets. It is safe to
+// call on a partially constructed camera.
+func (c *Camera) Close() {
+	for i := range c.buffers {
+		vulkan.DestroyBuffer(c.device.LogicalDevice, c.buffers[i], nil)
+		c.device.Free(c.memories[i])
+	}
+	vulkan.DestroyDescriptorPool(c.device.LogicalDevice, c.pool, nil)
+	vulkan.DestroyDescriptorSetLayout(c.device.LogicalDevice, c.Layout, nil)
+}
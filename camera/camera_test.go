@@ -0,0 +1,115 @@
+package camera
+
+import (
+	"math"
+	"testing"
+
+	"github.com/goki/vulkan"
+)
+
+// cameras are the views the tests run against: square and non-square
+// extents, with and without a rotated reference frame.
+func cameras() map[string]*Camera {
+	return map[string]*Camera{
+		"square": {Zoom: 1, extent: vulkan.Extent2D{Width: 600, Height: 600}},
+		"wide": {
+			Center: [2]float32{0.3, -0.2},
+			Zoom:   2.5,
+			extent: vulkan.Extent2D{Width: 1280, Height: 720},
+		},
+		"tall rotated": {
+			Center: [2]float32{-1, 0.5},
+			Zoom:   0.4,
+			extent: vulkan.Extent2D{Width: 480, Height: 800},
+			origin: [2]float32{3, -2},
+			angle:  0.7,
+		},
+	}
+}
+
+// project applies the column major view projection to a world position.
+func project(m [16]float32, world [2]float32) [2]float32 {
+	return [2]float32{
+		m[0]*world[0] + m[4]*world[1] + m[12],
+		m[1]*world[0] + m[5]*world[1] + m[13],
+	}
+}
+
+func near(a, b [2]float32) bool {
+	const tolerance = 1e-4
+	return math.Abs(float64(a[0]-b[0])) < tolerance && math.Abs(float64(a[1]-b[1])) < tolerance
+}
+
+var points = [][2]float32{{0, 0}, {1, 1}, {-1, 1}, {0.25, -0.75}}
+
+func TestToWorldInvertsViewProjection(t *testing.T) {
+	for name, c := range cameras() {
+		vp := c.ViewProjection()
+		for _, ndc := range points {
+			world := c.ToWorld(ndc)
+			if got := project(vp, world); !near(got, ndc) {
+				t.Errorf("%s: %v maps to world %v, which projects to %v", name, ndc, world, got)
+			}
+		}
+	}
+}
+
+func TestViewProjectionAspect(t *testing.T) {
+	// the shorter side spans 2/Zoom world units and the longer side more,
+	// so circles stay round
+	c := cameras()["wide"]
+	a, b := c.ToWorld([2]float32{-1, -1}), c.ToWorld([2]float32{1, 1})
+	width, height := b[0]-a[0], b[1]-a[1]
+	if math.Abs(float64(height-2/c.Zoom)) > 1e-5 {
+		t.Errorf("height = %g, want %g", height, 2/c.Zoom)
+	}
+	if math.Abs(float64(width/height-1280.0/720.0)) > 1e-5 {
+		t.Errorf("aspect = %g, want %g", width/height, 1280.0/720.0)
+	}
+}
+
+func TestZoomAtKeepsCursor(t *testing.T) {
+	for name, c := range cameras() {
+		for _, ndc := range points {
+			for _, factor := range []float32{1.25, 0.5} {
+				before := c.ToWorld(ndc)
+				zoom := c.Zoom
+				c.ZoomAt(factor, ndc)
+				if c.Zoom != zoom*factor {
+					t.Errorf("%s: zoom = %g, want %g", name, c.Zoom, zoom*factor)
+				}
+				if after := c.ToWorld(ndc); !near(after, before) {
+					t.Errorf("%s: zooming by %g at %v moved %v to %v", name, factor, ndc, before, after)
+				}
+			}
+		}
+	}
+}
+
+func TestZoomAtClamps(t *testing.T) {
+	c := cameras()["square"]
+	c.ZoomAt(1e9, [2]float32{0.5, 0.5})
+	if c.Zoom != maxZoom {
+		t.Errorf("zoom = %g, want %g", c.Zoom, float32(maxZoom))
+	}
+	c.ZoomAt(1e-12, [2]float32{0.5, 0.5})
+	if c.Zoom != minZoom {
+		t.Errorf("zoom = %g, want %g", c.Zoom, float32(minZoom))
+	}
+}
+
+func TestPanFollowsCursor(t *testing.T) {
+	for name, c := range cameras() {
+		from, to := [2]float32{0.1, -0.3}, [2]float32{-0.4, 0.2}
+		grabbed := c.ToWorld(from)
+		c.Pan(from, to)
+
+		// the world point grabbed at from is now under to
+		if got := c.ToWorld(to); !near(got, grabbed) {
+			t.Errorf("%s: %v is under the cursor after the pan, want %v", name, got, grabbed)
+		}
+		if got := project(c.ViewProjection(), grabbed); !near(got, to) {
+			t.Errorf("%s: grabbed point projects to %v, want %v", name, got, to)
+		}
+	}
+}
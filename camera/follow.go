@@ -0,0 +1,117 @@
+package camera
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"game/gravity"
+	"math"
+	"slices"
+)
+
+type FollowMode int
+
+const (
+	// FollowNone keeps the reference frame at the world origin.
+	FollowNone FollowMode = iota
+	// FollowCenterOfMass moves the reference frame with the centre of mass
+	// of all bodies.
+	FollowCenterOfMass
+	// FollowBody moves the reference frame with Follow.Body.
+	FollowBody
+)
+
+// Follow selects the reference frame the camera looks at.
+type Follow struct {
+	Mode FollowMode
+	// Body is the index of the followed body in upload order.
+	Body int
+	// CoRotating rotates the frame with the line between the two heaviest
+	// bodies, so that a binary stays still. Without another mode the frame
+	// is centred on their barycentre.
+	CoRotating bool
+}
+
+// Check reports whether f can be followed in a scene of bodies bodies.
+func (f Follow) Check(bodies int) error {
+	if f.Mode != FollowNone && bodies == 0 {
+		return errors.New("no bodies to follow")
+	}
+	if f.Mode == FollowBody && (f.Body < 0 || f.Body >= bodies) {
+		return fmt.Errorf("cannot follow body %d of %d", f.Body, bodies)
+	}
+	if f.CoRotating && bodies < 2 {
+		return errors.New("a co-rotating frame needs two bodies")
+	}
+
+	return nil
+}
+
+// Track moves the reference frame to the bodies of snapshot, extrapolated
+// by their velocities to the simulated time now, which hides the latency
+// of the readback. Follow has to pass Check for the snapshot.
+func (c *Camera) Track(snapshot gravity.Snapshot, now float64) {
+	if c.Follow.Check(len(snapshot.Bodies)) != nil || (c.Follow.Mode == FollowNone && !c.Follow.CoRotating) {
+		c.origin, c.angle = [2]float32{}, 0
+		return
+	}
+
+	lag := float32(now - snapshot.Time)
+	positions := make([][2]float32, len(snapshot.Bodies))
+	for i, body := range snapshot.Bodies {
+		p, v := body.Position(), body.Velocity()
+		positions[i] = [2]float32{p[0] + v[0]*lag, p[1] + v[1]*lag}
+	}
+
+	origin := [2]float32{}
+	switch c.Follow.Mode {
+	case FollowCenterOfMass:
+		origin = centerOfMass(snapshot.Bodies, positions)
+	case FollowBody:
+		origin = positions[c.Follow.Body]
+	}
+
+	angle := float32(0)
+	if c.Follow.CoRotating {
+		pair := heaviestPair(snapshot.Bodies)
+		a, b := positions[pair[0]], positions[pair[1]]
+		angle = float32(math.Atan2(float64(b[1]-a[1]), float64(b[0]-a[0])))
+		if c.Follow.Mode == FollowNone {
+			origin = centerOfMass(
+				[]gravity.ObjectWithMass{snapshot.Bodies[pair[0]], snapshot.Bodies[pair[1]]},
+				[][2]float32{a, b},
+			)
+		}
+	}
+
+	c.origin, c.angle = origin, angle
+}
+
+func centerOfMass(bodies []gravity.ObjectWithMass, positions [][2]float32) [2]float32 {
+	var center [2]float32
+	var total float32
+	for i, body := range bodies {
+		center[0] += body.Mass() * positions[i][0]
+		center[1] += body.Mass() * positions[i][1]
+		total += body.Mass()
+	}
+	if total == 0 {
+		return [2]float32{}
+	}
+
+	return [2]float32{center[0] / total, center[1] / total}
+}
+
+// heaviestPair returns the indices of the two heaviest bodies, heaviest
+// first.
+func heaviestPair(bodies []gravity.ObjectWithMass) [2]int {
+	indices := make([]int, len(bodies))
+	for i := range indices {
+		indices[i] = i
+	}
+	slices.SortStableFunc(indices, func(a, b int) int {
+		return cmp.Compare(bodies[b].Mass(), bodies[a].Mass())
+	})
+
+	return [2]int{indices[0], indices[1]}
+}
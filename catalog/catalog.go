@@ -0,0 +1,48 @@
+package catalog
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"game/model"
+)
+
+// Body is a mass body read from a catalog, projected onto the simulation's
+// XY plane and converted to simulation units.
+type Body struct {
+	Position [2]float32
+	Mass     model.MassModel
+}
+
+// Load reads every file as either a Horizons vector table or a CSV catalog
+// and numbers the resulting bodies in order.
+func Load(units Units, paths ...string) ([]Body, error) {
+	var bodies []Body
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		var fileBodies []Body
+		if bytes.Contains(data, []byte(startOfEphemeris)) {
+			var body Body
+			body, err = ReadHorizons(bytes.NewReader(data), units)
+			fileBodies = []Body{body}
+		} else {
+			fileBodies, err = ReadCSV(bytes.NewReader(data), units)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+
+		bodies = append(bodies, fileBodies...)
+	}
+
+	for i := range bodies {
+		bodies[i].Mass.ID = i
+	}
+
+	return bodies, nil
+}
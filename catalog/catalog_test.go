@@ -0,0 +1,225 @@
+package catalog
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// approx reports whether got is within a relative tolerance of want, float32
+// precision being the best the simulation units can hold.
+func approx(got float32, want float64) bool {
+	if want == 0 {
+		return math.Abs(float64(got)) < 1e-12
+	}
+
+	return math.Abs(float64(got)-want) <= 1e-6*math.Abs(want)
+}
+
+type wantBody struct {
+	name     string
+	position [2]float64 // metres
+	velocity [2]float64 // metres per second
+	massKg   float64
+	hidden   bool
+}
+
+func checkBody(t *testing.T, got Body, want wantBody) {
+	t.Helper()
+
+	u := DefaultUnits
+	if got.Mass.Name != want.name {
+		t.Errorf("name = %q, want %q", got.Mass.Name, want.name)
+	}
+	for i := range 2 {
+		if p := want.position[i] / u.Length; !approx(got.Position[i], p) {
+			t.Errorf("%s position[%d] = %g, want %g", want.name, i, got.Position[i], p)
+		}
+		if v := want.velocity[i] * u.Time() / u.Length; !approx(got.Mass.Velocity[i], v) {
+			t.Errorf("%s velocity[%d] = %g, want %g", want.name, i, got.Mass.Velocity[i], v)
+		}
+	}
+	if m := want.massKg / u.Mass; !approx(got.Mass.Mass, m) {
+		t.Errorf("%s mass = %g (%g kg), want %g (%g kg)", want.name, got.Mass.Mass, float64(got.Mass.Mass)*u.Mass, m, want.massKg)
+	}
+	if got.Mass.HideTrail != want.hidden {
+		t.Errorf("%s HideTrail = %t, want %t", want.name, got.Mass.HideTrail, want.hidden)
+	}
+}
+
+func TestReadHorizons(t *testing.T) {
+	tests := []struct {
+		file string
+		want wantBody
+	}{
+		{
+			// text layout in AU and days, mass from the GM entry and not
+			// the GM 1-sigma that follows it
+			file: "earth-vectors-au-d.txt",
+			want: wantBody{
+				name:     "Earth",
+				position: [2]float64{-1.749585204127261e-01 * AU, 9.667633024641036e-01 * AU},
+				velocity: [2]float64{-1.720187699426022e-02 * AU / Day, -3.158734865721484e-03 * AU / Day},
+				massKg:   398600.435436e9 / G,
+			},
+		},
+		{
+			// CSV_FORMAT=YES in km and seconds, GM written as GM (km^3/s^2)
+			file: "mars-vectors-km-s.csv",
+			want: wantBody{
+				name:     "Mars",
+				position: [2]float64{2.187146098718434e+07 * 1e3, 2.300207683040744e+08 * 1e3},
+				velocity: [2]float64{-2.318620718016427e+01 * 1e3, 3.954436573474286e+00 * 1e3},
+				massKg:   42828.375214e9 / G,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.file, func(t *testing.T) {
+			f, err := os.Open(filepath.Join("testdata", test.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			body, err := ReadHorizons(f, DefaultUnits)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkBody(t, body, test.want)
+		})
+	}
+}
+
+func TestReadHorizonsEarthMass(t *testing.T) {
+	f, err := os.Open(filepath.Join("testdata", "earth-vectors-au-d.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	body, err := ReadHorizons(f, DefaultUnits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the header also gives Mass x10^24 (kg) = 5.97219
+	if kg := float64(body.Mass.Mass) * SolarMass; math.Abs(kg/5.97219e24-1) > 1e-3 {
+		t.Errorf("Earth mass = %g kg, want about 5.97219e24 kg", kg)
+	}
+}
+
+func TestReadHorizonsNoEphemeris(t *testing.T) {
+	_, err := ReadHorizons(strings.NewReader("Target body name: Earth (399)\n$$SOE\n$$EOE\n"), DefaultUnits)
+	if err != ErrNoEphemeris {
+		t.Errorf("err = %v, want %v", err, ErrNoEphemeris)
+	}
+}
+
+func TestReadCSV(t *testing.T) {
+	tests := []struct {
+		name    string
+		catalog string
+		want    []wantBody
+	}{
+		{
+			name:    "km-s by default",
+			catalog: "name,mass,x,y,vx,vy\nProbe,1000,1.5e8,-2e7,10,-20\n",
+			want: []wantBody{
+				{name: "Probe", position: [2]float64{1.5e11, -2e10}, velocity: [2]float64{1e4, -2e4}, massKg: 1000},
+			},
+		},
+		{
+			name:    "units comment",
+			catalog: "# units: M-S\nName,Mass,X,Y,VX,VY\nRock,5,3,4,0.5,0.25\n",
+			want: []wantBody{
+				{name: "Rock", position: [2]float64{3, 4}, velocity: [2]float64{0.5, 0.25}, massKg: 5},
+			},
+		},
+		{
+			name:    "trail column",
+			catalog: "# units: M-S\nname,mass,x,y,vx,vy,trail\nA,1,0,0,0,0,false\nB,1,0,0,0,0,true\nC,1,0,0,0,0\n",
+			want: []wantBody{
+				{name: "A", massKg: 1, hidden: true},
+				{name: "B", massKg: 1},
+				{name: "C", massKg: 1},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			bodies, err := ReadCSV(strings.NewReader(test.catalog), DefaultUnits)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(bodies) != len(test.want) {
+				t.Fatalf("got %d bodies, want %d", len(bodies), len(test.want))
+			}
+			for i, want := range test.want {
+				checkBody(t, bodies[i], want)
+			}
+		})
+	}
+}
+
+func TestReadCSVErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		catalog string
+	}{
+		{"no header", "# units: AU-D\n"},
+		{"missing column", "name,mass,x,y,vx\nA,1,0,0,0\n"},
+		{"bad number", "name,mass,x,y,vx,vy\nA,heavy,0,0,0,0\n"},
+		{"bad units", "# units: parsec-year\nname,mass,x,y,vx,vy\n"},
+		{"bad trail", "name,mass,x,y,vx,vy,trail\nA,1,0,0,0,0,maybe\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := ReadCSV(strings.NewReader(test.catalog), DefaultUnits); err == nil {
+				t.Error("no error")
+			}
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	bodies, err := Load(DefaultUnits,
+		filepath.Join("testdata", "catalog.csv"),
+		filepath.Join("testdata", "earth-vectors-au-d.txt"),
+		filepath.Join("testdata", "mars-vectors-km-s.csv"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []wantBody{
+		{name: "Sun", massKg: 1.98847e30},
+		{
+			name:     "Earth",
+			position: [2]float64{-0.1749585204127261 * AU, 0.9667633024641036 * AU},
+			velocity: [2]float64{-0.01720187699426022 * AU / Day, -0.003158734865721484 * AU / Day},
+			massKg:   5.97219e24,
+		},
+		{name: "Test particle", position: [2]float64{2 * AU, 0}, velocity: [2]float64{0, 0.01 * AU / Day}},
+		{name: "Earth"},
+		{name: "Mars"},
+	}
+	if len(bodies) != len(want) {
+		t.Fatalf("got %d bodies, want %d", len(bodies), len(want))
+	}
+	for i, body := range bodies {
+		if body.Mass.ID != i {
+			t.Errorf("%s has ID %d, want %d", body.Mass.Name, body.Mass.ID, i)
+		}
+		if body.Mass.Name != want[i].name {
+			t.Errorf("body %d is %q, want %q", i, body.Mass.Name, want[i].name)
+		}
+	}
+	for _, i := range []int{0, 1, 2} {
+		checkBody(t, bodies[i], want[i])
+	}
+}
@@ -0,0 +1,133 @@
+package catalog
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ReadCSV parses a catalog with a header row naming the columns
+// name, mass, x, y, vx, vy and optionally z, vz and trail, a boolean that
+// hides the trail of the body when false. Masses are in kilograms;
+// positions and velocities are in km and km/s unless a "# units: AU-D"
+// style comment (AU-D, KM-S, KM-D or M-S) precedes the header.
+func ReadCSV(r io.Reader, units Units) ([]Body, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	from := kmPerSecond
+	var columns map[string]int
+	var bodies []Body
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if len(record) == 0 || record[0] == "" && len(record) == 1 {
+			continue
+		}
+
+		if strings.HasPrefix(record[0], "#") {
+			directive := strings.TrimSpace(strings.TrimPrefix(strings.Join(record, ","), "#"))
+			if key, value, ok := strings.Cut(directive, ":"); ok && strings.EqualFold(strings.TrimSpace(key), "units") {
+				if from, err = parseSourceUnits(value); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+
+		if columns == nil {
+			if columns, err = parseHeader(record); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		line, _ := reader.FieldPos(0)
+		body, err := parseBody(columns, record, from, units)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		bodies = append(bodies, body)
+	}
+
+	if columns == nil {
+		return nil, errors.New("catalog has no header row")
+	}
+
+	return bodies, nil
+}
+
+func parseHeader(record []string) (map[string]int, error) {
+	columns := map[string]int{}
+	for i, column := range record {
+		columns[strings.ToLower(strings.TrimSpace(column))] = i
+	}
+
+	for _, required := range []string{"name", "mass", "x", "y", "vx", "vy"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("catalog header has no %q column", required)
+		}
+	}
+
+	return columns, nil
+}
+
+func parseBody(columns map[string]int, record []string, from sourceUnits, units Units) (Body, error) {
+	value := func(column string) (float64, error) {
+		i, ok := columns[column]
+		if !ok || i >= len(record) || strings.TrimSpace(record[i]) == "" {
+			if column == "z" || column == "vz" {
+				return 0, nil
+			}
+			return 0, fmt.Errorf("missing %s", column)
+		}
+		v, err := strconv.ParseFloat(strings.TrimSpace(record[i]), 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s: %w", column, err)
+		}
+		return v, nil
+	}
+
+	massKg, err := value("mass")
+	if err != nil {
+		return Body{}, err
+	}
+
+	var s state
+	for i, column := range []string{"x", "y", "z"} {
+		if s.position[i], err = value(column); err != nil {
+			return Body{}, err
+		}
+	}
+	for i, column := range []string{"vx", "vy", "vz"} {
+		if s.velocity[i], err = value(column); err != nil {
+			return Body{}, err
+		}
+	}
+
+	name := ""
+	if i := columns["name"]; i < len(record) {
+		name = strings.TrimSpace(record[i])
+	}
+
+	body := s.body(name, massKg, from, units)
+	if i, ok := columns["trail"]; ok && i < len(record) && strings.TrimSpace(record[i]) != "" {
+		trail, err := strconv.ParseBool(strings.TrimSpace(record[i]))
+		if err != nil {
+			return Body{}, fmt.Errorf("invalid trail: %w", err)
+		}
+		body.Mass.HideTrail = !trail
+	}
+
+	return body, nil
+}
@@ -0,0 +1,183 @@
+package catalog
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	startOfEphemeris = "$$SOE"
+	endOfEphemeris   = "$$EOE"
+)
+
+var (
+	numberPattern = `~?\s*([-+]?[0-9]*\.?[0-9]+(?:[eE][-+]?[0-9]+)?)`
+
+	targetNamePattern  = regexp.MustCompile(`^\s*Target body name:\s*(.+?)\s*(?:\(|\{|$)`)
+	outputUnitsPattern = regexp.MustCompile(`^\s*Output units\s*:\s*([A-Za-z]+-[A-Za-z]+)`)
+	// "GM, km^3/s^2 = " or "GM (km^3/s^2) = ", but not the "GM 1-sigma"
+	// uncertainty that follows it
+	gmPattern        = regexp.MustCompile(`\bGM(?:,\s*|\s*\()km\^3/s\^2\)?\s*=\s*` + numberPattern)
+	massPattern      = regexp.MustCompile(`Mass[,\s]*x?\s*10\^\s*([0-9]+)\s*\(?kg\)?\s*=\s*` + numberPattern)
+	componentPattern = regexp.MustCompile(`\b(X|Y|Z|VX|VY|VZ)\s*=\s*([-+]?[0-9]*\.?[0-9]+(?:[eE][-+]?[0-9]+)?)`)
+)
+
+var ErrNoEphemeris = errors.New("no ephemeris records between $$SOE and $$EOE")
+
+// ReadHorizons parses a JPL Horizons VECTORS table, either in the default
+// text layout or with CSV_FORMAT=YES, and returns the body at the first
+// epoch. The mass comes from the GM or mass entry of the physical data
+// header; bodies without one are returned massless.
+func ReadHorizons(r io.Reader, units Units) (Body, error) {
+	var (
+		name     string
+		massKg   float64
+		hasGM    bool
+		from     = kmPerSecond
+		header   string
+		inTable  bool
+		current  state
+		found    = map[string]bool{}
+		finished bool
+	)
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() && !finished {
+		line := scanner.Text()
+
+		if !inTable {
+			switch {
+			case strings.HasPrefix(strings.TrimSpace(line), startOfEphemeris):
+				inTable = true
+			case targetNamePattern.MatchString(line):
+				name = targetNamePattern.FindStringSubmatch(line)[1]
+			case outputUnitsPattern.MatchString(line):
+				var err error
+				if from, err = parseSourceUnits(outputUnitsPattern.FindStringSubmatch(line)[1]); err != nil {
+					return Body{}, err
+				}
+			}
+
+			if m := gmPattern.FindStringSubmatch(line); m != nil && !hasGM {
+				gm, err := strconv.ParseFloat(m[1], 64)
+				if err != nil {
+					return Body{}, fmt.Errorf("invalid GM %q: %w", m[1], err)
+				}
+				massKg = gm * 1e9 / G
+				hasGM = true
+			} else if m := massPattern.FindStringSubmatch(line); m != nil && massKg == 0 {
+				exponent, _ := strconv.Atoi(m[1])
+				mantissa, err := strconv.ParseFloat(m[2], 64)
+				if err != nil {
+					return Body{}, fmt.Errorf("invalid mass %q: %w", m[2], err)
+				}
+				massKg = mantissa * math.Pow(10, float64(exponent))
+			}
+
+			if isCSVHeader(line) {
+				header = line
+			}
+			continue
+		}
+
+		if strings.HasPrefix(strings.TrimSpace(line), endOfEphemeris) {
+			break
+		}
+
+		if strings.Count(line, ",") >= 6 {
+			var err error
+			if current, err = parseCSVRecord(header, line); err != nil {
+				return Body{}, err
+			}
+			finished = true
+			continue
+		}
+
+		for _, m := range componentPattern.FindAllStringSubmatch(line, -1) {
+			value, err := strconv.ParseFloat(m[2], 64)
+			if err != nil {
+				return Body{}, fmt.Errorf("invalid %s %q: %w", m[1], m[2], err)
+			}
+			switch m[1] {
+			case "X":
+				current.position[0] = value
+			case "Y":
+				current.position[1] = value
+			case "Z":
+				current.position[2] = value
+			case "VX":
+				current.velocity[0] = value
+			case "VY":
+				current.velocity[1] = value
+			case "VZ":
+				current.velocity[2] = value
+			}
+			found[m[1]] = true
+		}
+		finished = len(found) == 6
+	}
+	if err := scanner.Err(); err != nil {
+		return Body{}, err
+	}
+
+	if !finished {
+		return Body{}, ErrNoEphemeris
+	}
+
+	return current.body(name, massKg, from, units), nil
+}
+
+func isCSVHeader(line string) bool {
+	columns := map[string]bool{}
+	for _, column := range strings.Split(line, ",") {
+		columns[strings.TrimSpace(column)] = true
+	}
+
+	return columns["X"] && columns["VX"]
+}
+
+func parseCSVRecord(header string, line string) (state, error) {
+	columns := map[string]int{"X": 2, "Y": 3, "Z": 4, "VX": 5, "VY": 6, "VZ": 7}
+	if header != "" {
+		for i, column := range strings.Split(header, ",") {
+			column = strings.TrimSpace(column)
+			if _, ok := columns[column]; ok {
+				columns[column] = i
+			}
+		}
+	}
+
+	fields := strings.Split(line, ",")
+	value := func(column string) (float64, error) {
+		i := columns[column]
+		if i >= len(fields) {
+			return 0, fmt.Errorf("record has no %s column: %q", column, line)
+		}
+		v, err := strconv.ParseFloat(strings.TrimSpace(fields[i]), 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s: %w", column, err)
+		}
+		return v, nil
+	}
+
+	var s state
+	var err error
+	for i, column := range []string{"X", "Y", "Z"} {
+		if s.position[i], err = value(column); err != nil {
+			return state{}, err
+		}
+	}
+	for i, column := range []string{"VX", "VY", "VZ"} {
+		if s.velocity[i], err = value(column); err != nil {
+			return state{}, err
+		}
+	}
+
+	return s, nil
+}
@@ -0,0 +1,103 @@
+package catalog
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"game/model"
+)
+
+const (
+	G         = 6.67430e-11 // m^3 kg^-1 s^-2
+	AU        = 1.495978707e11
+	Day       = 86400.0
+	SolarMass = 1.98847e30
+
+	// SimulationG is the gravitational constant hard-coded in the compute
+	// shaders; the simulation time unit is derived from it.
+	SimulationG = 0.81
+)
+
+// Units maps simulation units to SI. The time unit is not free: it is the
+// one for which G expressed in simulation units equals SimulationG.
+type Units struct {
+	Length float64 // metres per simulation length unit
+	Mass   float64 // kilograms per simulation mass unit
+}
+
+var DefaultUnits = Units{
+	Length: AU,
+	Mass:   SolarMass,
+}
+
+// Time returns seconds per simulation time unit.
+func (u Units) Time() float64 {
+	return math.Sqrt(SimulationG * u.Length * u.Length * u.Length / (G * u.Mass))
+}
+
+func (u Units) position(metres [3]float64) [2]float32 {
+	return [2]float32{
+		float32(metres[0] / u.Length),
+		float32(metres[1] / u.Length),
+	}
+}
+
+func (u Units) velocity(metresPerSecond [3]float64) [2]float32 {
+	scale := u.Time() / u.Length
+	return [2]float32{
+		float32(metresPerSecond[0] * scale),
+		float32(metresPerSecond[1] * scale),
+	}
+}
+
+// sourceUnits describes the length and time units of an input file.
+type sourceUnits struct {
+	length float64
+	time   float64
+}
+
+var (
+	auPerDay       = sourceUnits{length: AU, time: Day}
+	kmPerSecond    = sourceUnits{length: 1e3, time: 1}
+	kmPerDay       = sourceUnits{length: 1e3, time: Day}
+	metrePerSecond = sourceUnits{length: 1, time: 1}
+)
+
+func parseSourceUnits(s string) (sourceUnits, error) {
+	switch strings.ToUpper(strings.TrimSpace(s)) {
+	case "AU-D":
+		return auPerDay, nil
+	case "KM-S":
+		return kmPerSecond, nil
+	case "KM-D":
+		return kmPerDay, nil
+	case "M-S":
+		return metrePerSecond, nil
+	}
+
+	return sourceUnits{}, fmt.Errorf("unsupported units %q", s)
+}
+
+// state is a cartesian state vector in source units.
+type state struct {
+	position [3]float64
+	velocity [3]float64
+}
+
+func (s state) body(name string, massKg float64, from sourceUnits, to Units) Body {
+	var position, velocity [3]float64
+	for i := range 3 {
+		position[i] = s.position[i] * from.length
+		velocity[i] = s.velocity[i] * from.length / from.time
+	}
+
+	return Body{
+		Position: to.position(position),
+		Mass: model.MassModel{
+			Name:     name,
+			Velocity: to.velocity(velocity),
+			Mass:     float32(massKg / to.Mass),
+		},
+	}
+}
@@ -0,0 +1,338 @@
+package device
+
+import (
+	"cmp"
+	"errors"
+	"log/slog"
+	"slices"
+	"sync"
+	"unsafe"
+
+	"github.com/goki/vulkan"
+)
+
+// memoryBlockSize is the size of the device memory blocks allocations are
+// carved from. Larger requests get a dedicated block of their own.
+const memoryBlockSize = 64 << 20
+
+// Allocation is a range of a device memory block bound to one buffer or
+// image. It is released with Device.Free.
+type Allocation struct {
+	Memory vulkan.DeviceMemory
+	Offset vulkan.DeviceSize
+	Size   vulkan.DeviceSize
+
+	block *memoryBlock
+	// the reserved range, including the padding in front of Offset
+	start    vulkan.DeviceSize
+	reserved vulkan.DeviceSize
+}
+
+type memoryRange struct {
+	offset vulkan.DeviceSize
+	size   vulkan.DeviceSize
+}
+
+type memoryBlock struct {
+	pool        *memoryPool
+	memory      vulkan.DeviceMemory
+	size        vulkan.DeviceSize
+	dedicated   bool
+	free        []memoryRange // sorted by offset
+	allocations int
+	used        vulkan.DeviceSize
+	mapped      unsafe.Pointer
+}
+
+func alignUp(offset, alignment vulkan.DeviceSize) vulkan.DeviceSize {
+	if alignment <= 1 {
+		return offset
+	}
+
+	return (offset + alignment - 1) / alignment * alignment
+}
+
+// allocate reserves size bytes at the first free range that fits them at the
+// requested alignment.
+func (b *memoryBlock) allocate(size, alignment vulkan.DeviceSize) (*Allocation, bool) {
+	for i, r := range b.free {
+		offset := alignUp(r.offset, alignment)
+		reserved := offset - r.offset + size
+		if reserved > r.size {
+			continue
+		}
+
+		if reserved == r.size {
+			b.free = slices.Delete(b.free, i, i+1)
+		} else {
+			b.free[i] = memoryRange{offset: r.offset + reserved, size: r.size - reserved}
+		}
+		b.allocations++
+		b.used += size
+
+		return &Allocation{
+			Memory:   b.memory,
+			Offset:   offset,
+			Size:     size,
+			block:    b,
+			start:    r.offset,
+			reserved: reserved,
+		}, true
+	}
+
+	return nil, false
+}
+
+// release returns a range to the free list, merging it with its neighbours.
+func (b *memoryBlock) release(a *Allocation) {
+	b.allocations--
+	b.used -= a.Size
+
+	i, _ := slices.BinarySearchFunc(b.free, a.start, func(r memoryRange, offset vulkan.DeviceSize) int {
+		return cmp.Compare(r.offset, offset)
+	})
+	b.free = slices.Insert(b.free, i, memoryRange{offset: a.start, size: a.reserved})
+
+	if i+1 < len(b.free) && b.free[i].offset+b.free[i].size == b.free[i+1].offset {
+		b.free[i].size += b.free[i+1].size
+		b.free = slices.Delete(b.free, i+1, i+2)
+	}
+	if i > 0 && b.free[i-1].offset+b.free[i-1].size == b.free[i].offset {
+		b.free[i-1].size += b.free[i].size
+		b.free = slices.Delete(b.free, i, i+1)
+	}
+}
+
+// memoryPool holds the blocks of one memory type. Buffers and optimally
+// tiled images are kept in separate pools so that bufferImageGranularity
+// never has to be considered between neighbouring allocations.
+type memoryPool struct {
+	memoryType uint32
+	linear     bool
+	blockSize  vulkan.DeviceSize
+	blocks     []*memoryBlock
+}
+
+type poolKey struct {
+	memoryType uint32
+	linear     bool
+}
+
+type allocator struct {
+	mu         sync.Mutex
+	device     vulkan.Device
+	properties vulkan.PhysicalDeviceMemoryProperties
+	pools      map[poolKey]*memoryPool
+}
+
+func newAllocator(device vulkan.Device, physicalDevice vulkan.PhysicalDevice) *allocator {
+	a := &allocator{
+		device: device,
+		pools:  make(map[poolKey]*memoryPool),
+	}
+	vulkan.GetPhysicalDeviceMemoryProperties(physicalDevice, &a.properties)
+	a.properties.Deref()
+	for i := range a.properties.MemoryTypeCount {
+		a.properties.MemoryTypes[i].Deref()
+	}
+	for i := range a.properties.MemoryHeapCount {
+		a.properties.MemoryHeaps[i].Deref()
+	}
+
+	return a
+}
+
+func (a *allocator) findMemoryType(typeFilter uint32, properties vulkan.MemoryPropertyFlags) (uint32, error) {
+	for i := range a.properties.MemoryTypeCount {
+		if typeFilter&(1<<i) != 0 && (a.properties.MemoryTypes[i].PropertyFlags&properties) == properties {
+			return i, nil
+		}
+	}
+
+	return 0, errors.New("failed to find suitable memory type")
+}
+
+func (a *allocator) pool(memoryType uint32, linear bool) *memoryPool {
+	key := poolKey{memoryType: memoryType, linear: linear}
+	if pool, ok := a.pools[key]; ok {
+		return pool
+	}
+
+	// small heaps, such as the host visible device local window, would
+	// otherwise be used up by a couple of blocks
+	heap := a.properties.MemoryHeaps[a.properties.MemoryTypes[memoryType].HeapIndex]
+	pool := &memoryPool{
+		memoryType: memoryType,
+		linear:     linear,
+		blockSize:  min(memoryBlockSize, heap.Size/8),
+	}
+	a.pools[key] = pool
+
+	return pool
+}
+
+func (a *allocator) newBlock(pool *memoryPool, size vulkan.DeviceSize, dedicated bool) (*memoryBlock, error) {
+	block := &memoryBlock{
+		pool:      pool,
+		size:      size,
+		dedicated: dedicated,
+		free:      []memoryRange{{offset: 0, size: size}},
+	}
+	if err := Check(vulkan.AllocateMemory(a.device, &vulkan.MemoryAllocateInfo{
+		SType:           vulkan.StructureTypeMemoryAllocateInfo,
+		AllocationSize:  size,
+		MemoryTypeIndex: pool.memoryType,
+	}, nil, &block.memory), "allocate memory"); err != nil {
+		return nil, err
+	}
+	pool.blocks = append(pool.blocks, block)
+
+	return block, nil
+}
+
+func (a *allocator) allocate(
+	requirements vulkan.MemoryRequirements,
+	properties vulkan.MemoryPropertyFlags,
+	linear bool,
+) (*Allocation, error) {
+	memoryType, err := a.findMemoryType(requirements.MemoryTypeBits, properties)
+	if err != nil {
+		return nil, err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	pool := a.pool(memoryType, linear)
+	if requirements.Size > pool.blockSize/2 {
+		block, err := a.newBlock(pool, requirements.Size, true)
+		if err != nil {
+			return nil, err
+		}
+		allocation, _ := block.allocate(requirements.Size, 1)
+		return allocation, nil
+	}
+
+	for _, block := range pool.blocks {
+		if block.dedicated {
+			continue
+		}
+		if allocation, ok := block.allocate(requirements.Size, requirements.Alignment); ok {
+			return allocation, nil
+		}
+	}
+
+	block, err := a.newBlock(pool, pool.blockSize, false)
+	if err != nil {
+		return nil, err
+	}
+	allocation, _ := block.allocate(requirements.Size, requirements.Alignment)
+
+	return allocation, nil
+}
+
+func (a *allocator) free(allocation *Allocation) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	block := allocation.block
+	block.release(allocation)
+	if block.allocations > 0 || !block.pool.remove(block) {
+		return
+	}
+
+	vulkan.FreeMemory(a.device, block.memory, nil)
+}
+
+// remove takes an empty block out of the pool and reports whether its memory
+// is to be freed. A single empty block per pool is kept around so that short
+// lived staging buffers do not allocate and free a block every time.
+func (p *memoryPool) remove(block *memoryBlock) bool {
+	if !block.dedicated && !slices.ContainsFunc(p.blocks, func(other *memoryBlock) bool {
+		return other != block && !other.dedicated && other.allocations == 0
+	}) {
+		return false
+	}
+
+	p.blocks = slices.DeleteFunc(p.blocks, func(other *memoryBlock) bool {
+		return other == block
+	})
+
+	return true
+}
+
+func (a *allocator) mapMemory(allocation *Allocation) (unsafe.Pointer, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	block := allocation.block
+	if block.mapped == nil {
+		if err := Check(vulkan.MapMemory(a.device, block.memory, 0, vulkan.DeviceSize(vulkan.WholeSize), 0, &block.mapped), "map memory"); err != nil {
+			return nil, err
+		}
+	}
+
+	return unsafe.Add(block.mapped, allocation.Offset), nil
+}
+
+// MemoryStats describes the device memory held for one memory type.
+type MemoryStats struct {
+	MemoryType uint32
+	// Blocks is the number of vkAllocateMemory calls currently alive.
+	Blocks      int
+	Allocations int
+	// Reserved is the size of all blocks, Used the part of it handed out.
+	Reserved uint64
+	Used     uint64
+}
+
+func (a *allocator) stats() []MemoryStats {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	byType := make(map[uint32]*MemoryStats)
+	for _, pool := range a.pools {
+		stats, ok := byType[pool.memoryType]
+		if !ok {
+			stats = &MemoryStats{MemoryType: pool.memoryType}
+			byType[pool.memoryType] = stats
+		}
+		for _, block := range pool.blocks {
+			stats.Blocks++
+			stats.Allocations += block.allocations
+			stats.Reserved += uint64(block.size)
+			stats.Used += uint64(block.used)
+		}
+	}
+
+	var result []MemoryStats
+	for _, stats := range byType {
+		if stats.Blocks > 0 {
+			result = append(result, *stats)
+		}
+	}
+	slices.SortFunc(result, func(a, b MemoryStats) int {
+		return cmp.Compare(a.MemoryType, b.MemoryType)
+	})
+
+	return result
+}
+
+func (a *allocator) close() {
+	for _, stats := range a.stats() {
+		if stats.Allocations > 0 {
+			slog.Warn("device memory still allocated on close",
+				slog.Int("memory type", int(stats.MemoryType)),
+				slog.Int("allocations", stats.Allocations),
+				slog.Uint64("bytes", stats.Used),
+			)
+		}
+	}
+
+	for _, pool := range a.pools {
+		for _, block := range pool.blocks {
+			vulkan.FreeMemory(a.device, block.memory, nil)
+		}
+		pool.blocks = nil
+	}
+}
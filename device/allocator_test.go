@@ -0,0 +1,183 @@
+package device
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/goki/vulkan"
+)
+
+func TestAllocate(t *testing.T) {
+	tests := []struct {
+		name      string
+		free      []memoryRange
+		size      vulkan.DeviceSize
+		alignment vulkan.DeviceSize
+		offset    vulkan.DeviceSize
+		ok        bool
+		want      []memoryRange
+	}{
+		{
+			name: "front of a range",
+			free: []memoryRange{{0, 1024}},
+			size: 100, alignment: 1,
+			offset: 0, ok: true,
+			want: []memoryRange{{100, 924}},
+		},
+		{
+			// the padding in front of the aligned offset stays reserved
+			name: "aligned",
+			free: []memoryRange{{10, 1014}},
+			size: 100, alignment: 256,
+			offset: 256, ok: true,
+			want: []memoryRange{{356, 668}},
+		},
+		{
+			name: "exact fit",
+			free: []memoryRange{{0, 64}, {128, 100}, {512, 512}},
+			size: 100, alignment: 4,
+			offset: 128, ok: true,
+			want: []memoryRange{{0, 64}, {512, 512}},
+		},
+		{
+			name: "exact fit with padding",
+			free: []memoryRange{{8, 108}},
+			size: 100, alignment: 16,
+			offset: 16, ok: true,
+			want: []memoryRange{},
+		},
+		{
+			// the first range is large enough unaligned but not aligned
+			name: "skip misaligned range",
+			free: []memoryRange{{8, 100}, {256, 256}},
+			size: 100, alignment: 64,
+			offset: 256, ok: true,
+			want: []memoryRange{{8, 100}, {356, 156}},
+		},
+		{
+			name: "no room",
+			free: []memoryRange{{0, 64}, {128, 64}},
+			size: 100, alignment: 1,
+			ok:   false,
+			want: []memoryRange{{0, 64}, {128, 64}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b := &memoryBlock{size: 1024, free: test.free}
+			a, ok := b.allocate(test.size, test.alignment)
+			if ok != test.ok {
+				t.Fatalf("ok = %t, want %t", ok, test.ok)
+			}
+			if ok && (a.Offset != test.offset || a.Size != test.size || a.Offset%test.alignment != 0) {
+				t.Errorf("allocation at %d of %d, want %d of %d", a.Offset, a.Size, test.offset, test.size)
+			}
+			if !reflect.DeepEqual(b.free, test.want) {
+				t.Errorf("free = %v, want %v", b.free, test.want)
+			}
+		})
+	}
+}
+
+func TestRelease(t *testing.T) {
+	// three allocations filling 0-300 of a 400 byte block
+	tests := []struct {
+		name    string
+		release []int
+		want    []memoryRange
+	}{
+		{"no neighbours", []int{1}, []memoryRange{{100, 100}, {300, 100}}},
+		{"merge after", []int{2}, []memoryRange{{200, 200}}},
+		{"merge before", []int{0, 1}, []memoryRange{{0, 200}, {300, 100}}},
+		{"merge both sides", []int{0, 2, 1}, []memoryRange{{0, 400}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b := &memoryBlock{size: 400, free: []memoryRange{{0, 400}}}
+			var allocations []*Allocation
+			for range 3 {
+				a, ok := b.allocate(100, 1)
+				if !ok {
+					t.Fatal("allocation failed")
+				}
+				allocations = append(allocations, a)
+			}
+
+			for _, i := range test.release {
+				b.release(allocations[i])
+			}
+			if !reflect.DeepEqual(b.free, test.want) {
+				t.Errorf("free = %v, want %v", b.free, test.want)
+			}
+			if b.allocations != 3-len(test.release) || b.used != vulkan.DeviceSize(100*b.allocations) {
+				t.Errorf("%d allocations using %d bytes", b.allocations, b.used)
+			}
+		})
+	}
+}
+
+func TestReuseAfterRelease(t *testing.T) {
+	b := &memoryBlock{size: 256, free: []memoryRange{{0, 256}}}
+	first, _ := b.allocate(100, 1)
+	if _, ok := b.allocate(100, 64); !ok {
+		t.Fatal("second allocation failed")
+	}
+	if _, ok := b.allocate(100, 1); ok {
+		t.Fatal("allocated past the end of the block")
+	}
+
+	// the padding at 100-128 in front of the aligned second allocation
+	// stays reserved with it
+	b.release(first)
+	if !reflect.DeepEqual(b.free, []memoryRange{{0, 100}, {228, 28}}) {
+		t.Fatalf("free = %v", b.free)
+	}
+	a, ok := b.allocate(100, 4)
+	if !ok || a.Offset != 0 {
+		t.Errorf("reallocation = %+v, %t, want offset 0", a, ok)
+	}
+	if !reflect.DeepEqual(b.free, []memoryRange{{228, 28}}) {
+		t.Errorf("free = %v, want the tail only", b.free)
+	}
+}
+
+func TestRemoveKeepsOneEmptyBlock(t *testing.T) {
+	pool := &memoryPool{}
+	newBlock := func(dedicated bool) *memoryBlock {
+		b := &memoryBlock{pool: pool, size: 256, dedicated: dedicated, free: []memoryRange{{0, 256}}}
+		pool.blocks = append(pool.blocks, b)
+		return b
+	}
+	first, second, dedicated := newBlock(false), newBlock(false), newBlock(true)
+	a, _ := first.allocate(64, 1)
+	b, _ := second.allocate(64, 1)
+	c, _ := dedicated.allocate(256, 1)
+
+	// freeing the first block's allocation leaves it as the only empty
+	// shared block, so the allocator keeps it
+	new(allocator).free(a)
+	if len(pool.blocks) != 3 {
+		t.Fatalf("%d blocks after freeing the first, want 3", len(pool.blocks))
+	}
+	if first.allocations != 0 || !reflect.DeepEqual(first.free, []memoryRange{{0, 256}}) {
+		t.Errorf("first block holds %d allocations, free %v", first.allocations, first.free)
+	}
+
+	// a second empty shared block and an empty dedicated block are freed
+	second.release(b)
+	if !pool.remove(second) {
+		t.Error("second empty block kept")
+	}
+	dedicated.release(c)
+	if !pool.remove(dedicated) {
+		t.Error("empty dedicated block kept")
+	}
+	if !reflect.DeepEqual(pool.blocks, []*memoryBlock{first}) {
+		t.Errorf("pool keeps %d blocks, want the first", len(pool.blocks))
+	}
+	if pool.remove(first) {
+		t.Error("last empty block freed")
+	}
+}
@@ -0,0 +1,120 @@
+package device
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"unsafe"
+
+	"github.com/goki/vulkan"
+)
+
+// pipelineCacheHeaderSize is the size of VkPipelineCacheHeaderVersionOne.
+const pipelineCacheHeaderSize = 32
+
+func pipelineCachePath(properties vulkan.PhysicalDeviceProperties) (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+
+	name := fmt.Sprintf("pipelines-%04x-%04x.bin", properties.VendorID, properties.DeviceID)
+	return filepath.Join(dir, "vulkan_gravity", name), nil
+}
+
+// validPipelineCache reports whether data was written by the same driver
+// for the same device. Drivers are expected to reject foreign data, but
+// not all of them do so gracefully.
+func validPipelineCache(data []byte, properties vulkan.PhysicalDeviceProperties) bool {
+	if len(data) < pipelineCacheHeaderSize {
+		return false
+	}
+
+	headerSize := binary.NativeEndian.Uint32(data[0:])
+	headerVersion := binary.NativeEndian.Uint32(data[4:])
+	vendorID := binary.NativeEndian.Uint32(data[8:])
+	deviceID := binary.NativeEndian.Uint32(data[12:])
+
+	return headerSize >= pipelineCacheHeaderSize && int(headerSize) <= len(data) &&
+		headerVersion == uint32(vulkan.PipelineCacheHeaderVersion1) &&
+		vendorID == properties.VendorID &&
+		deviceID == properties.DeviceID &&
+		[16]byte(data[16:32]) == properties.PipelineCacheUUID
+}
+
+// createPipelineCache creates the pipeline cache shared by all pipelines of
+// the device, seeded from disk when a matching cache was saved before.
+func (v *Device) createPipelineCache() error {
+	properties := getProperties(v.physicalDevice)
+
+	var data []byte
+	path, err := pipelineCachePath(properties)
+	if err != nil {
+		slog.Warn("pipeline cache is not persisted", slog.Any("error", err))
+	} else {
+		v.pipelineCachePath = path
+		data, err = os.ReadFile(path)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+		case err != nil:
+			slog.Warn("failed to read pipeline cache", slog.String("path", path), slog.Any("error", err))
+		case !validPipelineCache(data, properties):
+			slog.Info("discarding pipeline cache of another device or driver", slog.String("path", path))
+			data = nil
+		}
+	}
+
+	createInfo := vulkan.PipelineCacheCreateInfo{
+		SType: vulkan.StructureTypePipelineCacheCreateInfo,
+	}
+	if len(data) > 0 {
+		createInfo.InitialDataSize = uint64(len(data))
+		createInfo.PInitialData = unsafe.Pointer(&data[0])
+	}
+
+	return Check(vulkan.CreatePipelineCache(v.LogicalDevice, &createInfo, nil, &v.PipelineCache), "create pipeline cache")
+}
+
+// savePipelineCache writes the pipeline cache next to the previous one. The
+// file is replaced atomically so that a crash never leaves a torn cache.
+func (v *Device) savePipelineCache() error {
+	if v.PipelineCache == vulkan.NullPipelineCache || v.pipelineCachePath == "" {
+		return nil
+	}
+
+	var size uint64
+	if err := Check(vulkan.GetPipelineCacheData(v.LogicalDevice, v.PipelineCache, &size, nil), "get pipeline cache size"); err != nil {
+		return err
+	}
+	if size == 0 {
+		return nil
+	}
+	data := make([]byte, size)
+	if err := Check(vulkan.GetPipelineCacheData(v.LogicalDevice, v.PipelineCache, &size, unsafe.Pointer(&data[0])), "get pipeline cache data"); err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(v.pipelineCachePath)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	file, err := os.CreateTemp(dir, "pipelines-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	if _, err := file.Write(data[:size]); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(file.Name(), v.pipelineCachePath)
+}
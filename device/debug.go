@@ -0,0 +1,197 @@
+package device
+
+// #include <stdint.h>
+import "C"
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+
+	"github.com/goki/vulkan"
+)
+
+// messageLogLimit is how many times a single message ID is logged before
+// further occurrences are only counted.
+const messageLogLimit = 10
+
+// MessageCount is the number of times a validation message was reported.
+type MessageCount struct {
+	// Name and ID identify the message, such as
+	// VUID-vkCmdDraw-None-02699. Either may be empty or zero.
+	Name     string
+	ID       int32
+	Type     string
+	Severity string
+	// Message is the text of the first occurrence.
+	Message string
+	Count   int
+}
+
+type messageKey struct {
+	name string
+	id   int32
+}
+
+// The C callback routes every messenger through a single Go function, so
+// the counts are shared by all devices in the process.
+var messages struct {
+	sync.Mutex
+	counts      map[messageKey]*MessageCount
+	errors      int
+	failOnError bool
+}
+
+func severity(flags vulkan.DebugUtilsMessageSeverityFlagBits) (string, slog.Level) {
+	switch {
+	case flags&vulkan.DebugUtilsMessageSeverityErrorBit != 0:
+		return "error", slog.LevelError
+	case flags&vulkan.DebugUtilsMessageSeverityWarningBit != 0:
+		return "warning", slog.LevelWarn
+	case flags&vulkan.DebugUtilsMessageSeverityInfoBit != 0:
+		// mostly loader chatter about the layers and drivers it found
+		return "info", slog.LevelDebug
+	}
+
+	return "verbose", slog.LevelDebug
+}
+
+func messageType(flags vulkan.DebugUtilsMessageTypeFlagBits) string {
+	switch {
+	case flags&vulkan.DebugUtilsMessageTypeValidationBit != 0:
+		return "validation"
+	case flags&vulkan.DebugUtilsMessageTypePerformanceBit != 0:
+		return "performance"
+	}
+
+	return "general"
+}
+
+//export debugMessage
+func debugMessage(
+	severityBits C.uint32_t,
+	typeBits C.uint32_t,
+	idName *C.char,
+	idNumber C.int32_t,
+	text *C.char,
+	objectType C.int32_t,
+	objectHandle C.uint64_t,
+	objectName *C.char,
+) C.uint32_t {
+	name, level := severity(vulkan.DebugUtilsMessageSeverityFlagBits(severityBits))
+	key := messageKey{id: int32(idNumber)}
+	if idName != nil {
+		key.name = C.GoString(idName)
+	}
+	message := C.GoString(text)
+
+	messages.Lock()
+	count, ok := messages.counts[key]
+	if !ok {
+		count = &MessageCount{
+			Name:     key.name,
+			ID:       key.id,
+			Type:     messageType(vulkan.DebugUtilsMessageTypeFlagBits(typeBits)),
+			Severity: name,
+			Message:  message,
+		}
+		messages.counts[key] = count
+	}
+	count.Count++
+	if level == slog.LevelError {
+		messages.errors++
+	}
+	n := count.Count
+	abort := level == slog.LevelError && messages.failOnError
+	messages.Unlock()
+
+	if n <= messageLogLimit {
+		attrs := []any{
+			slog.String("type", count.Type),
+			slog.String("name", key.name),
+			slog.Int("id", int(key.id)),
+		}
+		if objectHandle != 0 {
+			object := fmt.Sprintf("%d:%#x", int32(objectType), uint64(objectHandle))
+			if objectName != nil {
+				object += " " + C.GoString(objectName)
+			}
+			attrs = append(attrs, slog.String("object", object))
+		}
+		if n == messageLogLimit {
+			attrs = append(attrs, slog.Bool("suppressed", true))
+		}
+		slog.Log(context.Background(), level, message, attrs...)
+	}
+
+	// returning true makes the call that triggered the message fail with
+	// VK_ERROR_VALIDATION_FAILED_EXT
+	if abort {
+		return C.uint32_t(vulkan.True)
+	}
+
+	return C.uint32_t(vulkan.False)
+}
+
+// createMessenger routes validation layer output into slog through a
+// VK_EXT_debug_utils messenger.
+func createMessenger(instance vulkan.Instance, failOnError bool) (uint64, error) {
+	messages.Lock()
+	if messages.counts == nil {
+		messages.counts = make(map[messageKey]*MessageCount)
+	}
+	messages.failOnError = failOnError
+	messages.Unlock()
+
+	return createDebugMessenger(
+		instance,
+		vulkan.DebugUtilsMessageSeverityErrorBit|
+			vulkan.DebugUtilsMessageSeverityWarningBit|
+			vulkan.DebugUtilsMessageSeverityInfoBit,
+		vulkan.DebugUtilsMessageTypeGeneralBit|
+			vulkan.DebugUtilsMessageTypeValidationBit|
+			vulkan.DebugUtilsMessageTypePerformanceBit,
+	)
+}
+
+// ValidationMessages returns how often every validation message was
+// reported so far, most frequent first.
+func ValidationMessages() []MessageCount {
+	messages.Lock()
+	defer messages.Unlock()
+
+	counts := make([]MessageCount, 0, len(messages.counts))
+	for _, count := range messages.counts {
+		counts = append(counts, *count)
+	}
+	slices.SortFunc(counts, func(a, b MessageCount) int {
+		return cmp.Or(cmp.Compare(b.Count, a.Count), cmp.Compare(a.Name, b.Name), cmp.Compare(a.ID, b.ID))
+	})
+
+	return counts
+}
+
+// ValidationErrors returns the number of error messages reported so far.
+// Tests can assert it stays zero after exercising a device.
+func ValidationErrors() int {
+	messages.Lock()
+	defer messages.Unlock()
+
+	return messages.errors
+}
+
+func logMessageSummary() {
+	for _, count := range ValidationMessages() {
+		if count.Count > messageLogLimit {
+			slog.Warn("validation message repeated",
+				slog.String("name", count.Name),
+				slog.Int("id", int(count.ID)),
+				slog.String("severity", count.Severity),
+				slog.Int("count", count.Count),
+			)
+		}
+	}
+}
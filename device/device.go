@@ -4,39 +4,43 @@ import (
 	"errors"
 	"fmt"
 	"game/window"
+	"log/slog"
+	"slices"
+	"unsafe"
 
 	"github.com/goki/vulkan"
 )
 
-func checkValidationLayers() {
+func checkValidationLayers() error {
 	var layerCount uint32
 
-	if err := vulkan.Error(vulkan.EnumerateInstanceLayerProperties(&layerCount, nil)); err != nil {
-		panic("failed to enumerate instance layers: " + err.Error())
+	if err := Check(vulkan.EnumerateInstanceLayerProperties(&layerCount, nil), "enumerate instance layers"); err != nil {
+		return err
 	}
 
 	availableLayers := make([]vulkan.LayerProperties, layerCount)
-	if err := vulkan.Error(vulkan.EnumerateInstanceLayerProperties(&layerCount, availableLayers)); err != nil {
-		panic("failed to enumerate instance layers: " + err.Error())
+	if err := Check(vulkan.EnumerateInstanceLayerProperties(&layerCount, availableLayers), "enumerate instance layers"); err != nil {
+		return err
 	}
 
 	for _, layer := range availableLayers {
 		layer.Deref()
 		if vulkan.ToString(layer.LayerName[:]) == "VK_LAYER_KHRONOS_validation" {
-			return
+			return nil
 		}
 	}
 
-	panic(fmt.Sprintf("validation layer %s not found", "VK_LAYER_KHRONOS_validation"))
+	return fmt.Errorf("validation layer %s not found", "VK_LAYER_KHRONOS_validation")
 }
 
-func newInstance(withValidation bool, w window.Window) vulkan.Instance {
+func newInstance(withValidation, debugUtils bool, extensions []string) (vulkan.Instance, error) {
 	if withValidation {
-		checkValidationLayers()
+		if err := checkValidationLayers(); err != nil {
+			return nil, err
+		}
 	}
 
-	extensions := w.GetRequiredInstanceExtensions()
-	if withValidation {
+	if debugUtils {
 		extensions = append(extensions, vulkan.ExtDebugUtilsExtensionName+"\x00")
 	}
 	extensions = append(extensions, vulkan.KhrPortabilityEnumerationExtensionName+"\x00")
@@ -62,87 +66,156 @@ func newInstance(withValidation bool, w window.Window) vulkan.Instance {
 	}
 
 	var instance vulkan.Instance
-	if err := vulkan.Error(vulkan.CreateInstance(&createInfo, nil, &instance)); err != nil {
-		panic("failed to create instance: " + err.Error())
+	if err := Check(vulkan.CreateInstance(&createInfo, nil, &instance), "create instance"); err != nil {
+		return nil, err
 	}
 
 	if err := vulkan.InitInstance(instance); err != nil {
-		panic("failed to init instance: " + err.Error())
+		vulkan.DestroyInstance(instance, nil)
+		return nil, fmt.Errorf("failed to init instance: %w", err)
 	}
 
-	return instance
+	return instance, nil
 }
 
-func deviceIsSuitable(device vulkan.PhysicalDevice) bool {
+func getInstanceExtensions() (map[string]bool, error) {
+	var count uint32
+	if err := Check(vulkan.EnumerateInstanceExtensionProperties("", &count, nil), "enumerate instance extensions"); err != nil {
+		return nil, err
+	}
+	extensions := make([]vulkan.ExtensionProperties, count)
+	if err := Check(vulkan.EnumerateInstanceExtensionProperties("", &count, extensions), "enumerate instance extensions"); err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]bool, len(extensions))
+	for _, extension := range extensions {
+		extension.Deref()
+		result[vulkan.ToString(extension.ExtensionName[:])] = true
+	}
+
+	return result, nil
+}
+
+// newDebugInstance creates an instance with VK_EXT_debug_utils enabled when
+// available, which frame debuggers such as RenderDoc provide even without
+// validation, and reports whether objects can be named through it.
+func newDebugInstance(withValidation bool, extensions []string) (vulkan.Instance, bool, error) {
+	available, err := getInstanceExtensions()
+	if err != nil {
+		return nil, false, err
+	}
+	// the validation layer provides it as well
+	debugUtils := available[vulkan.ExtDebugUtilsExtensionName] || withValidation
+
+	instance, err := newInstance(withValidation, debugUtils, extensions)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return instance, debugUtils && loadDebugUtils(instance), nil
+}
+
+func getDeviceExtensions(device vulkan.PhysicalDevice) (map[string]bool, error) {
 	var extensionCount uint32
-	if err := vulkan.Error(vulkan.EnumerateDeviceExtensionProperties(device, "", &extensionCount, nil)); err != nil {
-		panic("failed to enumerate device extensions: " + err.Error())
+	if err := Check(vulkan.EnumerateDeviceExtensionProperties(device, "", &extensionCount, nil), "enumerate device extensions"); err != nil {
+		return nil, err
 	}
 
 	extensions := make([]vulkan.ExtensionProperties, extensionCount)
-	if err := vulkan.Error(vulkan.EnumerateDeviceExtensionProperties(device, "", &extensionCount, extensions)); err != nil {
-		panic("failed to enumerate device extensions: " + err.Error())
+	if err := Check(vulkan.EnumerateDeviceExtensionProperties(device, "", &extensionCount, extensions), "enumerate device extensions"); err != nil {
+		return nil, err
 	}
 
+	result := make(map[string]bool, len(extensions))
 	for _, extension := range extensions {
 		extension.Deref()
-		if vulkan.ToString(extension.ExtensionName[:]) == vulkan.KhrSwapchainExtensionName {
-			return true
-		}
+		result[vulkan.ToString(extension.ExtensionName[:])] = true
 	}
 
-	return false
+	return result, nil
 }
 
-func pickPhysicalDevice(instance vulkan.Instance) (vulkan.PhysicalDevice, error) {
-	var devicesCount uint32
-	if err := vulkan.Error(vulkan.EnumeratePhysicalDevices(instance, &devicesCount, nil)); err != nil {
-		panic("failed to enumerate physical devices: " + err.Error())
-	}
-	devices := make([]vulkan.PhysicalDevice, devicesCount)
-	if err := vulkan.Error(vulkan.EnumeratePhysicalDevices(instance, &devicesCount, devices)); err != nil {
-		panic("failed to enumerate physical devices: " + err.Error())
+func deviceIsSuitable(device vulkan.PhysicalDevice, requiredExtensions []string) (bool, error) {
+	extensions, err := getDeviceExtensions(device)
+	if err != nil {
+		return false, err
 	}
 
-	for _, device := range devices {
-		if deviceIsSuitable(device) {
-			return device, nil
+	for _, extension := range requiredExtensions {
+		if !extensions[extension] {
+			return false, nil
 		}
 	}
 
-	return nil, errors.New("no suitable device found")
+	return true, nil
 }
 
+var errNoQueueFamily = errors.New("no suitable queue family found")
+
 func findGraphicQueueFamily(
 	device vulkan.PhysicalDevice,
 	surface vulkan.Surface,
 	queuesFamilies []vulkan.QueueFamilyProperties,
-) int {
+) (int, error) {
 	for i, family := range queuesFamilies {
 		family.Deref()
 		if family.QueueCount > 0 && family.QueueFlags&vulkan.QueueFlags(vulkan.QueueGraphicsBit) > 0 {
 			var supported vulkan.Bool32
 			vulkan.GetPhysicalDeviceSurfaceSupport(device, uint32(i), surface, &supported)
 			if supported.B() {
-				return i
+				return i, nil
 			}
 		}
 	}
 
-	panic("no suitable queue family found")
+	return 0, errNoQueueFamily
 }
 
 func findComputeQueueFamily(
 	queuesFamilies []vulkan.QueueFamilyProperties,
-) int {
+) (int, error) {
 	for i, family := range queuesFamilies {
 		family.Deref()
 		if family.QueueCount > 0 && family.QueueFlags&vulkan.QueueFlags(vulkan.QueueComputeBit) > 0 {
+			return i, nil
+		}
+	}
+
+	return 0, errNoQueueFamily
+}
+
+func findOffscreenQueueFamily(
+	queuesFamilies []vulkan.QueueFamilyProperties,
+	fallback int,
+) int {
+	for i, family := range queuesFamilies {
+		family.Deref()
+		if family.QueueCount > 0 && family.QueueFlags&vulkan.QueueFlags(vulkan.QueueGraphicsBit) > 0 {
+			return i
+		}
+	}
+
+	return fallback
+}
+
+// findTransferQueueFamily prefers a family that only does transfers, which
+// usually maps to a DMA engine running alongside compute and graphics.
+func findTransferQueueFamily(
+	queuesFamilies []vulkan.QueueFamilyProperties,
+	fallback int,
+) int {
+	for i, family := range queuesFamilies {
+		family.Deref()
+		flags := family.QueueFlags
+		if family.QueueCount > 0 &&
+			flags&vulkan.QueueFlags(vulkan.QueueTransferBit) != 0 &&
+			flags&vulkan.QueueFlags(vulkan.QueueGraphicsBit|vulkan.QueueComputeBit) == 0 {
 			return i
 		}
 	}
 
-	panic("no suitable queue family found")
+	return fallback
 }
 
 func getQueueFamilies(device vulkan.PhysicalDevice) []vulkan.QueueFamilyProperties {
@@ -154,195 +227,386 @@ func getQueueFamilies(device vulkan.PhysicalDevice) []vulkan.QueueFamilyProperti
 	return queuesFamilies
 }
 
-func createLogicalDevice(device vulkan.PhysicalDevice, queueIdx int) vulkan.Device {
+func createLogicalDevice(device vulkan.PhysicalDevice, queueFamilies []int, extensions []string) (vulkan.Device, error) {
+	available, err := getDeviceExtensions(device)
+	if err != nil {
+		return nil, err
+	}
+	// portability subset must be enabled whenever the implementation offers it (MoltenVK)
+	if available[vulkan.KhrPortabilitySubsetExtensionName] {
+		extensions = append(extensions, vulkan.KhrPortabilitySubsetExtensionName)
+	}
+
+	var enabledExtensions []string
+	for _, extension := range extensions {
+		enabledExtensions = append(enabledExtensions, extension+"\x00")
+	}
+
+	var queueCreateInfos []vulkan.DeviceQueueCreateInfo
+	seen := map[int]bool{}
+	for _, queueIdx := range queueFamilies {
+		if seen[queueIdx] {
+			continue
+		}
+		seen[queueIdx] = true
+		queueCreateInfos = append(queueCreateInfos, vulkan.DeviceQueueCreateInfo{
+			SType:            vulkan.StructureTypeDeviceQueueCreateInfo,
+			QueueFamilyIndex: uint32(queueIdx),
+			QueueCount:       1,
+			PQueuePriorities: []float32{1.0},
+		})
+	}
+
 	var logicalDevice vulkan.Device
-	if err := vulkan.Error(vulkan.CreateDevice(device, &vulkan.DeviceCreateInfo{
+	if err := Check(vulkan.CreateDevice(device, &vulkan.DeviceCreateInfo{
 		SType:                vulkan.StructureTypeDeviceCreateInfo,
-		QueueCreateInfoCount: 1,
-		PQueueCreateInfos: []vulkan.DeviceQueueCreateInfo{
-			{
-				SType:            vulkan.StructureTypeDeviceQueueCreateInfo,
-				QueueFamilyIndex: uint32(queueIdx),
-				QueueCount:       1,
-				PQueuePriorities: []float32{1.0},
-			},
-		},
+		QueueCreateInfoCount: uint32(len(queueCreateInfos)),
+		PQueueCreateInfos:    queueCreateInfos,
 		PEnabledFeatures: []vulkan.PhysicalDeviceFeatures{
 			{
 				SamplerAnisotropy: vulkan.True,
 			},
 		},
 
-		EnabledExtensionCount: 2,
-		PpEnabledExtensionNames: []string{
-			vulkan.KhrSwapchainExtensionName + "\x00",
-			vulkan.KhrPortabilitySubsetExtensionName + "\x00",
-		},
-	}, nil, &logicalDevice)); err != nil {
-		panic("failed to create logical device: " + err.Error())
+		EnabledExtensionCount:   uint32(len(enabledExtensions)),
+		PpEnabledExtensionNames: enabledExtensions,
+	}, nil, &logicalDevice), "create logical device"); err != nil {
+		return nil, err
 	}
 
-	return logicalDevice
+	return logicalDevice, nil
 }
 
-func createCommandPool(device vulkan.Device, queueIdx int) vulkan.CommandPool {
+func createCommandPool(device vulkan.Device, queueIdx int) (vulkan.CommandPool, error) {
 	var pool vulkan.CommandPool
-	if err := vulkan.Error(vulkan.CreateCommandPool(device, &vulkan.CommandPoolCreateInfo{
+	err := Check(vulkan.CreateCommandPool(device, &vulkan.CommandPoolCreateInfo{
 		SType:            vulkan.StructureTypeCommandPoolCreateInfo,
 		QueueFamilyIndex: uint32(queueIdx),
 		Flags:            vulkan.CommandPoolCreateFlags(vulkan.CommandPoolCreateTransientBit | vulkan.CommandPoolCreateResetCommandBufferBit),
-	}, nil, &pool)); err != nil {
-		panic("failed to create command pool: " + err.Error())
-	}
-	return pool
+	}, nil, &pool), "create command pool")
+
+	return pool, err
+}
+
+type Config struct {
+	// Validation enables the Khronos validation layer and logs its messages.
+	Validation bool
+	// FailOnValidationError makes every Vulkan call that triggers a
+	// validation error fail with ErrorValidationFailed.
+	FailOnValidationError bool
+	// DeviceIndex selects a physical device by enumeration order, -1 picks
+	// the best suitable one by type.
+	DeviceIndex int
+	// DeviceName restricts the automatic choice to devices whose name
+	// contains it, ignoring case.
+	DeviceName string
+	// DedicatedTransfer uploads through a transfer-only queue family when
+	// the device has one, instead of the compute queue.
+	DedicatedTransfer bool
+	// PipelineCache keeps compiled pipelines in the user cache directory
+	// between runs.
+	PipelineCache bool
+}
+
+var DefaultConfig = Config{
+	Validation:        true,
+	DeviceIndex:       -1,
+	DedicatedTransfer: true,
+	PipelineCache:     true,
 }
 
 type Device struct {
-	instance        vulkan.Instance
-	Surface         vulkan.Surface
-	queueIdx        int
-	computeQueueIdx int
-	Queue           vulkan.Queue
-	ComputeQueue    vulkan.Queue
-	physicalDevice  vulkan.PhysicalDevice
-	LogicalDevice   vulkan.Device
-	Pool            vulkan.CommandPool
-	ComputePool     vulkan.CommandPool
+	instance         vulkan.Instance
+	messenger        uint64
+	debugUtils       bool
+	memory           *allocator
+	Surface          vulkan.Surface
+	queueIdx         int
+	computeQueueIdx  int
+	transferQueueIdx int
+	Queue            vulkan.Queue
+	ComputeQueue     vulkan.Queue
+	TransferQueue    vulkan.Queue
+	physicalDevice   vulkan.PhysicalDevice
+	LogicalDevice    vulkan.Device
+	Pool             vulkan.CommandPool
+	ComputePool      vulkan.CommandPool
+	TransferPool     vulkan.CommandPool
+	// PipelineCache is used for all graphics and compute pipelines.
+	PipelineCache     vulkan.PipelineCache
+	pipelineCachePath string
 }
 
-func New(w window.Window) *Device {
-	instance := newInstance(true, w)
-	surface := w.CreateSurface(instance)
-	device, err := pickPhysicalDevice(instance)
+func New(w window.Window, config Config) (*Device, error) {
+	available, err := getInstanceExtensions()
 	if err != nil {
-		panic("failed to pick physical device: " + err.Error())
+		return nil, err
+	}
+	extensions := w.GetRequiredInstanceExtensions()
+	// surfaces only report HDR and extended color spaces with it
+	if available[vulkan.ExtSwapchainColorSpaceExtensionName] {
+		extensions = append(extensions, vulkan.ExtSwapchainColorSpaceExtensionName+"\x00")
 	}
-	queueFamilies := getQueueFamilies(device)
-	queueIdx := findGraphicQueueFamily(device, surface, queueFamilies)
-	computeQueueIdx := findComputeQueueFamily(queueFamilies)
 
-	logicalDevice := createLogicalDevice(device, queueIdx)
+	instance, debugUtils, err := newDebugInstance(config.Validation, extensions)
+	if err != nil {
+		return nil, err
+	}
+	v := &Device{instance: instance, Surface: vulkan.NullSurface, debugUtils: debugUtils}
 
-	var queue vulkan.Queue
-	vulkan.GetDeviceQueue(logicalDevice, uint32(queueIdx), 0, &queue)
+	if config.Validation {
+		if v.messenger, err = createMessenger(instance, config.FailOnValidationError); err != nil {
+			v.Close()
+			return nil, err
+		}
+	}
 
-	var computeQueue vulkan.Queue
-	vulkan.GetDeviceQueue(logicalDevice, uint32(computeQueueIdx), 0, &computeQueue)
+	if v.Surface, err = w.CreateSurface(instance); err != nil {
+		v.Close()
+		return nil, err
+	}
+	if v.physicalDevice, err = pickPhysicalDevice(instance, []string{vulkan.KhrSwapchainExtensionName}, config); err != nil {
+		v.Close()
+		return nil, fmt.Errorf("failed to pick physical device: %w", err)
+	}
+	queueFamilies := getQueueFamilies(v.physicalDevice)
+	if v.queueIdx, err = findGraphicQueueFamily(v.physicalDevice, v.Surface, queueFamilies); err != nil {
+		v.Close()
+		return nil, err
+	}
+	if v.computeQueueIdx, err = findComputeQueueFamily(queueFamilies); err != nil {
+		v.Close()
+		return nil, err
+	}
+	v.transferQueueIdx = v.computeQueueIdx
+	if config.DedicatedTransfer {
+		v.transferQueueIdx = findTransferQueueFamily(queueFamilies, v.computeQueueIdx)
+	}
+
+	if err := v.init([]string{vulkan.KhrSwapchainExtensionName}, config.PipelineCache); err != nil {
+		v.Close()
+		return nil, err
+	}
 
-	pool := createCommandPool(logicalDevice, queueIdx)
-	computePool := createCommandPool(logicalDevice, computeQueueIdx)
+	return v, nil
+}
 
-	return &Device{
-		instance:        instance,
-		Surface:         surface,
-		physicalDevice:  device,
-		queueIdx:        queueIdx,
-		computeQueueIdx: computeQueueIdx,
-		LogicalDevice:   logicalDevice,
-		Queue:           queue,
-		ComputeQueue:    computeQueue,
-		Pool:            pool,
-		ComputePool:     computePool,
+// NewHeadless creates a device without a surface or swapchain support, for
+// compute-only and offscreen runs under software drivers such as lavapipe.
+func NewHeadless(config Config) (*Device, error) {
+	instance, debugUtils, err := newDebugInstance(config.Validation, nil)
+	if err != nil {
+		return nil, err
 	}
+	v := &Device{instance: instance, Surface: vulkan.NullSurface, debugUtils: debugUtils}
+
+	if config.Validation {
+		if v.messenger, err = createMessenger(instance, config.FailOnValidationError); err != nil {
+			v.Close()
+			return nil, err
+		}
+	}
+
+	if v.physicalDevice, err = pickPhysicalDevice(instance, nil, config); err != nil {
+		v.Close()
+		return nil, fmt.Errorf("failed to pick physical device: %w", err)
+	}
+	queueFamilies := getQueueFamilies(v.physicalDevice)
+	if v.computeQueueIdx, err = findComputeQueueFamily(queueFamilies); err != nil {
+		v.Close()
+		return nil, err
+	}
+	v.transferQueueIdx = v.computeQueueIdx
+	if config.DedicatedTransfer {
+		v.transferQueueIdx = findTransferQueueFamily(queueFamilies, v.computeQueueIdx)
+	}
+	// offscreen rendering still needs a graphics queue, compute-only devices fall back to compute
+	v.queueIdx = findOffscreenQueueFamily(queueFamilies, v.computeQueueIdx)
+
+	if err := v.init(nil, config.PipelineCache); err != nil {
+		v.Close()
+		return nil, err
+	}
+
+	return v, nil
 }
 
-func (v *Device) findMemoryType(typeFilter uint32, properties vulkan.MemoryPropertyFlags) uint32 {
-	var memProperties vulkan.PhysicalDeviceMemoryProperties
-	vulkan.GetPhysicalDeviceMemoryProperties(v.physicalDevice, &memProperties)
-	memProperties.Deref()
+// init creates the logical device, its queues and command pools once the
+// physical device and queue families are chosen.
+func (v *Device) init(extensions []string, pipelineCache bool) error {
+	var err error
+	if v.LogicalDevice, err = createLogicalDevice(v.physicalDevice, v.queueFamilies(), extensions); err != nil {
+		return err
+	}
+
+	v.memory = newAllocator(v.LogicalDevice, v.physicalDevice)
 
-	for i := uint32(0); i < memProperties.MemoryTypeCount; i++ {
-		memProperties.MemoryTypes[i].Deref()
-		if typeFilter&(1<<i) != 0 && (memProperties.MemoryTypes[i].PropertyFlags&properties) == properties {
-			return i
+	vulkan.GetDeviceQueue(v.LogicalDevice, uint32(v.queueIdx), 0, &v.Queue)
+	vulkan.GetDeviceQueue(v.LogicalDevice, uint32(v.computeQueueIdx), 0, &v.ComputeQueue)
+	vulkan.GetDeviceQueue(v.LogicalDevice, uint32(v.transferQueueIdx), 0, &v.TransferQueue)
+
+	if v.Pool, err = createCommandPool(v.LogicalDevice, v.queueIdx); err != nil {
+		return err
+	}
+	if v.ComputePool, err = createCommandPool(v.LogicalDevice, v.computeQueueIdx); err != nil {
+		return err
+	}
+	if v.TransferPool, err = createCommandPool(v.LogicalDevice, v.transferQueueIdx); err != nil {
+		return err
+	}
+
+	if pipelineCache {
+		return v.createPipelineCache()
+	}
+
+	return nil
+}
+
+// queueFamilies returns the distinct queue families the device uses.
+func (v *Device) queueFamilies() []int {
+	families := []int{v.queueIdx}
+	for _, idx := range []int{v.computeQueueIdx, v.transferQueueIdx} {
+		if !slices.Contains(families, idx) {
+			families = append(families, idx)
 		}
 	}
 
-	panic("failed to find suitable memory type")
+	return families
+}
+
+func (v *Device) Name() string {
+	properties := getProperties(v.physicalDevice)
+	return vulkan.ToString(properties.DeviceName[:])
+}
+
+// Timestamps returns the nanoseconds per timestamp tick and the number of
+// valid timestamp bits shared by the graphics and compute queues. A zero
+// value of either means timestamps can't be used.
+func (v *Device) Timestamps() (float32, uint32) {
+	properties := getProperties(v.physicalDevice)
+
+	queueFamilies := getQueueFamilies(v.physicalDevice)
+	validBits := uint32(64)
+	for _, idx := range []int{v.queueIdx, v.computeQueueIdx} {
+		queueFamilies[idx].Deref()
+		validBits = min(validBits, queueFamilies[idx].TimestampValidBits)
+	}
+
+	return properties.Limits.TimestampPeriod, validBits
 }
 
+// CreateBuffer creates a buffer bound to memory from the device allocator.
+// Both are released with vulkan.DestroyBuffer and Free.
 func (v *Device) CreateBuffer(
 	size vulkan.DeviceSize,
 	usage vulkan.BufferUsageFlags,
 	memProperties vulkan.MemoryPropertyFlags,
-) (vulkan.Buffer, vulkan.DeviceMemory) {
-	var buffer vulkan.Buffer
-	if err := vulkan.Error(vulkan.CreateBuffer(v.LogicalDevice, &vulkan.BufferCreateInfo{
+) (vulkan.Buffer, *Allocation, error) {
+	createInfo := vulkan.BufferCreateInfo{
 		SType:       vulkan.StructureTypeBufferCreateInfo,
 		Size:        size,
 		Usage:       usage,
 		SharingMode: vulkan.SharingModeExclusive,
-	}, nil, &buffer)); err != nil {
-		panic("failed to create buffer: " + err.Error())
+	}
+	// buffers are written by the transfer queue and read by compute and
+	// graphics, sharing them avoids ownership transfers between families
+	if families := v.queueFamilies(); len(families) > 1 {
+		createInfo.SharingMode = vulkan.SharingModeConcurrent
+		for _, family := range families {
+			createInfo.PQueueFamilyIndices = append(createInfo.PQueueFamilyIndices, uint32(family))
+		}
+		createInfo.QueueFamilyIndexCount = uint32(len(families))
+	}
+
+	var buffer vulkan.Buffer
+	if err := Check(vulkan.CreateBuffer(v.LogicalDevice, &createInfo, nil, &buffer), "create buffer"); err != nil {
+		return nil, nil, err
 	}
 
 	var memRequirements vulkan.MemoryRequirements
 	vulkan.GetBufferMemoryRequirements(v.LogicalDevice, buffer, &memRequirements)
 	memRequirements.Deref()
 
-	var memory vulkan.DeviceMemory
-	if err := vulkan.Error(vulkan.AllocateMemory(v.LogicalDevice, &vulkan.MemoryAllocateInfo{
-		SType:           vulkan.StructureTypeMemoryAllocateInfo,
-		AllocationSize:  memRequirements.Size,
-		MemoryTypeIndex: v.findMemoryType(memRequirements.MemoryTypeBits, memProperties),
-	}, nil, &memory)); err != nil {
-		panic("failed to allocate buffer: " + err.Error())
+	allocation, err := v.memory.allocate(memRequirements, memProperties, true)
+	if err != nil {
+		vulkan.DestroyBuffer(v.LogicalDevice, buffer, nil)
+		return nil, nil, err
 	}
 
-	if err := vulkan.Error(vulkan.BindBufferMemory(v.LogicalDevice, buffer, memory, 0)); err != nil {
-		panic("failed to bind buffer memory: " + err.Error())
+	if err := Check(vulkan.BindBufferMemory(v.LogicalDevice, buffer, allocation.Memory, allocation.Offset), "bind buffer memory"); err != nil {
+		vulkan.DestroyBuffer(v.LogicalDevice, buffer, nil)
+		v.Free(allocation)
+		return nil, nil, err
 	}
 
-	return buffer, memory
+	return buffer, allocation, nil
 }
 
 func (v *Device) CreateImageWithInfo(
 	createInfo vulkan.ImageCreateInfo,
 	properties vulkan.MemoryPropertyFlags,
-) (vulkan.Image, vulkan.DeviceMemory) {
+) (vulkan.Image, *Allocation, error) {
 	var image vulkan.Image
-	if err := vulkan.Error(vulkan.CreateImage(v.LogicalDevice, &createInfo, nil, &image)); err != nil {
-		panic("failed to create image: " + err.Error())
+	if err := Check(vulkan.CreateImage(v.LogicalDevice, &createInfo, nil, &image), "create image"); err != nil {
+		return nil, nil, err
 	}
 
 	var memReq vulkan.MemoryRequirements
 	vulkan.GetImageMemoryRequirements(v.LogicalDevice, image, &memReq)
 	memReq.Deref()
 
-	var imageMemory vulkan.DeviceMemory
-	if err := vulkan.Error(vulkan.AllocateMemory(v.LogicalDevice, &vulkan.MemoryAllocateInfo{
-		SType:           vulkan.StructureTypeMemoryAllocateInfo,
-		AllocationSize:  memReq.Size,
-		MemoryTypeIndex: v.findMemoryType(memReq.MemoryTypeBits, properties),
-	}, nil, &imageMemory)); err != nil {
-		panic("failed to allocate memory for image: " + err.Error())
+	allocation, err := v.memory.allocate(memReq, properties, createInfo.Tiling == vulkan.ImageTilingLinear)
+	if err != nil {
+		vulkan.DestroyImage(v.LogicalDevice, image, nil)
+		return nil, nil, err
+	}
+
+	if err := Check(vulkan.BindImageMemory(v.LogicalDevice, image, allocation.Memory, allocation.Offset), "bind image to memory"); err != nil {
+		vulkan.DestroyImage(v.LogicalDevice, image, nil)
+		v.Free(allocation)
+		return nil, nil, err
 	}
 
-	if err := vulkan.Error(vulkan.BindImageMemory(v.LogicalDevice, image, imageMemory, 0)); err != nil {
-		panic("failed to bind image to memory: " + err.Error())
+	return image, allocation, nil
+}
+
+// Free returns an allocation to its block, nil is ignored.
+func (v *Device) Free(allocation *Allocation) {
+	if allocation != nil {
+		v.memory.free(allocation)
 	}
+}
+
+// Map returns a pointer to host visible allocation memory. Blocks stay
+// mapped until the device is closed, so there is nothing to unmap.
+func (v *Device) Map(allocation *Allocation) (unsafe.Pointer, error) {
+	return v.memory.mapMemory(allocation)
+}
 
-	return image, imageMemory
+// MemoryStats reports the device memory held by the allocator per memory
+// type.
+func (v *Device) MemoryStats() []MemoryStats {
+	return v.memory.stats()
 }
 
 func (v *Device) FindSupportedFormat(
 	candidates []vulkan.Format,
 	tiling vulkan.ImageTiling,
 	features vulkan.FormatFeatureFlags,
-) vulkan.Format {
+) (vulkan.Format, error) {
 	for _, format := range candidates {
 		var properties vulkan.FormatProperties
 		vulkan.GetPhysicalDeviceFormatProperties(v.physicalDevice, format, &properties)
 		properties.Deref()
 
 		if tiling == vulkan.ImageTilingLinear && (properties.LinearTilingFeatures&features) == features {
-			return format
+			return format, nil
 		}
 		if tiling == vulkan.ImageTilingOptimal && (properties.OptimalTilingFeatures&features) == features {
-			return format
+			return format, nil
 		}
 	}
-	panic("failed to find supported format")
+	return vulkan.FormatUndefined, errors.New("failed to find supported format")
 }
 
 type SwapchainProperties struct {
@@ -351,11 +615,11 @@ type SwapchainProperties struct {
 	Presents []vulkan.PresentMode
 }
 
-func (v *Device) SwapchainSupport() SwapchainProperties {
+func (v *Device) SwapchainSupport() (SwapchainProperties, error) {
 	sp := SwapchainProperties{}
 
-	if err := vulkan.Error(vulkan.GetPhysicalDeviceSurfaceCapabilities(v.physicalDevice, v.Surface, &sp.Caps)); err != nil {
-		panic("failed quering surface caps: " + err.Error())
+	if err := Check(vulkan.GetPhysicalDeviceSurfaceCapabilities(v.physicalDevice, v.Surface, &sp.Caps), "query surface caps"); err != nil {
+		return sp, err
 	}
 	sp.Caps.Deref()
 	sp.Caps.CurrentExtent.Deref()
@@ -363,36 +627,54 @@ func (v *Device) SwapchainSupport() SwapchainProperties {
 	sp.Caps.MinImageExtent.Deref()
 
 	var formatCount uint32
-	if err := vulkan.Error(vulkan.GetPhysicalDeviceSurfaceFormats(v.physicalDevice, v.Surface, &formatCount, nil)); err != nil {
-		panic("failed quering surface formats: " + err.Error())
+	if err := Check(vulkan.GetPhysicalDeviceSurfaceFormats(v.physicalDevice, v.Surface, &formatCount, nil), "query surface formats"); err != nil {
+		return sp, err
 	}
 
 	if formatCount != 0 {
 		sp.Formats = make([]vulkan.SurfaceFormat, formatCount)
-		if err := vulkan.Error(vulkan.GetPhysicalDeviceSurfaceFormats(v.physicalDevice, v.Surface, &formatCount, sp.Formats)); err != nil {
-			panic("failed quering surface formats: " + err.Error())
+		if err := Check(vulkan.GetPhysicalDeviceSurfaceFormats(v.physicalDevice, v.Surface, &formatCount, sp.Formats), "query surface formats"); err != nil {
+			return sp, err
 		}
 	}
 
 	var presentCount uint32
-	if err := vulkan.Error(vulkan.GetPhysicalDeviceSurfacePresentModes(v.physicalDevice, v.Surface, &presentCount, nil)); err != nil {
-		panic("failed quering surface present modes: " + err.Error())
+	if err := Check(vulkan.GetPhysicalDeviceSurfacePresentModes(v.physicalDevice, v.Surface, &presentCount, nil), "query surface present modes"); err != nil {
+		return sp, err
 	}
 
 	if presentCount != 0 {
 		sp.Presents = make([]vulkan.PresentMode, presentCount)
-		if err := vulkan.Error(vulkan.GetPhysicalDeviceSurfacePresentModes(v.physicalDevice, v.Surface, &presentCount, sp.Presents)); err != nil {
-			panic("failed quering surface present modes: " + err.Error())
+		if err := Check(vulkan.GetPhysicalDeviceSurfacePresentModes(v.physicalDevice, v.Surface, &presentCount, sp.Presents), "query surface present modes"); err != nil {
+			return sp, err
 		}
 	}
 
-	return sp
+	return sp, nil
 }
 
+// Close destroys everything the device owns. It is safe to call on a
+// partially constructed device.
 func (v *Device) Close() {
-	vulkan.DestroyCommandPool(v.LogicalDevice, v.ComputePool, nil)
-	vulkan.DestroyCommandPool(v.LogicalDevice, v.Pool, nil)
-	vulkan.DestroyDevice(v.LogicalDevice, nil)
-	vulkan.DestroySurface(v.instance, v.Surface, nil)
-	vulkan.DestroyInstance(v.instance, nil)
+	if v.LogicalDevice != nil {
+		if err := v.savePipelineCache(); err != nil {
+			slog.Warn("failed to save pipeline cache", slog.Any("error", err))
+		}
+		vulkan.DestroyPipelineCache(v.LogicalDevice, v.PipelineCache, nil)
+		v.memory.close()
+		vulkan.DestroyCommandPool(v.LogicalDevice, v.TransferPool, nil)
+		vulkan.DestroyCommandPool(v.LogicalDevice, v.ComputePool, nil)
+		vulkan.DestroyCommandPool(v.LogicalDevice, v.Pool, nil)
+		vulkan.DestroyDevice(v.LogicalDevice, nil)
+	}
+	if v.Surface != vulkan.NullSurface {
+		vulkan.DestroySurface(v.instance, v.Surface, nil)
+	}
+	if v.messenger != 0 {
+		destroyDebugMessenger(v.instance, v.messenger)
+		logMessageSummary()
+	}
+	if v.instance != nil {
+		vulkan.DestroyInstance(v.instance, nil)
+	}
 }
@@ -0,0 +1,29 @@
+package device
+
+import (
+	"github.com/goki/vulkan"
+)
+
+// VulkanError is returned when a Vulkan call fails. It unwraps to the error
+// for its Result, so callers can tell e.g. device loss from out of memory.
+type VulkanError struct {
+	Op     string
+	Result vulkan.Result
+}
+
+func (e *VulkanError) Error() string {
+	return "failed to " + e.Op + ": " + vulkan.Error(e.Result).Error()
+}
+
+func (e *VulkanError) Unwrap() error {
+	return vulkan.Error(e.Result)
+}
+
+// Check turns a non-success result of the op Vulkan call into a VulkanError.
+func Check(result vulkan.Result, op string) error {
+	if vulkan.Error(result) == nil {
+		return nil
+	}
+
+	return &VulkanError{Op: op, Result: result}
+}
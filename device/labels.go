@@ -0,0 +1,202 @@
+package device
+
+/*
+#include <stdint.h>
+#include <stdlib.h>
+
+// The bindings do not wrap VK_EXT_debug_utils, so the few entry points used
+// here are declared by hand and resolved through the loader they set up.
+
+typedef struct {
+	int32_t sType;
+	const void* pNext;
+	int32_t objectType;
+	uint64_t objectHandle;
+	const char* pObjectName;
+} ObjectNameInfo;
+
+typedef struct {
+	int32_t sType;
+	const void* pNext;
+	const char* pLabelName;
+	float color[4];
+} LabelInfo;
+
+typedef struct {
+	int32_t sType;
+	const void* pNext;
+	uint32_t flags;
+	const char* pMessageIdName;
+	int32_t messageIdNumber;
+	const char* pMessage;
+	uint32_t queueLabelCount;
+	const LabelInfo* pQueueLabels;
+	uint32_t cmdBufLabelCount;
+	const LabelInfo* pCmdBufLabels;
+	uint32_t objectCount;
+	const ObjectNameInfo* pObjects;
+} MessengerCallbackData;
+
+typedef uint32_t (*PFN_messengerCallback)(uint32_t, uint32_t, const MessengerCallbackData*, void*);
+
+typedef struct {
+	int32_t sType;
+	const void* pNext;
+	uint32_t flags;
+	uint32_t messageSeverity;
+	uint32_t messageType;
+	PFN_messengerCallback pfnUserCallback;
+	void* pUserData;
+} MessengerCreateInfo;
+
+typedef void (*PFN_void)(void);
+typedef int32_t (*PFN_setObjectName)(void*, const ObjectNameInfo*);
+typedef void (*PFN_beginLabel)(void*, const LabelInfo*);
+typedef void (*PFN_endLabel)(void*);
+typedef int32_t (*PFN_createMessenger)(void*, const MessengerCreateInfo*, const void*, uint64_t*);
+typedef void (*PFN_destroyMessenger)(void*, uint64_t, const void*);
+
+extern PFN_void (*vgo_vkGetInstanceProcAddr)(void*, const char*);
+
+// debugMessage is exported from debug.go.
+extern uint32_t debugMessage(uint32_t, uint32_t, char*, int32_t, char*, int32_t, uint64_t, char*);
+
+static PFN_setObjectName setObjectName;
+static PFN_beginLabel beginLabel;
+static PFN_endLabel endLabel;
+static PFN_createMessenger createMessenger;
+static PFN_destroyMessenger destroyMessenger;
+
+static int loadDebugUtils(void* instance) {
+	setObjectName = (PFN_setObjectName)vgo_vkGetInstanceProcAddr(instance, "vkSetDebugUtilsObjectNameEXT");
+	beginLabel = (PFN_beginLabel)vgo_vkGetInstanceProcAddr(instance, "vkCmdBeginDebugUtilsLabelEXT");
+	endLabel = (PFN_endLabel)vgo_vkGetInstanceProcAddr(instance, "vkCmdEndDebugUtilsLabelEXT");
+	createMessenger = (PFN_createMessenger)vgo_vkGetInstanceProcAddr(instance, "vkCreateDebugUtilsMessengerEXT");
+	destroyMessenger = (PFN_destroyMessenger)vgo_vkGetInstanceProcAddr(instance, "vkDestroyDebugUtilsMessengerEXT");
+	return setObjectName != NULL && beginLabel != NULL && endLabel != NULL &&
+		createMessenger != NULL && destroyMessenger != NULL;
+}
+
+// messengerCallback passes the message and the first object it is about
+// on to Go.
+static uint32_t messengerCallback(uint32_t severity, uint32_t types, const MessengerCallbackData* data, void* userData) {
+	const ObjectNameInfo* object = data->objectCount > 0 ? data->pObjects : NULL;
+	return debugMessage(severity, types, (char*)data->pMessageIdName, data->messageIdNumber, (char*)data->pMessage,
+		object != NULL ? object->objectType : 0,
+		object != NULL ? object->objectHandle : 0,
+		object != NULL ? (char*)object->pObjectName : NULL);
+}
+
+static int32_t callCreateMessenger(void* instance, int32_t sType, uint32_t severities, uint32_t types, uint64_t* messenger) {
+	if (createMessenger == NULL) {
+		return -7; // VK_ERROR_EXTENSION_NOT_PRESENT
+	}
+	MessengerCreateInfo info = {sType, NULL, 0, severities, types, messengerCallback, NULL};
+	return createMessenger(instance, &info, NULL, messenger);
+}
+
+static void callDestroyMessenger(void* instance, uint64_t messenger) {
+	destroyMessenger(instance, messenger, NULL);
+}
+
+static void callSetObjectName(void* device, int32_t sType, int32_t type, uint64_t handle, const char* name) {
+	ObjectNameInfo info = {sType, NULL, type, handle, name};
+	setObjectName(device, &info);
+}
+
+static void callBeginLabel(void* commandBuffer, int32_t sType, const char* name, float r, float g, float b, float a) {
+	LabelInfo info = {sType, NULL, name, {r, g, b, a}};
+	beginLabel(commandBuffer, &info);
+}
+
+static void callEndLabel(void* commandBuffer) {
+	endLabel(commandBuffer);
+}
+*/
+import "C"
+
+import (
+	"unsafe"
+
+	"github.com/goki/vulkan"
+)
+
+func loadDebugUtils(instance vulkan.Instance) bool {
+	return C.loadDebugUtils(unsafe.Pointer(instance)) != 0
+}
+
+func createDebugMessenger(instance vulkan.Instance, severities vulkan.DebugUtilsMessageSeverityFlagBits, types vulkan.DebugUtilsMessageTypeFlagBits) (uint64, error) {
+	var messenger C.uint64_t
+	err := Check(vulkan.Result(C.callCreateMessenger(
+		unsafe.Pointer(instance),
+		C.int32_t(vulkan.StructureTypeDebugUtilsMessengerCreateInfo),
+		C.uint32_t(severities),
+		C.uint32_t(types),
+		&messenger,
+	)), "create debug messenger")
+
+	return uint64(messenger), err
+}
+
+func destroyDebugMessenger(instance vulkan.Instance, messenger uint64) {
+	C.callDestroyMessenger(unsafe.Pointer(instance), C.uint64_t(messenger))
+}
+
+// Colors of the labels around the profiled passes.
+var (
+	LabelCompute  = [4]float32{0.2, 0.6, 1.0, 1.0}
+	LabelGraphics = [4]float32{1.0, 0.6, 0.2, 1.0}
+)
+
+func (v *Device) setName(objectType vulkan.ObjectType, handle unsafe.Pointer, name string) {
+	if !v.debugUtils {
+		return
+	}
+
+	cname := C.CString(name)
+	defer C.free(unsafe.Pointer(cname))
+	C.callSetObjectName(unsafe.Pointer(v.LogicalDevice), C.int32_t(vulkan.StructureTypeDebugUtilsObjectNameInfo), C.int32_t(objectType), C.uint64_t(uintptr(handle)), cname)
+}
+
+// NameBuffer and the other Name methods label objects for frame debuggers
+// and validation messages. They do nothing without VK_EXT_debug_utils.
+func (v *Device) NameBuffer(buffer vulkan.Buffer, name string) {
+	v.setName(vulkan.ObjectTypeBuffer, unsafe.Pointer(buffer), name)
+}
+
+func (v *Device) NameImage(image vulkan.Image, name string) {
+	v.setName(vulkan.ObjectTypeImage, unsafe.Pointer(image), name)
+}
+
+func (v *Device) NamePipeline(pipeline vulkan.Pipeline, name string) {
+	v.setName(vulkan.ObjectTypePipeline, unsafe.Pointer(pipeline), name)
+}
+
+func (v *Device) NameDescriptorSet(set vulkan.DescriptorSet, name string) {
+	v.setName(vulkan.ObjectTypeDescriptorSet, unsafe.Pointer(set), name)
+}
+
+func (v *Device) NameSemaphore(semaphore vulkan.Semaphore, name string) {
+	v.setName(vulkan.ObjectTypeSemaphore, unsafe.Pointer(semaphore), name)
+}
+
+func (v *Device) NameCommandBuffer(commandBuffer vulkan.CommandBuffer, name string) {
+	v.setName(vulkan.ObjectTypeCommandBuffer, unsafe.Pointer(commandBuffer), name)
+}
+
+// BeginLabel opens a named region in the command buffer, closed by EndLabel.
+func (v *Device) BeginLabel(commandBuffer vulkan.CommandBuffer, name string, color [4]float32) {
+	if !v.debugUtils {
+		return
+	}
+
+	cname := C.CString(name)
+	defer C.free(unsafe.Pointer(cname))
+	C.callBeginLabel(unsafe.Pointer(commandBuffer), C.int32_t(vulkan.StructureTypeDebugUtilsLabel), cname, C.float(color[0]), C.float(color[1]), C.float(color[2]), C.float(color[3]))
+}
+
+func (v *Device) EndLabel(commandBuffer vulkan.CommandBuffer) {
+	if v.debugUtils {
+		C.callEndLabel(unsafe.Pointer(commandBuffer))
+	}
+}
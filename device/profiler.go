@@ -0,0 +1,196 @@
+package device
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+	"unsafe"
+
+	"github.com/goki/vulkan"
+)
+
+// profilerWindow is the number of frames the averages are taken over.
+const profilerWindow = 60
+
+type Timing struct {
+	Pass    string
+	Last    time.Duration
+	Average time.Duration
+}
+
+type passSamples struct {
+	samples [profilerWindow]time.Duration
+	next    int
+	count   int
+	last    time.Duration
+}
+
+func (s *passSamples) add(d time.Duration) {
+	s.samples[s.next] = d
+	s.next = (s.next + 1) % profilerWindow
+	s.count = min(s.count+1, profilerWindow)
+	s.last = d
+}
+
+func (s *passSamples) average() time.Duration {
+	if s.count == 0 {
+		return 0
+	}
+
+	var sum time.Duration
+	for _, d := range s.samples[:s.count] {
+		sum += d
+	}
+
+	return sum / time.Duration(s.count)
+}
+
+// Profiler brackets named passes with GPU timestamps. Every frame in flight
+// owns its own range of queries, which is read back the next time the frame
+// index comes around and its fence has been waited on. On devices without
+// timestamp support the profiler does nothing and reports no timings; all
+// methods are also safe to call on a nil profiler.
+type Profiler struct {
+	device  *Device
+	pool    vulkan.QueryPool
+	passes  []string
+	period  float64
+	mask    uint64
+	written [][]bool
+	timings []passSamples
+}
+
+func NewProfiler(device *Device, framesInFlight int, passes ...string) (*Profiler, error) {
+	period, validBits := device.Timestamps()
+	p := &Profiler{
+		device:  device,
+		pool:    vulkan.QueryPool(vulkan.NullHandle),
+		passes:  passes,
+		period:  float64(period),
+		mask:    ^uint64(0),
+		written: make([][]bool, framesInFlight),
+		timings: make([]passSamples, len(passes)),
+	}
+	if period == 0 || validBits == 0 {
+		return p, nil
+	}
+	if validBits < 64 {
+		p.mask = 1<<validBits - 1
+	}
+
+	for i := range p.written {
+		p.written[i] = make([]bool, len(passes))
+	}
+
+	if err := Check(vulkan.CreateQueryPool(device.LogicalDevice, &vulkan.QueryPoolCreateInfo{
+		SType:      vulkan.StructureTypeQueryPoolCreateInfo,
+		QueryType:  vulkan.QueryTypeTimestamp,
+		QueryCount: uint32(framesInFlight * len(passes) * 2),
+	}, nil, &p.pool), "create query pool"); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+func (p *Profiler) Enabled() bool {
+	return p != nil && p.pool != vulkan.QueryPool(vulkan.NullHandle)
+}
+
+func (p *Profiler) query(frameIdx uint32, pass int) uint32 {
+	return (frameIdx*uint32(len(p.passes)) + uint32(pass)) * 2
+}
+
+// BeginFrame collects the timestamps written the previous time frameIdx was
+// used and resets its queries. It must be recorded into the first command
+// buffer submitted for the frame, before any pass.
+func (p *Profiler) BeginFrame(commandBuffer vulkan.CommandBuffer, frameIdx uint32) {
+	if !p.Enabled() {
+		return
+	}
+
+	for pass, written := range p.written[frameIdx] {
+		if !written {
+			continue
+		}
+		p.written[frameIdx][pass] = false
+
+		var data [2]uint64
+		if vulkan.GetQueryPoolResults(
+			p.device.LogicalDevice,
+			p.pool,
+			p.query(frameIdx, pass),
+			2,
+			uint64(unsafe.Sizeof(data)),
+			unsafe.Pointer(&data[0]),
+			vulkan.DeviceSize(unsafe.Sizeof(data[0])),
+			vulkan.QueryResultFlags(vulkan.QueryResult64Bit),
+		) != vulkan.Success {
+			continue
+		}
+
+		ticks := (data[1] - data[0]) & p.mask
+		p.timings[pass].add(time.Duration(float64(ticks) * p.period))
+	}
+
+	vulkan.CmdResetQueryPool(commandBuffer, p.pool, p.query(frameIdx, 0), uint32(len(p.passes)*2))
+}
+
+func (p *Profiler) Begin(commandBuffer vulkan.CommandBuffer, frameIdx uint32, pass string) {
+	if idx := p.passIdx(pass); idx >= 0 {
+		vulkan.CmdWriteTimestamp(commandBuffer, vulkan.PipelineStageTopOfPipeBit, p.pool, p.query(frameIdx, idx))
+	}
+}
+
+func (p *Profiler) End(commandBuffer vulkan.CommandBuffer, frameIdx uint32, pass string) {
+	if idx := p.passIdx(pass); idx >= 0 {
+		vulkan.CmdWriteTimestamp(commandBuffer, vulkan.PipelineStageBottomOfPipeBit, p.pool, p.query(frameIdx, idx)+1)
+		p.written[frameIdx][idx] = true
+	}
+}
+
+func (p *Profiler) passIdx(pass string) int {
+	if !p.Enabled() {
+		return -1
+	}
+
+	return slices.Index(p.passes, pass)
+}
+
+// Timings returns the last and rolling average duration of every pass that
+// has been measured at least once.
+func (p *Profiler) Timings() []Timing {
+	if !p.Enabled() {
+		return nil
+	}
+
+	var timings []Timing
+	for i, pass := range p.passes {
+		if p.timings[i].count == 0 {
+			continue
+		}
+		timings = append(timings, Timing{
+			Pass:    pass,
+			Last:    p.timings[i].last,
+			Average: p.timings[i].average(),
+		})
+	}
+
+	return timings
+}
+
+func (p *Profiler) String() string {
+	var parts []string
+	for _, timing := range p.Timings() {
+		parts = append(parts, fmt.Sprintf("%s %.3fms", timing.Pass, float64(timing.Average)/float64(time.Millisecond)))
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+func (p *Profiler) Close() {
+	if p.Enabled() {
+		vulkan.DestroyQueryPool(p.device.LogicalDevice, p.pool, nil)
+	}
+}
@@ -0,0 +1,223 @@
+package device
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/goki/vulkan"
+)
+
+var deviceTypeNames = map[vulkan.PhysicalDeviceType]string{
+	vulkan.PhysicalDeviceTypeOther:         "other",
+	vulkan.PhysicalDeviceTypeIntegratedGpu: "integrated",
+	vulkan.PhysicalDeviceTypeDiscreteGpu:   "discrete",
+	vulkan.PhysicalDeviceTypeVirtualGpu:    "virtual",
+	vulkan.PhysicalDeviceTypeCpu:           "cpu",
+}
+
+// deviceTypeScores ranks device types for automatic selection, software
+// rasterizers such as lavapipe come last.
+var deviceTypeScores = map[vulkan.PhysicalDeviceType]int{
+	vulkan.PhysicalDeviceTypeDiscreteGpu:   4,
+	vulkan.PhysicalDeviceTypeIntegratedGpu: 3,
+	vulkan.PhysicalDeviceTypeVirtualGpu:    2,
+	vulkan.PhysicalDeviceTypeCpu:           1,
+}
+
+func getProperties(device vulkan.PhysicalDevice) vulkan.PhysicalDeviceProperties {
+	var properties vulkan.PhysicalDeviceProperties
+	vulkan.GetPhysicalDeviceProperties(device, &properties)
+	properties.Deref()
+	properties.Limits.Deref()
+
+	return properties
+}
+
+func enumeratePhysicalDevices(instance vulkan.Instance) ([]vulkan.PhysicalDevice, error) {
+	var devicesCount uint32
+	if err := Check(vulkan.EnumeratePhysicalDevices(instance, &devicesCount, nil), "enumerate physical devices"); err != nil {
+		return nil, err
+	}
+	devices := make([]vulkan.PhysicalDevice, devicesCount)
+	if err := Check(vulkan.EnumeratePhysicalDevices(instance, &devicesCount, devices), "enumerate physical devices"); err != nil {
+		return nil, err
+	}
+
+	return devices, nil
+}
+
+func pickPhysicalDevice(instance vulkan.Instance, requiredExtensions []string, config Config) (vulkan.PhysicalDevice, error) {
+	devices, err := enumeratePhysicalDevices(instance)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.DeviceIndex >= 0 {
+		if config.DeviceIndex >= len(devices) {
+			return nil, fmt.Errorf("device index %d out of range, %d devices found", config.DeviceIndex, len(devices))
+		}
+		suitable, err := deviceIsSuitable(devices[config.DeviceIndex], requiredExtensions)
+		if err != nil {
+			return nil, err
+		}
+		if !suitable {
+			return nil, fmt.Errorf("device %d does not support %v", config.DeviceIndex, requiredExtensions)
+		}
+		return devices[config.DeviceIndex], nil
+	}
+
+	var best vulkan.PhysicalDevice
+	bestScore := -1
+	for _, device := range devices {
+		properties := getProperties(device)
+		name := vulkan.ToString(properties.DeviceName[:])
+		if config.DeviceName != "" && !strings.Contains(strings.ToLower(name), strings.ToLower(config.DeviceName)) {
+			continue
+		}
+		suitable, err := deviceIsSuitable(device, requiredExtensions)
+		if err != nil {
+			return nil, err
+		}
+		if !suitable {
+			continue
+		}
+
+		if score := deviceTypeScores[properties.DeviceType]; score > bestScore {
+			best, bestScore = device, score
+		}
+	}
+
+	if best == nil {
+		if config.DeviceName != "" {
+			return nil, fmt.Errorf("no suitable device matches %q", config.DeviceName)
+		}
+		return nil, errors.New("no suitable device found")
+	}
+
+	return best, nil
+}
+
+type Limits struct {
+	MaxImageDimension2D            uint32
+	MaxStorageBufferRange          uint32
+	MaxPushConstantsSize           uint32
+	MaxBoundDescriptorSets         uint32
+	MaxComputeSharedMemorySize     uint32
+	MaxComputeWorkGroupCount       [3]uint32
+	MaxComputeWorkGroupInvocations uint32
+	MaxComputeWorkGroupSize        [3]uint32
+	TimestampPeriod                float32
+}
+
+type QueueFamilyInfo struct {
+	Count              uint32
+	Graphics           bool
+	Compute            bool
+	Transfer           bool
+	TimestampValidBits uint32
+}
+
+type MemoryHeapInfo struct {
+	Size        uint64
+	DeviceLocal bool
+	// MemoryTypes lists the property flags of every memory type backed by
+	// the heap.
+	MemoryTypes []vulkan.MemoryPropertyFlags
+}
+
+type Info struct {
+	Index         int
+	Name          string
+	Type          string
+	Score         int
+	APIVersion    string
+	DriverVersion uint32
+	VendorID      uint32
+	DeviceID      uint32
+	Swapchain     bool
+	Limits        Limits
+	QueueFamilies []QueueFamilyInfo
+	MemoryHeaps   []MemoryHeapInfo
+}
+
+// Enumerate describes every physical device visible to the Vulkan loader,
+// in enumeration order, for use with Config.DeviceIndex.
+func Enumerate() ([]Info, error) {
+	instance, err := newInstance(false, false, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer vulkan.DestroyInstance(instance, nil)
+
+	devices, err := enumeratePhysicalDevices(instance)
+	if err != nil {
+		return nil, err
+	}
+
+	var infos []Info
+	for i, device := range devices {
+		properties := getProperties(device)
+		limits := properties.Limits
+		extensions, err := getDeviceExtensions(device)
+		if err != nil {
+			return nil, err
+		}
+
+		info := Info{
+			Index:         i,
+			Name:          vulkan.ToString(properties.DeviceName[:]),
+			Type:          deviceTypeNames[properties.DeviceType],
+			Score:         deviceTypeScores[properties.DeviceType],
+			APIVersion:    vulkan.Version(properties.ApiVersion).String(),
+			DriverVersion: properties.DriverVersion,
+			VendorID:      properties.VendorID,
+			DeviceID:      properties.DeviceID,
+			Swapchain:     extensions[vulkan.KhrSwapchainExtensionName],
+			Limits: Limits{
+				MaxImageDimension2D:            limits.MaxImageDimension2D,
+				MaxStorageBufferRange:          limits.MaxStorageBufferRange,
+				MaxPushConstantsSize:           limits.MaxPushConstantsSize,
+				MaxBoundDescriptorSets:         limits.MaxBoundDescriptorSets,
+				MaxComputeSharedMemorySize:     limits.MaxComputeSharedMemorySize,
+				MaxComputeWorkGroupCount:       limits.MaxComputeWorkGroupCount,
+				MaxComputeWorkGroupInvocations: limits.MaxComputeWorkGroupInvocations,
+				MaxComputeWorkGroupSize:        limits.MaxComputeWorkGroupSize,
+				TimestampPeriod:                limits.TimestampPeriod,
+			},
+		}
+
+		for _, family := range getQueueFamilies(device) {
+			family.Deref()
+			info.QueueFamilies = append(info.QueueFamilies, QueueFamilyInfo{
+				Count:              family.QueueCount,
+				Graphics:           family.QueueFlags&vulkan.QueueFlags(vulkan.QueueGraphicsBit) != 0,
+				Compute:            family.QueueFlags&vulkan.QueueFlags(vulkan.QueueComputeBit) != 0,
+				Transfer:           family.QueueFlags&vulkan.QueueFlags(vulkan.QueueTransferBit) != 0,
+				TimestampValidBits: family.TimestampValidBits,
+			})
+		}
+
+		var memProperties vulkan.PhysicalDeviceMemoryProperties
+		vulkan.GetPhysicalDeviceMemoryProperties(device, &memProperties)
+		memProperties.Deref()
+		for h := range memProperties.MemoryHeapCount {
+			heap := memProperties.MemoryHeaps[h]
+			heap.Deref()
+			info.MemoryHeaps = append(info.MemoryHeaps, MemoryHeapInfo{
+				Size:        uint64(heap.Size),
+				DeviceLocal: heap.Flags&vulkan.MemoryHeapFlags(vulkan.MemoryHeapDeviceLocalBit) != 0,
+			})
+		}
+		for t := range memProperties.MemoryTypeCount {
+			memoryType := memProperties.MemoryTypes[t]
+			memoryType.Deref()
+			heap := &info.MemoryHeaps[memoryType.HeapIndex]
+			heap.MemoryTypes = append(heap.MemoryTypes, memoryType.PropertyFlags)
+		}
+
+		infos = append(infos, info)
+	}
+
+	return infos, nil
+}
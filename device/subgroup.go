@@ -0,0 +1,68 @@
+package device
+
+/*
+#include <stdint.h>
+
+// vkGetPhysicalDeviceProperties2 is not wrapped by the bindings either. Only
+// the chained subgroup properties are read, so the core properties are given
+// an opaque buffer comfortably larger than VkPhysicalDeviceProperties.
+
+typedef struct {
+	int32_t sType;
+	void* pNext;
+	uint32_t subgroupSize;
+	uint32_t supportedStages;
+	uint32_t supportedOperations;
+	uint32_t quadOperationsInAllStages;
+} SubgroupProperties;
+
+typedef struct {
+	int32_t sType;
+	void* pNext;
+	unsigned char properties[2048];
+} Properties2;
+
+typedef void (*PFN_void)(void);
+typedef void (*PFN_getProperties2)(void*, Properties2*);
+
+extern PFN_void (*vgo_vkGetInstanceProcAddr)(void*, const char*);
+
+static uint32_t subgroupSize(void* instance, void* physicalDevice, int32_t properties2Type, int32_t subgroupType) {
+	PFN_getProperties2 getProperties2 = (PFN_getProperties2)vgo_vkGetInstanceProcAddr(instance, "vkGetPhysicalDeviceProperties2");
+	if (getProperties2 == NULL) {
+		return 0;
+	}
+
+	SubgroupProperties subgroup = {subgroupType, NULL, 0, 0, 0, 0};
+	Properties2 properties = {properties2Type, &subgroup};
+	getProperties2(physicalDevice, &properties);
+	return subgroup.subgroupSize;
+}
+*/
+import "C"
+
+import (
+	"unsafe"
+
+	"github.com/goki/vulkan"
+)
+
+// SubgroupSize returns the number of invocations per subgroup, or 0 when
+// the device does not report it.
+func (v *Device) SubgroupSize() uint32 {
+	if getProperties(v.physicalDevice).ApiVersion < vulkan.MakeVersion(1, 1, 0) {
+		return 0
+	}
+
+	return uint32(C.subgroupSize(
+		unsafe.Pointer(v.instance),
+		unsafe.Pointer(v.physicalDevice),
+		C.int32_t(vulkan.StructureTypePhysicalDeviceProperties2),
+		C.int32_t(vulkan.StructureTypePhysicalDeviceSubgroupProperties),
+	))
+}
+
+// Limits returns the limits of the physical device.
+func (v *Device) Limits() vulkan.PhysicalDeviceLimits {
+	return getProperties(v.physicalDevice).Limits
+}
@@ -1,56 +1,192 @@
 package drawer
 
 import (
+	"errors"
 	"game/device"
+	"game/gravity"
+	"game/model"
 	"game/object"
 	"game/pipeline"
-	"time"
+	"game/transfer"
 	"unsafe"
 
 	"github.com/goki/vulkan"
 )
 
+// Drawer draws all masses and all field arrows with one indirect, instanced
+// draw each. The instance counts are written by the field pass. Trails are
+// drawn below them, one instanced draw per run of bodies that show one.
 type Drawer struct {
-	pipeline         *pipeline.Pipeline
-	lastRenderedTime time.Time
+	device    *device.Device
+	pipeline  *pipeline.Pipeline
+	trails    *pipeline.Pipeline
+	instances vulkan.Buffer
+	memory    *device.Allocation
+	batches   []batch
+	trailRuns []run
+}
+
+// run is a range of consecutive mass instances.
+type run struct {
+	first, count uint32
+}
+
+// batch is one indirect draw of a model.
+type batch struct {
+	model   *model.Model
+	isField uint32
+	command int
+	// offset of the first instance in the instance buffer
+	offset vulkan.DeviceSize
 }
 
 func New(
-	device *device.Device,
+	d *device.Device,
+	t *transfer.Manager,
 	renderPass vulkan.RenderPass,
-	descriptorsLayout vulkan.DescriptorSetLayout,
-) *Drawer {
-	return &Drawer{
-		pipeline:         pipeline.New(device, renderPass, descriptorsLayout),
-		lastRenderedTime: time.Now(),
+	samples vulkan.SampleCountFlagBits,
+	colorSpace vulkan.ColorSpace,
+	descriptorsLayouts []vulkan.DescriptorSetLayout,
+	gameObjects []*object.GameObject,
+) (*Drawer, error) {
+	dr := &Drawer{device: d}
+	var err error
+	if dr.pipeline, err = pipeline.New(d, renderPass, samples, colorSpace, descriptorsLayouts, pipeline.Scene); err != nil {
+		return nil, err
+	}
+	if dr.trails, err = pipeline.New(d, renderPass, samples, colorSpace, descriptorsLayouts, pipeline.Trails); err != nil {
+		dr.Close()
+		return nil, err
+	}
+
+	// instances are ordered like the simulation buffers, so that the
+	// instance index also indexes the masses and forces
+	var masses, fields []model.Instance
+	for _, obj := range gameObjects {
+		if obj.Field != nil {
+			fields = append(fields, obj.ToInstance())
+			dr.addBatch(obj.Model, 1, gravity.DrawFields)
+		} else {
+			if !obj.Mass.HideTrail {
+				dr.addTrail(uint32(len(masses)))
+			}
+			masses = append(masses, obj.ToInstance())
+			dr.addBatch(obj.Model, 0, gravity.DrawMasses)
+		}
+	}
+	instances := append(masses, fields...)
+	for i := range dr.batches {
+		if dr.batches[i].isField == 1 {
+			dr.batches[i].offset = vulkan.DeviceSize(len(masses)) * vulkan.DeviceSize(unsafe.Sizeof(model.Instance{}))
+		}
 	}
+	if len(instances) == 0 {
+		return dr, nil
+	}
+
+	if dr.instances, dr.memory, err = d.CreateBuffer(
+		vulkan.DeviceSize(len(instances))*vulkan.DeviceSize(unsafe.Sizeof(model.Instance{})),
+		vulkan.BufferUsageFlags(vulkan.BufferUsageVertexBufferBit|vulkan.BufferUsageTransferDstBit),
+		vulkan.MemoryPropertyFlags(vulkan.MemoryPropertyDeviceLocalBit),
+	); err != nil {
+		dr.Close()
+		return nil, err
+	}
+	d.NameBuffer(dr.instances, "instances")
+
+	// the copy completes asynchronously, callers wait on the manager before
+	// the first draw
+	if err := transfer.Upload(t, instances, dr.instances); err != nil {
+		dr.Close()
+		return nil, err
+	}
+
+	return dr, nil
 }
 
-func (d *Drawer) RenderGameObects(
+func (d *Drawer) addBatch(m *model.Model, isField uint32, command int) {
+	for _, b := range d.batches {
+		if b.command == command {
+			return
+		}
+	}
+	d.batches = append(d.batches, batch{model: m, isField: isField, command: command})
+}
+
+func (d *Drawer) addTrail(body uint32) {
+	if n := len(d.trailRuns); n > 0 && d.trailRuns[n-1].first+d.trailRuns[n-1].count == body {
+		d.trailRuns[n-1].count++
+		return
+	}
+	d.trailRuns = append(d.trailRuns, run{first: body, count: 1})
+}
+
+// Draw records the draws of frameIdx, reading the draw commands from
+// drawBuffer. descriptors are the simulation and camera sets of the frame.
+func (d *Drawer) Draw(
 	commandBuffer vulkan.CommandBuffer,
-	descriptors vulkan.DescriptorSet,
-	gameObjects []*object.GameObject,
+	descriptors []vulkan.DescriptorSet,
+	drawBuffer vulkan.Buffer,
+	trail gravity.Trail,
 ) {
-	d.pipeline.Bind(commandBuffer, descriptors)
+	if trail.Count >= 2 && len(d.trailRuns) > 0 {
+		d.trails.Bind(commandBuffer, descriptors)
+		vulkan.CmdPushConstants(
+			commandBuffer,
+			d.trails.Layout,
+			vulkan.ShaderStageFlags(vulkan.ShaderStageVertexBit),
+			0,
+			uint32(unsafe.Sizeof(pipeline.TrailPushData{})),
+			unsafe.Pointer(&pipeline.TrailPushData{Head: trail.Head, Length: trail.Length, Count: trail.Count}),
+		)
+		vulkan.CmdBindVertexBuffers(commandBuffer, 1, 1, []vulkan.Buffer{d.instances}, []vulkan.DeviceSize{0})
+		for _, r := range d.trailRuns {
+			vulkan.CmdDraw(commandBuffer, trail.Count, r.count, 0, r.first)
+		}
+	}
 
-	since := time.Since(d.lastRenderedTime)
-	d.lastRenderedTime = time.Now()
+	d.pipeline.Bind(commandBuffer, descriptors)
 
-	for _, obj := range gameObjects {
+	for _, b := range d.batches {
 		vulkan.CmdPushConstants(
 			commandBuffer,
 			d.pipeline.Layout,
-			vulkan.ShaderStageFlags(vulkan.ShaderStageVertexBit|vulkan.ShaderStageFragmentBit),
+			vulkan.ShaderStageFlags(vulkan.ShaderStageVertexBit),
 			0,
 			uint32(unsafe.Sizeof(pipeline.PushData{})),
-			unsafe.Pointer(obj.ToPushData(since)),
+			unsafe.Pointer(&pipeline.PushData{IsField: b.isField}),
 		)
 
-		obj.Model.Bind(commandBuffer)
-		obj.Model.Draw(commandBuffer)
+		b.model.Bind(commandBuffer)
+		vulkan.CmdBindVertexBuffers(commandBuffer, 1, 1, []vulkan.Buffer{d.instances}, []vulkan.DeviceSize{b.offset})
+		vulkan.CmdDrawIndirect(
+			commandBuffer,
+			drawBuffer,
+			vulkan.DeviceSize(b.command*gravity.DrawCommandSize),
+			1,
+			uint32(gravity.DrawCommandSize),
+		)
 	}
 }
 
+// UsesShader reports whether one of the pipelines is built from the SPIR-V
+// file.
+func (d *Drawer) UsesShader(name string) bool {
+	return pipeline.Scene.UsesShader(name) || pipeline.Trails.UsesShader(name)
+}
+
+// Reload rebuilds the pipelines from the SPIR-V files on disk.
+func (d *Drawer) Reload() error {
+	return errors.Join(d.pipeline.Reload(), d.trails.Reload())
+}
+
 func (d *Drawer) Close() {
-	d.pipeline.Close()
+	if d.pipeline != nil {
+		d.pipeline.Close()
+	}
+	if d.trails != nil {
+		d.trails.Close()
+	}
+	vulkan.DestroyBuffer(d.device.LogicalDevice, d.instances, nil)
+	d.device.Free(d.memory)
 }
@@ -1,11 +1,15 @@
 package gravity
 
 import (
+	"errors"
+	"fmt"
 	"game/device"
+	"game/layout"
+	"game/model"
 	"game/object"
 	"game/shader"
-	"game/swapchain"
-	"time"
+	"game/transfer"
+	"runtime"
 	"unsafe"
 
 	"github.com/goki/vulkan"
@@ -15,23 +19,66 @@ type ObjectWithMass struct {
 	position [2]float32
 	velocity [2]float32
 	mass     float32
-	_        [3]float32 // padding to make struct 24 bytes (multiple of 8)
+}
+
+func (o ObjectWithMass) Position() [2]float32 {
+	return o.position
+}
+
+func (o ObjectWithMass) Velocity() [2]float32 {
+	return o.velocity
+}
+
+func (o ObjectWithMass) Mass() float32 {
+	return o.mass
 }
 
 type ForceField struct {
 	force [2]float32
-	_     [2]float32
 }
 
 type VectorField struct {
 	position [2]float32
-	_        [2]float32
 }
 
+// DrawCommand is a VkDrawIndirectCommand. The field pass fills in the
+// instance counts, one command draws the masses and one the field arrows.
+type DrawCommand struct {
+	VertexCount   uint32
+	InstanceCount uint32
+	FirstVertex   uint32
+	FirstInstance uint32
+}
+
+// TrailPoint is an element of the trail ring buffers.
+type TrailPoint struct {
+	position [2]float32
+}
+
+// indices of the draw commands in the draw buffer
+const (
+	DrawMasses = iota
+	DrawFields
+)
+
+// std140 layouts of the storage buffer elements
+var (
+	massLayout  = layout.Must[ObjectWithMass](layout.Std140)
+	forceLayout = layout.Must[ForceField](layout.Std140)
+	fieldLayout = layout.Must[VectorField](layout.Std140)
+	drawLayout  = layout.Must[DrawCommand](layout.Std430)
+	trailLayout = layout.Must[TrailPoint](layout.Std430)
+)
+
+// DrawCommandSize is the stride of the commands in a draw buffer.
+var DrawCommandSize = drawLayout.Size
+
 type pushMassData struct {
 	timeSince   float32
 	numElements uint32
-	_           [2]float32
+	trailLength uint32
+	// trailSlot is out of range for steps that do not record the trail
+	trailSlot uint32
 }
 
 type pushFieldData struct {
@@ -42,9 +89,12 @@ type pushFieldData struct {
 
 type Buffers struct {
 	massBuffer  vulkan.Buffer
-	massMemory  vulkan.DeviceMemory
+	massMemory  *device.Allocation
 	forceBuffer vulkan.Buffer
-	forceMemory vulkan.DeviceMemory
+	forceMemory *device.Allocation
+	drawBuffer  vulkan.Buffer
+	drawMemory  *device.Allocation
+	readback    readback
 }
 
 type Gravity struct {
@@ -53,51 +103,190 @@ type Gravity struct {
 	pipelinesLayout            vulkan.PipelineLayout
 	DescriptorsSets            []vulkan.DescriptorSet
 	DescriptorsLayout          vulkan.DescriptorSetLayout
-	lastRenderedTime           time.Time
 	buffers                    []Buffers
 	vecBuffer                  vulkan.Buffer
-	vecMemory                  vulkan.DeviceMemory
+	vecMemory                  *device.Allocation
+	trailBuffer                vulkan.Buffer
+	trailMemory                *device.Allocation
 	massModule                 vulkan.ShaderModule
 	fieldModule                vulkan.ShaderModule
 	descriptorsPool            vulkan.DescriptorPool
 	computeFinished            []vulkan.Semaphore
 	computeFinishedForGraphics []vulkan.Semaphore
 
+	options            Options
+	workgroupSize      uint32
+	framesInFlight     int
 	massElementsCount  int
 	fieldElementsCount int
+	// index of the mass buffer holding the most recent simulation state
+	latestIdx int
+	// slot of the trail ring buffers written last
+	trailHead int
+	// simulated seconds integrated so far
+	simulatedTime float64
+	// latest mass state copied back by the frames in flight
+	snapshot Snapshot
+	// state uploaded by UploadMassObjects, restored by Reset
+	initial []ObjectWithMass
+
+	// Profiler, when set, times the per-frame gravity and field passes.
+	Profiler *device.Profiler
+}
+
+// names of the profiled passes
+const (
+	PassGravity = "gravity"
+	PassField   = "field"
+)
+
+// SPIR-V files the compute pipelines are built from.
+const (
+	GravityShader = "gravity.comp.spv"
+	FieldShader   = "field.comp.spv"
+)
+
+// preferredWorkgroupSize is used unless the device limits call for less.
+const preferredWorkgroupSize = 256
+
+type Integrator uint32
+
+const (
+	IntegratorSymplecticEuler Integrator = iota
+	IntegratorExplicitEuler
+)
+
+var integratorNames = []string{"symplectic", "explicit"}
+
+func (i Integrator) String() string {
+	if int(i) < len(integratorNames) {
+		return integratorNames[i]
+	}
+
+	return fmt.Sprintf("Integrator(%d)", i)
+}
+
+func ParseIntegrator(name string) (Integrator, error) {
+	for i, n := range integratorNames {
+		if n == name {
+			return Integrator(i), nil
+		}
+	}
+
+	return 0, fmt.Errorf("unknown integrator %q, want one of %v", name, integratorNames)
+}
+
+// Options configure the simulation. Softening and Integrator are compiled
+// into the compute pipelines as specialization constants.
+type Options struct {
+	// Softening replaces the close encounter cutoff with Plummer softening
+	// of this length, zero keeps the cutoff.
+	Softening  float32
+	Integrator Integrator
+	// TrailLength is the number of past positions kept per body, recorded
+	// once per frame. Zero disables trails.
+	TrailLength int
+	// Dt is the longest integration step frames are split into.
+	Dt float32
+}
+
+var DefaultOptions = Options{
+	TrailLength: 256,
+	Dt:          0.001,
+}
+
+// specialization matches the constant_id declarations of the compute
+// shaders.
+type specialization struct {
+	workgroupSize   uint32
+	softening       vulkan.Bool32
+	softeningLength float32
+	integrator      uint32
+}
+
+var specializationEntries = []vulkan.SpecializationMapEntry{
+	{ConstantID: 0, Offset: uint32(unsafe.Offsetof(specialization{}.workgroupSize)), Size: 4},
+	{ConstantID: 1, Offset: uint32(unsafe.Offsetof(specialization{}.softening)), Size: 4},
+	{ConstantID: 2, Offset: uint32(unsafe.Offsetof(specialization{}.softeningLength)), Size: 4},
+	{ConstantID: 3, Offset: uint32(unsafe.Offsetof(specialization{}.integrator)), Size: 4},
+}
+
+// chooseWorkgroupSize fits the preferred size into the device limits and
+// rounds it down to whole subgroups.
+func chooseWorkgroupSize(d *device.Device) uint32 {
+	limits := d.Limits()
+	size := min(preferredWorkgroupSize, limits.MaxComputeWorkGroupSize[0], limits.MaxComputeWorkGroupInvocations)
+	if subgroup := d.SubgroupSize(); subgroup > 0 && size >= subgroup {
+		size -= size % subgroup
+	}
+
+	return size
+}
+
+// steps recorded into a single command buffer by Simulate
+const maxStepsPerSubmit = 1024
+
+func (g *Gravity) groupCount(elements int) uint32 {
+	return (uint32(elements) + g.workgroupSize - 1) / g.workgroupSize
 }
 
 func createSyncObjects(
-	device *device.Device,
-) ([]vulkan.Semaphore, []vulkan.Semaphore) {
-	computeFinished := make([]vulkan.Semaphore, swapchain.MAX_FRAMES_IN_FLIGHT)
-	computeFinishedForGraphics := make([]vulkan.Semaphore, swapchain.MAX_FRAMES_IN_FLIGHT)
+	d *device.Device,
+	framesInFlight int,
+) ([]vulkan.Semaphore, []vulkan.Semaphore, error) {
+	computeFinished := make([]vulkan.Semaphore, framesInFlight)
+	computeFinishedForGraphics := make([]vulkan.Semaphore, framesInFlight)
 
 	for i := range computeFinished {
-		if err := vulkan.Error(vulkan.CreateSemaphore(device.LogicalDevice, &vulkan.SemaphoreCreateInfo{
+		if err := device.Check(vulkan.CreateSemaphore(d.LogicalDevice, &vulkan.SemaphoreCreateInfo{
 			SType: vulkan.StructureTypeSemaphoreCreateInfo,
-		}, nil, &computeFinished[i])); err != nil {
-			panic("failed to create image semaphore: " + err.Error())
+		}, nil, &computeFinished[i]), "create compute semaphore"); err != nil {
+			return computeFinished, computeFinishedForGraphics, err
 		}
 
-		if err := vulkan.Error(vulkan.CreateSemaphore(device.LogicalDevice, &vulkan.SemaphoreCreateInfo{
+		if err := device.Check(vulkan.CreateSemaphore(d.LogicalDevice, &vulkan.SemaphoreCreateInfo{
 			SType: vulkan.StructureTypeSemaphoreCreateInfo,
-		}, nil, &computeFinishedForGraphics[i])); err != nil {
-			panic("failed to create image semaphore: " + err.Error())
+		}, nil, &computeFinishedForGraphics[i]), "create compute semaphore"); err != nil {
+			return computeFinished, computeFinishedForGraphics, err
 		}
 	}
 
-	return computeFinished, computeFinishedForGraphics
+	return computeFinished, computeFinishedForGraphics, nil
 }
 
-func New(device *device.Device) *Gravity {
-	massModule := shader.CreateShaderModule("shaders/gravity.comp.spv", device.LogicalDevice)
-	forceModule := shader.CreateShaderModule("shaders/field.comp.spv", device.LogicalDevice)
+// New creates the compute side of the simulation with one mass buffer per
+// frame in flight, which the integration steps cycle through. A step reads
+// the previous buffer and writes the next, so at least two are needed.
+func New(d *device.Device, framesInFlight int, options Options) (*Gravity, error) {
+	if framesInFlight < 2 {
+		return nil, fmt.Errorf("invalid number of frames in flight %d, the mass buffers need at least 2", framesInFlight)
+	}
+	if options.TrailLength < 0 {
+		return nil, fmt.Errorf("invalid trail length %d", options.TrailLength)
+	}
+
+	g := &Gravity{
+		device:         d,
+		options:        options,
+		workgroupSize:  chooseWorkgroupSize(d),
+		framesInFlight: framesInFlight,
+		buffers:        make([]Buffers, framesInFlight),
+		latestIdx:      framesInFlight - 1,
+	}
+	if err := g.init(); err != nil {
+		g.Close()
+		return nil, err
+	}
 
-	var descriptorsLayout vulkan.DescriptorSetLayout
-	if err := vulkan.Error(vulkan.CreateDescriptorSetLayout(device.LogicalDevice, &vulkan.DescriptorSetLayoutCreateInfo{
+	return g, nil
+}
+
+func (g *Gravity) init() error {
+	d := g.device
+
+	if err := device.Check(vulkan.CreateDescriptorSetLayout(d.LogicalDevice, &vulkan.DescriptorSetLayoutCreateInfo{
 		SType:        vulkan.StructureTypeDescriptorSetLayoutCreateInfo,
-		BindingCount: 4,
+		BindingCount: 6,
 		PBindings: []vulkan.DescriptorSetLayoutBinding{
 			{ // mass previous frame (in)
 				Binding:         0,
@@ -123,42 +312,53 @@ func New(device *device.Device) *Gravity {
 				DescriptorType:  vulkan.DescriptorTypeStorageBuffer,
 				StageFlags:      vulkan.ShaderStageFlags(vulkan.ShaderStageVertexBit | vulkan.ShaderStageComputeBit),
 			},
+			{ // draw commands (out)
+				Binding:         4,
+				DescriptorCount: 1,
+				DescriptorType:  vulkan.DescriptorTypeStorageBuffer,
+				StageFlags:      vulkan.ShaderStageFlags(vulkan.ShaderStageComputeBit),
+			},
+			{ // trails (out)
+				Binding:         5,
+				DescriptorCount: 1,
+				DescriptorType:  vulkan.DescriptorTypeStorageBuffer,
+				StageFlags:      vulkan.ShaderStageFlags(vulkan.ShaderStageVertexBit | vulkan.ShaderStageComputeBit),
+			},
 		},
-	}, nil, &descriptorsLayout)); err != nil {
-		panic("failed to create descriptor set layout: " + err.Error())
+	}, nil, &g.DescriptorsLayout), "create descriptor set layout"); err != nil {
+		return err
 	}
 
-	var descriptorsPool vulkan.DescriptorPool
-	if err := vulkan.Error(vulkan.CreateDescriptorPool(device.LogicalDevice, &vulkan.DescriptorPoolCreateInfo{
+	if err := device.Check(vulkan.CreateDescriptorPool(d.LogicalDevice, &vulkan.DescriptorPoolCreateInfo{
 		SType:         vulkan.StructureTypeDescriptorPoolCreateInfo,
 		PoolSizeCount: 1,
 		PPoolSizes: []vulkan.DescriptorPoolSize{
 			{
 				Type:            vulkan.DescriptorTypeStorageBuffer,
-				DescriptorCount: 4 * swapchain.MAX_FRAMES_IN_FLIGHT,
+				DescriptorCount: uint32(6 * g.framesInFlight),
 			},
 		},
-		MaxSets: swapchain.MAX_FRAMES_IN_FLIGHT,
-	}, nil, &descriptorsPool)); err != nil {
-		panic("failed to create descriptor pool: " + err.Error())
+		MaxSets: uint32(g.framesInFlight),
+	}, nil, &g.descriptorsPool), "create descriptor pool"); err != nil {
+		return err
 	}
 
-	descriptorsSets := make([]vulkan.DescriptorSet, swapchain.MAX_FRAMES_IN_FLIGHT)
-	for i := range swapchain.MAX_FRAMES_IN_FLIGHT {
-		if err := vulkan.Error(vulkan.AllocateDescriptorSets(device.LogicalDevice, &vulkan.DescriptorSetAllocateInfo{
+	g.DescriptorsSets = make([]vulkan.DescriptorSet, g.framesInFlight)
+	for i := range g.framesInFlight {
+		if err := device.Check(vulkan.AllocateDescriptorSets(d.LogicalDevice, &vulkan.DescriptorSetAllocateInfo{
 			SType:              vulkan.StructureTypeDescriptorSetAllocateInfo,
-			DescriptorPool:     descriptorsPool,
+			DescriptorPool:     g.descriptorsPool,
 			DescriptorSetCount: 1,
 			PSetLayouts: []vulkan.DescriptorSetLayout{
-				descriptorsLayout,
+				g.DescriptorsLayout,
 			},
-		}, &descriptorsSets[i])); err != nil {
-			panic("failed to allocate descriptor sets: " + err.Error())
+		}, &g.DescriptorsSets[i]), "allocate descriptor sets"); err != nil {
+			return err
 		}
+		d.NameDescriptorSet(g.DescriptorsSets[i], fmt.Sprintf("gravity[frame %d]", i))
 	}
 
-	var layout vulkan.PipelineLayout
-	if err := vulkan.Error(vulkan.CreatePipelineLayout(device.LogicalDevice, &vulkan.PipelineLayoutCreateInfo{
+	if err := device.Check(vulkan.CreatePipelineLayout(d.LogicalDevice, &vulkan.PipelineLayoutCreateInfo{
 		SType:                  vulkan.StructureTypePipelineLayoutCreateInfo,
 		PushConstantRangeCount: 1,
 		PPushConstantRanges: []vulkan.PushConstantRange{
@@ -170,131 +370,232 @@ func New(device *device.Device) *Gravity {
 		},
 		SetLayoutCount: 1,
 		PSetLayouts: []vulkan.DescriptorSetLayout{
-			descriptorsLayout,
+			g.DescriptorsLayout,
+		},
+	}, nil, &g.pipelinesLayout), "create pipeline layout"); err != nil {
+		return err
+	}
+
+	var err error
+	if g.massModule, g.fieldModule, g.pipelines, err = g.createPipelines(); err != nil {
+		return err
+	}
+
+	g.computeFinished, g.computeFinishedForGraphics, err = createSyncObjects(d, g.framesInFlight)
+	if err != nil {
+		return err
+	}
+	for i := range g.framesInFlight {
+		d.NameSemaphore(g.computeFinished[i], fmt.Sprintf("computeFinished[frame %d]", i))
+		d.NameSemaphore(g.computeFinishedForGraphics[i], fmt.Sprintf("computeFinishedForGraphics[frame %d]", i))
+	}
+
+	return device.Check(vulkan.QueueSubmit(d.ComputeQueue, 1, []vulkan.SubmitInfo{
+		{
+			SType:                vulkan.StructureTypeSubmitInfo,
+			SignalSemaphoreCount: 1,
+			PSignalSemaphores: []vulkan.Semaphore{
+				g.computeFinished[g.framesInFlight-1],
+			},
+		},
+	}, nil), "submit compute command buffer")
+}
+
+// createPipelines builds the gravity and field pipelines from the current
+// SPIR-V files.
+func (g *Gravity) createPipelines() (vulkan.ShaderModule, vulkan.ShaderModule, []vulkan.Pipeline, error) {
+	d := g.device
+
+	if err := CheckLayouts(); err != nil {
+		return nil, nil, nil, err
+	}
+
+	massModule, err := shader.CreateShaderModule(GravityShader, d.LogicalDevice)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	fieldModule, err := shader.CreateShaderModule(FieldShader, d.LogicalDevice)
+	if err != nil {
+		vulkan.DestroyShaderModule(d.LogicalDevice, massModule, nil)
+		return nil, nil, nil, err
+	}
+
+	constants := specialization{
+		workgroupSize:   g.workgroupSize,
+		softening:       vulkan.False,
+		softeningLength: g.options.Softening,
+		integrator:      uint32(g.options.Integrator),
+	}
+	if g.options.Softening > 0 {
+		constants.softening = vulkan.True
+	}
+	// the bindings keep the data pointer in C memory for the call
+	var pinner runtime.Pinner
+	pinner.Pin(&constants)
+	defer pinner.Unpin()
+	specializationInfo := []vulkan.SpecializationInfo{
+		{
+			MapEntryCount: uint32(len(specializationEntries)),
+			PMapEntries:   specializationEntries,
+			DataSize:      uint64(unsafe.Sizeof(constants)),
+			PData:         unsafe.Pointer(&constants),
 		},
-	}, nil, &layout)); err != nil {
-		panic("failed to create pipeline layout: " + err.Error())
 	}
 
 	pipelines := make([]vulkan.Pipeline, 2)
-	if err := vulkan.Error(vulkan.CreateComputePipelines(device.LogicalDevice, nil, 2, []vulkan.ComputePipelineCreateInfo{
+	if err := device.Check(vulkan.CreateComputePipelines(d.LogicalDevice, d.PipelineCache, 2, []vulkan.ComputePipelineCreateInfo{
 		{
 			SType: vulkan.StructureTypeComputePipelineCreateInfo,
 			Stage: vulkan.PipelineShaderStageCreateInfo{
-				SType:  vulkan.StructureTypePipelineShaderStageCreateInfo,
-				Stage:  vulkan.ShaderStageComputeBit,
-				Module: massModule,
-				PName:  "main\x00",
+				SType:               vulkan.StructureTypePipelineShaderStageCreateInfo,
+				Stage:               vulkan.ShaderStageComputeBit,
+				Module:              massModule,
+				PName:               "main\x00",
+				PSpecializationInfo: specializationInfo,
 			},
-			Layout: layout,
+			Layout: g.pipelinesLayout,
 		},
 		{
 			SType: vulkan.StructureTypeComputePipelineCreateInfo,
 			Stage: vulkan.PipelineShaderStageCreateInfo{
-				SType:  vulkan.StructureTypePipelineShaderStageCreateInfo,
-				Stage:  vulkan.ShaderStageComputeBit,
-				Module: forceModule,
-				PName:  "main\x00",
+				SType:               vulkan.StructureTypePipelineShaderStageCreateInfo,
+				Stage:               vulkan.ShaderStageComputeBit,
+				Module:              fieldModule,
+				PName:               "main\x00",
+				PSpecializationInfo: specializationInfo,
 			},
-			Layout: layout,
+			Layout: g.pipelinesLayout,
 		},
-	}, nil, pipelines)); err != nil {
-		panic("failed to create compute pipeline: " + err.Error())
+	}, nil, pipelines), "create compute pipelines"); err != nil {
+		vulkan.DestroyShaderModule(d.LogicalDevice, massModule, nil)
+		vulkan.DestroyShaderModule(d.LogicalDevice, fieldModule, nil)
+		return nil, nil, nil, err
 	}
+	d.NamePipeline(pipelines[0], PassGravity)
+	d.NamePipeline(pipelines[1], PassField)
 
-	computeFinished, computeFinishedForGraphics := createSyncObjects(device)
-	if err := vulkan.Error(vulkan.QueueSubmit(device.ComputeQueue, 1, []vulkan.SubmitInfo{
-		{
-			SType:                vulkan.StructureTypeSubmitInfo,
-			SignalSemaphoreCount: 1,
-			PSignalSemaphores: []vulkan.Semaphore{
-				computeFinished[swapchain.MAX_FRAMES_IN_FLIGHT-1],
-			},
-		},
-	}, nil)); err != nil {
-		panic("failed to submit compute command buffer: " + err.Error())
-	}
+	return massModule, fieldModule, pipelines, nil
+}
 
-	return &Gravity{
-		pipelines:         pipelines,
-		pipelinesLayout:   layout,
-		DescriptorsSets:   descriptorsSets,
-		lastRenderedTime:  time.Now(),
-		DescriptorsLayout: descriptorsLayout,
+// CheckLayouts compares the buffers and push constants the compute shaders
+// declare with the Go structs that fill them, which would otherwise only
+// show up as corrupted simulation data.
+func CheckLayouts() error {
+	gravityModule, err := shader.Reflect(GravityShader)
+	if err != nil {
+		return err
+	}
+	fieldModule, err := shader.Reflect(FieldShader)
+	if err != nil {
+		return err
+	}
 
-		buffers: make([]Buffers, swapchain.MAX_FRAMES_IN_FLIGHT),
-		device:  device,
+	if err := errors.Join(
+		gravityModule.CheckArray(0, 0, &massLayout.Block),
+		gravityModule.CheckArray(0, 1, &massLayout.Block),
+		gravityModule.CheckArray(0, 5, &trailLayout.Block),
+		gravityModule.CheckPushConstants(pushMassData{}),
+		fieldModule.CheckArray(0, 1, &massLayout.Block),
+		fieldModule.CheckArray(0, 2, &fieldLayout.Block),
+		fieldModule.CheckArray(0, 3, &forceLayout.Block),
+		fieldModule.CheckArray(0, 4, &drawLayout.Block),
+		fieldModule.CheckPushConstants(pushFieldData{}),
+	); err != nil {
+		return fmt.Errorf("compute shaders do not match the Go layouts: %w", err)
+	}
 
-		massModule:                 massModule,
-		fieldModule:                forceModule,
-		computeFinished:            computeFinished,
-		computeFinishedForGraphics: computeFinishedForGraphics,
+	return nil
+}
 
-		descriptorsPool: descriptorsPool,
+// Reload recreates the compute pipelines from the SPIR-V files on disk. The
+// current pipelines are kept when that fails. The device must be idle.
+func (g *Gravity) Reload() error {
+	massModule, fieldModule, pipelines, err := g.createPipelines()
+	if err != nil {
+		return err
 	}
-}
 
-func copyWithStagingBuffer[T any](
-	device *device.Device,
-	initialBuffer []T,
-	copyFn func(commandBuffer vulkan.CommandBuffer, staging vulkan.Buffer),
-) {
-	bufferSize := len(initialBuffer) * int(unsafe.Sizeof(initialBuffer[0]))
+	g.destroyPipelines()
+	g.massModule, g.fieldModule, g.pipelines = massModule, fieldModule, pipelines
 
-	buffer, memory := device.CreateBuffer(
-		vulkan.DeviceSize(bufferSize),
-		vulkan.BufferUsageFlags(vulkan.BufferUsageTransferSrcBit),
-		vulkan.MemoryPropertyFlags(vulkan.MemoryPropertyHostVisibleBit|vulkan.MemoryPropertyHostCoherentBit),
-	)
+	return nil
+}
 
-	var data unsafe.Pointer
-	if err := vulkan.Error(vulkan.MapMemory(device.LogicalDevice, memory, 0, vulkan.DeviceSize(bufferSize), 0, &data)); err != nil {
-		panic("failed to map buffer memory: " + err.Error())
+func (g *Gravity) destroyPipelines() {
+	for _, pipeline := range g.pipelines {
+		vulkan.DestroyPipeline(g.device.LogicalDevice, pipeline, nil)
 	}
-	slice := unsafe.Slice((*T)(data), len(initialBuffer))
-	copy(slice, initialBuffer)
-	vulkan.UnmapMemory(device.LogicalDevice, memory)
+	vulkan.DestroyShaderModule(g.device.LogicalDevice, g.massModule, nil)
+	vulkan.DestroyShaderModule(g.device.LogicalDevice, g.fieldModule, nil)
+}
 
+func submitOnce(d *device.Device, recordFn func(commandBuffer vulkan.CommandBuffer)) error {
 	commandBuffer := make([]vulkan.CommandBuffer, 1)
-	if err := vulkan.Error(vulkan.AllocateCommandBuffers(device.LogicalDevice, &vulkan.CommandBufferAllocateInfo{
+	if err := device.Check(vulkan.AllocateCommandBuffers(d.LogicalDevice, &vulkan.CommandBufferAllocateInfo{
 		SType:              vulkan.StructureTypeCommandBufferAllocateInfo,
 		Level:              vulkan.CommandBufferLevelPrimary,
-		CommandPool:        device.ComputePool,
+		CommandPool:        d.ComputePool,
 		CommandBufferCount: 1,
-	}, commandBuffer)); err != nil {
-		panic("failed to allocate command buffers: " + err.Error())
+	}, commandBuffer), "allocate command buffers"); err != nil {
+		return err
 	}
+	defer vulkan.FreeCommandBuffers(d.LogicalDevice, d.ComputePool, 1, commandBuffer)
 
-	if err := vulkan.Error(vulkan.BeginCommandBuffer(commandBuffer[0], &vulkan.CommandBufferBeginInfo{
+	if err := device.Check(vulkan.BeginCommandBuffer(commandBuffer[0], &vulkan.CommandBufferBeginInfo{
 		SType: vulkan.StructureTypeCommandBufferBeginInfo,
 		Flags: vulkan.CommandBufferUsageFlags(vulkan.CommandBufferUsageOneTimeSubmitBit),
-	})); err != nil {
-		panic("failed to begin recording command buffer: " + err.Error())
+	}), "begin recording command buffer"); err != nil {
+		return err
 	}
 
-	copyFn(commandBuffer[0], buffer)
+	recordFn(commandBuffer[0])
 
-	if err := vulkan.Error(vulkan.EndCommandBuffer(commandBuffer[0])); err != nil {
-		panic("failed to end command buffer: " + err.Error())
+	if err := device.Check(vulkan.EndCommandBuffer(commandBuffer[0]), "end command buffer"); err != nil {
+		return err
 	}
 
-	vulkan.QueueSubmit(device.ComputeQueue, 1, []vulkan.SubmitInfo{
+	if err := device.Check(vulkan.QueueSubmit(d.ComputeQueue, 1, []vulkan.SubmitInfo{
 		{
 			SType:              vulkan.StructureTypeSubmitInfo,
 			CommandBufferCount: 1,
 			PCommandBuffers:    commandBuffer,
 		},
-	}, nil)
-	vulkan.QueueWaitIdle(device.ComputeQueue)
-	vulkan.FreeCommandBuffers(device.LogicalDevice, device.ComputePool, 1, commandBuffer)
-	vulkan.DestroyBuffer(device.LogicalDevice, buffer, nil)
-	vulkan.FreeMemory(device.LogicalDevice, memory, nil)
+	}, nil), "submit compute command buffer"); err != nil {
+		return err
+	}
+
+	return device.Check(vulkan.QueueWaitIdle(d.ComputeQueue), "wait for compute queue")
+}
+
+func memoryBarrier(
+	commandBuffer vulkan.CommandBuffer,
+	srcStage vulkan.PipelineStageFlagBits,
+	dstStage vulkan.PipelineStageFlagBits,
+	srcAccess vulkan.AccessFlagBits,
+	dstAccess vulkan.AccessFlagBits,
+) {
+	vulkan.CmdPipelineBarrier(
+		commandBuffer,
+		vulkan.PipelineStageFlags(srcStage),
+		vulkan.PipelineStageFlags(dstStage),
+		0,
+		1,
+		[]vulkan.MemoryBarrier{
+			{
+				SType:         vulkan.StructureTypeMemoryBarrier,
+				SrcAccessMask: vulkan.AccessFlags(srcAccess),
+				DstAccessMask: vulkan.AccessFlags(dstAccess),
+			},
+		},
+		0, nil, 0, nil,
+	)
 }
 
 func (g *Gravity) UploadMassObjects(
-	device *device.Device,
+	d *device.Device,
+	t *transfer.Manager,
 	objects []*object.GameObject,
-) {
+) error {
 	var massObjects []ObjectWithMass
 	for _, object := range objects {
 		if object.Mass != nil {
@@ -306,30 +607,45 @@ func (g *Gravity) UploadMassObjects(
 		}
 	}
 	g.massElementsCount = len(massObjects)
-	bufferSize := int(unsafe.Sizeof(ObjectWithMass{})) * g.massElementsCount
+	bufferSize := massLayout.Size * g.massElementsCount
+	g.snapshot = Snapshot{Bodies: massObjects}
+	g.initial = massObjects
 
-	for i := range swapchain.MAX_FRAMES_IN_FLIGHT {
-		g.buffers[i].massBuffer, g.buffers[i].massMemory = device.CreateBuffer(
+	for i := range g.framesInFlight {
+		var err error
+		g.buffers[i].massBuffer, g.buffers[i].massMemory, err = d.CreateBuffer(
 			vulkan.DeviceSize(bufferSize),
-			vulkan.BufferUsageFlags(vulkan.BufferUsageVertexBufferBit|vulkan.BufferUsageStorageBufferBit|vulkan.BufferUsageTransferDstBit),
+			vulkan.BufferUsageFlags(vulkan.BufferUsageVertexBufferBit|vulkan.BufferUsageStorageBufferBit|vulkan.BufferUsageTransferSrcBit|vulkan.BufferUsageTransferDstBit),
 			vulkan.MemoryPropertyFlags(vulkan.MemoryPropertyDeviceLocalBit),
 		)
-	}
+		if err != nil {
+			return err
+		}
+		d.NameBuffer(g.buffers[i].massBuffer, fmt.Sprintf("mass[frame %d]", i))
 
-	copyWithStagingBuffer(device, massObjects, func(cb vulkan.CommandBuffer, staging vulkan.Buffer) {
-		for i := range swapchain.MAX_FRAMES_IN_FLIGHT {
-			vulkan.CmdCopyBuffer(cb, staging, g.buffers[i].massBuffer, 1, []vulkan.BufferCopy{
-				{
-					SrcOffset: 0,
-					DstOffset: 0,
-					Size:      vulkan.DeviceSize(bufferSize),
-				},
-			})
+		if g.buffers[i].readback, err = newReadback(d, bufferSize, i); err != nil {
+			return err
 		}
-	})
+	}
+
+	// the descriptor needs a buffer even without trails
+	trailSize := trailLayout.Size * max(len(massObjects)*g.options.TrailLength, 1)
+	var err error
+	g.trailBuffer, g.trailMemory, err = d.CreateBuffer(
+		vulkan.DeviceSize(trailSize),
+		vulkan.BufferUsageFlags(vulkan.BufferUsageStorageBufferBit|vulkan.BufferUsageTransferDstBit),
+		vulkan.MemoryPropertyFlags(vulkan.MemoryPropertyDeviceLocalBit),
+	)
+	if err != nil {
+		return err
+	}
+	d.NameBuffer(g.trailBuffer, "trails")
+	if err := g.uploadInitial(t); err != nil {
+		return err
+	}
 
-	for i := range swapchain.MAX_FRAMES_IN_FLIGHT {
-		vulkan.UpdateDescriptorSets(device.LogicalDevice, 2, []vulkan.WriteDescriptorSet{
+	for i := range g.framesInFlight {
+		vulkan.UpdateDescriptorSets(d.LogicalDevice, 3, []vulkan.WriteDescriptorSet{
 			{
 				SType:           vulkan.StructureTypeWriteDescriptorSet,
 				DstSet:          g.DescriptorsSets[i],
@@ -339,7 +655,7 @@ func (g *Gravity) UploadMassObjects(
 				DescriptorCount: 1,
 				PBufferInfo: []vulkan.DescriptorBufferInfo{
 					{
-						Buffer: g.buffers[(i+swapchain.MAX_FRAMES_IN_FLIGHT-1)%swapchain.MAX_FRAMES_IN_FLIGHT].massBuffer,
+						Buffer: g.buffers[(i+g.framesInFlight-1)%g.framesInFlight].massBuffer,
 						Offset: 0,
 						Range:  vulkan.DeviceSize(bufferSize),
 					},
@@ -360,14 +676,32 @@ func (g *Gravity) UploadMassObjects(
 					},
 				},
 			},
+			{
+				SType:           vulkan.StructureTypeWriteDescriptorSet,
+				DstSet:          g.DescriptorsSets[i],
+				DstBinding:      5,
+				DstArrayElement: 0,
+				DescriptorType:  vulkan.DescriptorTypeStorageBuffer,
+				DescriptorCount: 1,
+				PBufferInfo: []vulkan.DescriptorBufferInfo{
+					{
+						Buffer: g.trailBuffer,
+						Offset: 0,
+						Range:  vulkan.DeviceSize(trailSize),
+					},
+				},
+			},
 		}, 0, nil)
 	}
+
+	return nil
 }
 
 func (g *Gravity) UploadFieldObjects(
-	device *device.Device,
+	d *device.Device,
+	t *transfer.Manager,
 	objects []*object.GameObject,
-) {
+) error {
 	var fieldObjects []VectorField
 	for _, object := range objects {
 		if object.Field != nil {
@@ -377,34 +711,37 @@ func (g *Gravity) UploadFieldObjects(
 		}
 	}
 	g.fieldElementsCount = len(fieldObjects)
-	bufferSize := int(unsafe.Sizeof(VectorField{})) * g.fieldElementsCount
 
-	for i := range swapchain.MAX_FRAMES_IN_FLIGHT {
-		g.buffers[i].forceBuffer, g.buffers[i].forceMemory = device.CreateBuffer(
-			vulkan.DeviceSize(g.fieldElementsCount*int(unsafe.Sizeof(ForceField{}))),
+	for i := range g.framesInFlight {
+		var err error
+		g.buffers[i].forceBuffer, g.buffers[i].forceMemory, err = d.CreateBuffer(
+			vulkan.DeviceSize(g.fieldElementsCount*forceLayout.Size),
 			vulkan.BufferUsageFlags(vulkan.BufferUsageVertexBufferBit|vulkan.BufferUsageStorageBufferBit),
 			vulkan.MemoryPropertyFlags(vulkan.MemoryPropertyDeviceLocalBit),
 		)
+		if err != nil {
+			return err
+		}
+		d.NameBuffer(g.buffers[i].forceBuffer, fmt.Sprintf("force[frame %d]", i))
 	}
 
-	g.vecBuffer, g.vecMemory = device.CreateBuffer(
-		vulkan.DeviceSize(g.fieldElementsCount*int(unsafe.Sizeof(VectorField{}))),
+	var err error
+	g.vecBuffer, g.vecMemory, err = d.CreateBuffer(
+		vulkan.DeviceSize(g.fieldElementsCount*fieldLayout.Size),
 		vulkan.BufferUsageFlags(vulkan.BufferUsageStorageBufferBit|vulkan.BufferUsageTransferDstBit),
 		vulkan.MemoryPropertyFlags(vulkan.MemoryPropertyDeviceLocalBit),
 	)
+	if err != nil {
+		return err
+	}
+	d.NameBuffer(g.vecBuffer, "vector field")
 
-	copyWithStagingBuffer(device, fieldObjects, func(cb vulkan.CommandBuffer, staging vulkan.Buffer) {
-		vulkan.CmdCopyBuffer(cb, staging, g.vecBuffer, 1, []vulkan.BufferCopy{
-			{
-				SrcOffset: 0,
-				DstOffset: 0,
-				Size:      vulkan.DeviceSize(bufferSize),
-			},
-		})
-	})
+	if err := transfer.Upload(t, fieldLayout.Encode(fieldObjects), g.vecBuffer); err != nil {
+		return err
+	}
 
-	for i := range swapchain.MAX_FRAMES_IN_FLIGHT {
-		vulkan.UpdateDescriptorSets(device.LogicalDevice, 2, []vulkan.WriteDescriptorSet{
+	for i := range g.framesInFlight {
+		vulkan.UpdateDescriptorSets(d.LogicalDevice, 2, []vulkan.WriteDescriptorSet{
 			{
 				SType:           vulkan.StructureTypeWriteDescriptorSet,
 				DstSet:          g.DescriptorsSets[i],
@@ -416,7 +753,7 @@ func (g *Gravity) UploadFieldObjects(
 					{
 						Buffer: g.vecBuffer,
 						Offset: 0,
-						Range:  vulkan.DeviceSize(g.fieldElementsCount * int(unsafe.Sizeof(VectorField{}))),
+						Range:  vulkan.DeviceSize(g.fieldElementsCount * fieldLayout.Size),
 					},
 				},
 			},
@@ -431,42 +768,298 @@ func (g *Gravity) UploadFieldObjects(
 					{
 						Buffer: g.buffers[i].forceBuffer,
 						Offset: 0,
-						Range:  vulkan.DeviceSize(g.fieldElementsCount * int(unsafe.Sizeof(ForceField{}))),
+						Range:  vulkan.DeviceSize(g.fieldElementsCount * forceLayout.Size),
 					},
 				},
 			},
 		}, 0, nil)
 	}
+
+	return nil
 }
 
-func (g *Gravity) ComputeGravity(
+// UploadDrawCommands creates the draw buffers the field pass writes the
+// instance counts to. All masses and all field points have to share a model.
+func (g *Gravity) UploadDrawCommands(
+	d *device.Device,
+	t *transfer.Manager,
+	objects []*object.GameObject,
+) error {
+	commands := make([]DrawCommand, 2)
+	models := make([]*model.Model, 2)
+	for _, object := range objects {
+		idx := DrawMasses
+		if object.Field != nil {
+			idx = DrawFields
+		}
+		if models[idx] != nil && models[idx] != object.Model {
+			return errors.New("instanced drawing needs a single model for all masses and one for all field points")
+		}
+		models[idx] = object.Model
+		commands[idx].VertexCount = object.Model.VertexCount()
+	}
+	bufferSize := drawLayout.Size * len(commands)
+
+	drawBuffers := make([]vulkan.Buffer, g.framesInFlight)
+	for i := range g.framesInFlight {
+		var err error
+		g.buffers[i].drawBuffer, g.buffers[i].drawMemory, err = d.CreateBuffer(
+			vulkan.DeviceSize(bufferSize),
+			vulkan.BufferUsageFlags(vulkan.BufferUsageIndirectBufferBit|vulkan.BufferUsageStorageBufferBit|vulkan.BufferUsageTransferDstBit),
+			vulkan.MemoryPropertyFlags(vulkan.MemoryPropertyDeviceLocalBit),
+		)
+		if err != nil {
+			return err
+		}
+		d.NameBuffer(g.buffers[i].drawBuffer, fmt.Sprintf("draw commands[frame %d]", i))
+		drawBuffers[i] = g.buffers[i].drawBuffer
+	}
+	if err := transfer.Upload(t, drawLayout.Encode(commands), drawBuffers...); err != nil {
+		return err
+	}
+
+	for i := range g.framesInFlight {
+		vulkan.UpdateDescriptorSets(d.LogicalDevice, 1, []vulkan.WriteDescriptorSet{
+			{
+				SType:           vulkan.StructureTypeWriteDescriptorSet,
+				DstSet:          g.DescriptorsSets[i],
+				DstBinding:      4,
+				DstArrayElement: 0,
+				DescriptorType:  vulkan.DescriptorTypeStorageBuffer,
+				DescriptorCount: 1,
+				PBufferInfo: []vulkan.DescriptorBufferInfo{
+					{
+						Buffer: g.buffers[i].drawBuffer,
+						Offset: 0,
+						Range:  vulkan.DeviceSize(bufferSize),
+					},
+				},
+			},
+		}, 0, nil)
+	}
+
+	return nil
+}
+
+// Trail describes the trail ring buffers for drawing.
+type Trail struct {
+	// Head is the slot written by the last gravity pass.
+	Head uint32
+	// Length is the number of slots per body.
+	Length uint32
+	// Count is the number of points that can be drawn without reading the
+	// slots the gravity passes of the frames in flight are writing.
+	Count uint32
+}
+
+func (g *Gravity) Trail() Trail {
+	return Trail{
+		Head:   uint32(g.trailHead),
+		Length: uint32(g.options.TrailLength),
+		Count:  uint32(max(g.options.TrailLength-g.framesInFlight, 0)),
+	}
+}
+
+// uploadInitial writes the initial state to the mass buffers and every
+// slot of the trail ring buffers, so that trails grow from the bodies
+// instead of the origin.
+func (g *Gravity) uploadInitial(t *transfer.Manager) error {
+	massBuffers := make([]vulkan.Buffer, g.framesInFlight)
+	for i := range massBuffers {
+		massBuffers[i] = g.buffers[i].massBuffer
+	}
+	if err := transfer.Upload(t, massLayout.Encode(g.initial), massBuffers...); err != nil {
+		return err
+	}
+
+	trail := make([]TrailPoint, 0, len(g.initial)*g.options.TrailLength)
+	for _, object := range g.initial {
+		for range g.options.TrailLength {
+			trail = append(trail, TrailPoint{position: object.position})
+		}
+	}
+	return transfer.Upload(t, trailLayout.Encode(trail), g.trailBuffer)
+}
+
+// Reset restores the state uploaded by UploadMassObjects and clears the
+// trails. The device must be idle, and the upload is done once t is waited
+// for.
+func (g *Gravity) Reset(t *transfer.Manager) error {
+	g.latestIdx = g.framesInFlight - 1
+	g.trailHead = 0
+	g.simulatedTime = 0
+	g.snapshot = Snapshot{Bodies: g.initial}
+	for i := range g.buffers {
+		g.buffers[i].readback.pending = false
+	}
+
+	return g.uploadInitial(t)
+}
+
+// DrawBuffer returns the draw commands written by the field pass of
+// frameIdx, DrawCommand sized and indexed by DrawMasses and DrawFields.
+func (g *Gravity) DrawBuffer(frameIdx uint32) vulkan.Buffer {
+	return g.buffers[frameIdx].drawBuffer
+}
+
+// ComputeGravityFor advances the simulation by elapsed simulated seconds
+// regardless of how much real time has passed.
+func (g *Gravity) ComputeGravityFor(
 	commandBuffer vulkan.CommandBuffer,
 	frameIdx uint32,
+	elapsed float32,
+) {
+	steps := int(elapsed / g.options.Dt)
+	steps = (steps/g.framesInFlight + 1) * g.framesInFlight
+	g.device.BeginLabel(commandBuffer, PassGravity, device.LabelCompute)
+	g.Profiler.Begin(commandBuffer, frameIdx, PassGravity)
+	if g.options.TrailLength > 0 {
+		g.trailHead = (g.trailHead + 1) % g.options.TrailLength
+	}
+	g.collectReadback(int(frameIdx))
+	// the first dispatch overwrites the mass buffer the previous frame
+	// copied back
+	memoryBarrier(commandBuffer, vulkan.PipelineStageTransferBit, vulkan.PipelineStageComputeShaderBit, 0, 0)
+	g.computeSteps(commandBuffer, int(frameIdx), elapsed/float32(steps), steps, uint32(g.trailHead))
+	g.recordReadback(commandBuffer, int(frameIdx))
+	g.Profiler.End(commandBuffer, frameIdx, PassGravity)
+	g.device.EndLabel(commandBuffer)
+}
+
+// ComputeGravitySteps records steps integration passes of dt each, ping-ponging
+// between the mass buffers starting with the one written for startIdx.
+func (g *Gravity) ComputeGravitySteps(
+	commandBuffer vulkan.CommandBuffer,
+	startIdx int,
+	dt float32,
+	steps int,
+) {
+	g.computeSteps(commandBuffer, startIdx, dt, steps, noTrail)
+}
+
+// noTrail is a trail slot that is never written.
+const noTrail = ^uint32(0)
+
+// computeSteps records the position after the last step into trailSlot of
+// the trail ring buffers.
+func (g *Gravity) computeSteps(
+	commandBuffer vulkan.CommandBuffer,
+	startIdx int,
+	dt float32,
+	steps int,
+	trailSlot uint32,
 ) {
 	vulkan.CmdBindPipeline(commandBuffer, vulkan.PipelineBindPointCompute, g.pipelines[0])
-	lastRenderedTime := float32(time.Since(g.lastRenderedTime).Seconds())
-	g.lastRenderedTime = time.Now()
 
-	steps := int(lastRenderedTime / 0.001)
-	steps = (steps/swapchain.MAX_FRAMES_IN_FLIGHT + 1) * swapchain.MAX_FRAMES_IN_FLIGHT
-	currentIdx := frameIdx
-	for range steps {
+	currentIdx := startIdx
+	for step := range steps {
+		if step > 0 {
+			memoryBarrier(
+				commandBuffer,
+				vulkan.PipelineStageComputeShaderBit,
+				vulkan.PipelineStageComputeShaderBit,
+				vulkan.AccessShaderWriteBit,
+				vulkan.AccessShaderReadBit,
+			)
+		}
 		vulkan.CmdBindDescriptorSets(commandBuffer, vulkan.PipelineBindPointCompute, g.pipelinesLayout, 0, 1, []vulkan.DescriptorSet{
 			g.DescriptorsSets[currentIdx],
 		}, 0, nil)
-		vulkan.CmdPushConstants(commandBuffer, g.pipelinesLayout, vulkan.ShaderStageFlags(vulkan.ShaderStageComputeBit), 0, uint32(unsafe.Sizeof(pushMassData{})), unsafe.Pointer(&pushMassData{
-			timeSince:   lastRenderedTime / float32(steps),
+		push := pushMassData{
+			timeSince:   dt,
 			numElements: uint32(g.massElementsCount),
-		}))
-		vulkan.CmdDispatch(commandBuffer, 1, 1, 1)
-		currentIdx = (currentIdx + 1) % swapchain.MAX_FRAMES_IN_FLIGHT
+			trailLength: uint32(g.options.TrailLength),
+			trailSlot:   noTrail,
+		}
+		if step == steps-1 {
+			push.trailSlot = trailSlot
+		}
+		vulkan.CmdPushConstants(commandBuffer, g.pipelinesLayout, vulkan.ShaderStageFlags(vulkan.ShaderStageComputeBit), 0, uint32(unsafe.Sizeof(push)), unsafe.Pointer(&push))
+		vulkan.CmdDispatch(commandBuffer, g.groupCount(g.massElementsCount), 1, 1)
+		g.latestIdx = currentIdx
+		currentIdx = (currentIdx + 1) % g.framesInFlight
+	}
+	g.simulatedTime += float64(dt) * float64(steps)
+}
+
+// Simulate runs steps integration passes of dt each on the compute queue
+// and blocks until they finish. It does not touch any graphics state.
+func (g *Gravity) Simulate(steps int, dt float32) error {
+	for steps > 0 {
+		batch := min(steps, maxStepsPerSubmit)
+		err := submitOnce(g.device, func(commandBuffer vulkan.CommandBuffer) {
+			g.ComputeGravitySteps(commandBuffer, (g.latestIdx+1)%g.framesInFlight, dt, batch)
+		})
+		if err != nil {
+			return err
+		}
+		steps -= batch
 	}
+
+	return nil
+}
+
+// ReadMassObjects copies the most recent simulation state back to the host.
+func (g *Gravity) ReadMassObjects() ([]ObjectWithMass, error) {
+	bufferSize := vulkan.DeviceSize(massLayout.Size * g.massElementsCount)
+
+	buffer, memory, err := g.device.CreateBuffer(
+		bufferSize,
+		vulkan.BufferUsageFlags(vulkan.BufferUsageTransferDstBit),
+		vulkan.MemoryPropertyFlags(vulkan.MemoryPropertyHostVisibleBit|vulkan.MemoryPropertyHostCoherentBit),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer g.device.Free(memory)
+	defer vulkan.DestroyBuffer(g.device.LogicalDevice, buffer, nil)
+
+	err = submitOnce(g.device, func(commandBuffer vulkan.CommandBuffer) {
+		memoryBarrier(
+			commandBuffer,
+			vulkan.PipelineStageComputeShaderBit,
+			vulkan.PipelineStageTransferBit,
+			vulkan.AccessShaderWriteBit,
+			vulkan.AccessTransferReadBit,
+		)
+		vulkan.CmdCopyBuffer(commandBuffer, g.buffers[g.latestIdx].massBuffer, buffer, 1, []vulkan.BufferCopy{
+			{
+				Size: bufferSize,
+			},
+		})
+		memoryBarrier(
+			commandBuffer,
+			vulkan.PipelineStageTransferBit,
+			vulkan.PipelineStageHostBit,
+			vulkan.AccessTransferWriteBit,
+			vulkan.AccessHostReadBit,
+		)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := g.device.Map(memory)
+	if err != nil {
+		return nil, err
+	}
+
+	return massLayout.Decode(unsafe.Slice((*byte)(data), bufferSize))
 }
 
 func (g *Gravity) ComputeGravityField(
 	commandBuffer vulkan.CommandBuffer,
 	frameIdx uint32,
-) (vulkan.Semaphore, vulkan.DescriptorSet) {
+) (vulkan.Semaphore, vulkan.DescriptorSet, error) {
+	g.device.BeginLabel(commandBuffer, PassField, device.LabelCompute)
+	g.Profiler.Begin(commandBuffer, frameIdx, PassField)
+	memoryBarrier(
+		commandBuffer,
+		vulkan.PipelineStageComputeShaderBit,
+		vulkan.PipelineStageComputeShaderBit,
+		vulkan.AccessShaderWriteBit,
+		vulkan.AccessShaderReadBit,
+	)
 	vulkan.CmdBindPipeline(commandBuffer, vulkan.PipelineBindPointCompute, g.pipelines[1])
 	vulkan.CmdPushConstants(commandBuffer, g.pipelinesLayout, vulkan.ShaderStageFlags(vulkan.ShaderStageComputeBit), 0, uint32(unsafe.Sizeof(pushFieldData{})), unsafe.Pointer(&pushFieldData{
 		totalElements: uint32(g.fieldElementsCount),
@@ -476,18 +1069,21 @@ func (g *Gravity) ComputeGravityField(
 		g.DescriptorsSets[frameIdx],
 	}, 0, nil)
 
-	vulkan.CmdDispatch(commandBuffer, 8, 1, 1)
+	// the first invocation writes the draw commands even without field points
+	vulkan.CmdDispatch(commandBuffer, g.groupCount(max(g.fieldElementsCount, 1)), 1, 1)
+	g.Profiler.End(commandBuffer, frameIdx, PassField)
+	g.device.EndLabel(commandBuffer)
 
-	if err := vulkan.Error(vulkan.EndCommandBuffer(commandBuffer)); err != nil {
-		panic("failed to end command buffer: " + err.Error())
+	if err := device.Check(vulkan.EndCommandBuffer(commandBuffer), "end command buffer"); err != nil {
+		return nil, nil, err
 	}
 
-	if err := vulkan.Error(vulkan.QueueSubmit(g.device.ComputeQueue, 1, []vulkan.SubmitInfo{
+	if err := device.Check(vulkan.QueueSubmit(g.device.ComputeQueue, 1, []vulkan.SubmitInfo{
 		{
 			SType:              vulkan.StructureTypeSubmitInfo,
 			WaitSemaphoreCount: 1,
 			PWaitSemaphores: []vulkan.Semaphore{
-				g.computeFinished[(frameIdx+swapchain.MAX_FRAMES_IN_FLIGHT-1)%swapchain.MAX_FRAMES_IN_FLIGHT],
+				g.computeFinished[(int(frameIdx)+g.framesInFlight-1)%g.framesInFlight],
 			},
 			PWaitDstStageMask: []vulkan.PipelineStageFlags{
 				vulkan.PipelineStageFlags(vulkan.PipelineStageComputeShaderBit),
@@ -500,11 +1096,11 @@ func (g *Gravity) ComputeGravityField(
 				g.computeFinishedForGraphics[frameIdx],
 			},
 		},
-	}, nil)); err != nil {
-		panic("failed to submit compute command buffer: " + err.Error())
+	}, nil), "submit compute command buffer"); err != nil {
+		return nil, nil, err
 	}
 
-	return g.computeFinishedForGraphics[frameIdx], g.DescriptorsSets[frameIdx]
+	return g.computeFinishedForGraphics[frameIdx], g.DescriptorsSets[frameIdx], nil
 }
 
 func (g *Gravity) Close() {
@@ -518,18 +1114,19 @@ func (g *Gravity) Close() {
 
 	for _, buffer := range g.buffers {
 		vulkan.DestroyBuffer(g.device.LogicalDevice, buffer.massBuffer, nil)
-		vulkan.FreeMemory(g.device.LogicalDevice, buffer.massMemory, nil)
+		g.device.Free(buffer.massMemory)
 		vulkan.DestroyBuffer(g.device.LogicalDevice, buffer.forceBuffer, nil)
-		vulkan.FreeMemory(g.device.LogicalDevice, buffer.forceMemory, nil)
+		g.device.Free(buffer.forceMemory)
+		vulkan.DestroyBuffer(g.device.LogicalDevice, buffer.drawBuffer, nil)
+		g.device.Free(buffer.drawMemory)
+		buffer.readback.close(g.device)
 	}
 	vulkan.DestroyBuffer(g.device.LogicalDevice, g.vecBuffer, nil)
-	vulkan.FreeMemory(g.device.LogicalDevice, g.vecMemory, nil)
-	for _, pipeline := range g.pipelines {
-		vulkan.DestroyPipeline(g.device.LogicalDevice, pipeline, nil)
-	}
+	g.device.Free(g.vecMemory)
+	vulkan.DestroyBuffer(g.device.LogicalDevice, g.trailBuffer, nil)
+	g.device.Free(g.trailMemory)
+	g.destroyPipelines()
 	vulkan.DestroyPipelineLayout(g.device.LogicalDevice, g.pipelinesLayout, nil)
 	vulkan.DestroyDescriptorSetLayout(g.device.LogicalDevice, g.DescriptorsLayout, nil)
 	vulkan.DestroyDescriptorPool(g.device.LogicalDevice, g.descriptorsPool, nil)
-	vulkan.DestroyShaderModule(g.device.LogicalDevice, g.massModule, nil)
-	vulkan.DestroyShaderModule(g.device.LogicalDevice, g.fieldModule, nil)
 }
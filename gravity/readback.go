@@ -0,0 +1,118 @@
+package gravity
+
+import (
+	"fmt"
+	"game/device"
+	"log/slog"
+	"unsafe"
+
+	"github.com/goki/vulkan"
+)
+
+// Snapshot is the state of the mass bodies at a point of simulated time,
+// in the order they were uploaded.
+type Snapshot struct {
+	Bodies []ObjectWithMass
+	// Time is the simulated seconds integrated when Bodies was copied.
+	Time float64
+}
+
+// readback is a host visible copy of the mass buffer written at the end of
+// the gravity pass of a frame and read once the frame has finished.
+type readback struct {
+	buffer  vulkan.Buffer
+	memory  *device.Allocation
+	data    unsafe.Pointer
+	size    int
+	time    float64
+	pending bool
+}
+
+func newReadback(d *device.Device, size int, frame int) (readback, error) {
+	r := readback{size: size}
+
+	var err error
+	r.buffer, r.memory, err = d.CreateBuffer(
+		vulkan.DeviceSize(max(size, 1)),
+		vulkan.BufferUsageFlags(vulkan.BufferUsageTransferDstBit),
+		vulkan.MemoryPropertyFlags(vulkan.MemoryPropertyHostVisibleBit|vulkan.MemoryPropertyHostCoherentBit),
+	)
+	if err != nil {
+		return readback{}, err
+	}
+	d.NameBuffer(r.buffer, fmt.Sprintf("mass readback[frame %d]", frame))
+	if r.data, err = d.Map(r.memory); err != nil {
+		r.close(d)
+		return readback{}, err
+	}
+
+	return r, nil
+}
+
+func (r *readback) close(d *device.Device) {
+	vulkan.DestroyBuffer(d.LogicalDevice, r.buffer, nil)
+	d.Free(r.memory)
+}
+
+// recordReadback copies the latest mass buffer into the readback of
+// frameIdx.
+func (g *Gravity) recordReadback(commandBuffer vulkan.CommandBuffer, frameIdx int) {
+	r := &g.buffers[frameIdx].readback
+	if r.size == 0 {
+		return
+	}
+
+	memoryBarrier(
+		commandBuffer,
+		vulkan.PipelineStageComputeShaderBit,
+		vulkan.PipelineStageTransferBit,
+		vulkan.AccessShaderWriteBit,
+		vulkan.AccessTransferReadBit,
+	)
+	vulkan.CmdCopyBuffer(commandBuffer, g.buffers[g.latestIdx].massBuffer, r.buffer, 1, []vulkan.BufferCopy{
+		{
+			Size: vulkan.DeviceSize(r.size),
+		},
+	})
+	memoryBarrier(
+		commandBuffer,
+		vulkan.PipelineStageTransferBit,
+		vulkan.PipelineStageHostBit,
+		vulkan.AccessTransferWriteBit,
+		vulkan.AccessHostReadBit,
+	)
+	r.time = g.simulatedTime
+	r.pending = true
+}
+
+// collectReadback takes the copy recorded the last time frameIdx was in
+// flight. The frame fence must have been waited for.
+func (g *Gravity) collectReadback(frameIdx int) {
+	r := &g.buffers[frameIdx].readback
+	if !r.pending {
+		return
+	}
+	r.pending = false
+
+	bodies, err := massLayout.Decode(unsafe.Slice((*byte)(r.data), r.size))
+	if err != nil {
+		slog.Warn("failed to decode mass readback", slog.Any("error", err))
+		return
+	}
+	if r.time >= g.snapshot.Time {
+		g.snapshot = Snapshot{Bodies: bodies, Time: r.time}
+	}
+}
+
+// Snapshot returns the most recent mass state read back from the frames in
+// flight, without waiting for the GPU. It lags SimulatedTime by up to the
+// number of frames in flight.
+func (g *Gravity) Snapshot() Snapshot {
+	return g.snapshot
+}
+
+// SimulatedTime is the simulated seconds integrated by the passes recorded
+// so far.
+func (g *Gravity) SimulatedTime() float64 {
+	return g.simulatedTime
+}
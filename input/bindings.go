@@ -0,0 +1,140 @@
+package input
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/go-gl/glfw/v3.3/glfw"
+)
+
+// Action is something the app does in response to a bound key.
+type Action int
+
+const (
+	ActionPause Action = iota
+	ActionStep
+	ActionSpeedUp
+	ActionSlowDown
+	ActionReset
+	ActionScreenshot
+)
+
+var actionNames = []string{"pause", "step", "speed-up", "slow-down", "reset", "screenshot"}
+
+func (a Action) String() string {
+	if int(a) < len(actionNames) {
+		return actionNames[a]
+	}
+
+	return fmt.Sprintf("Action(%d)", a)
+}
+
+// Bindings maps every action to the keys that trigger it.
+type Bindings map[Action][]glfw.Key
+
+var DefaultBindings = Bindings{
+	ActionPause:      {glfw.KeySpace},
+	ActionStep:       {glfw.KeyPeriod},
+	ActionSpeedUp:    {glfw.KeyEqual, glfw.KeyKPAdd},
+	ActionSlowDown:   {glfw.KeyMinus, glfw.KeyKPSubtract},
+	ActionReset:      {glfw.KeyR},
+	ActionScreenshot: {glfw.KeyF12},
+}
+
+var keyNames = map[string]glfw.Key{
+	"space":     glfw.KeySpace,
+	"period":    glfw.KeyPeriod,
+	"comma":     glfw.KeyComma,
+	"minus":     glfw.KeyMinus,
+	"equal":     glfw.KeyEqual,
+	"enter":     glfw.KeyEnter,
+	"tab":       glfw.KeyTab,
+	"backspace": glfw.KeyBackspace,
+	"escape":    glfw.KeyEscape,
+	"left":      glfw.KeyLeft,
+	"right":     glfw.KeyRight,
+	"up":        glfw.KeyUp,
+	"down":      glfw.KeyDown,
+	"home":      glfw.KeyHome,
+	"end":       glfw.KeyEnd,
+	"kp-add":    glfw.KeyKPAdd,
+	"kp-sub":    glfw.KeyKPSubtract,
+}
+
+func init() {
+	for c := 'a'; c <= 'z'; c++ {
+		keyNames[string(c)] = glfw.KeyA + glfw.Key(c-'a')
+	}
+	for c := '0'; c <= '9'; c++ {
+		keyNames[string(c)] = glfw.Key0 + glfw.Key(c-'0')
+	}
+	for n := 1; n <= 12; n++ {
+		keyNames[fmt.Sprintf("f%d", n)] = glfw.KeyF1 + glfw.Key(n-1)
+	}
+}
+
+// ParseBindings reads a comma separated list of action=key pairs, such as
+// pause=p,step=n, on top of base. Keys are letters, digits, f1 to f12 or
+// space, period, comma, minus, equal, enter, tab, backspace, escape, the
+// arrow keys, home, end, kp-add and kp-sub. Binding an action replaces its
+// keys in base, binding it more than once in the list adds keys. A key
+// bound in the list is taken from the action base binds it to, and binding
+// one key to two actions in the list is an error.
+func ParseBindings(list string, base Bindings) (Bindings, error) {
+	bindings := maps.Clone(base)
+	parsed := map[Action]bool{}
+	bound := map[glfw.Key]Action{}
+	for _, entry := range strings.Split(list, ",") {
+		name, keyName, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok {
+			return nil, fmt.Errorf("binding %q is not action=key", entry)
+		}
+
+		i := slices.Index(actionNames, name)
+		if i < 0 {
+			return nil, fmt.Errorf("unknown action %q, want one of %v", name, actionNames)
+		}
+		key, ok := keyNames[strings.ToLower(keyName)]
+		if !ok {
+			return nil, fmt.Errorf("unknown key %q", keyName)
+		}
+
+		action := Action(i)
+		if other, ok := bound[key]; ok && other != action {
+			return nil, fmt.Errorf("key %q is bound to both %v and %v", keyName, other, action)
+		}
+		bound[key] = action
+
+		if !parsed[action] {
+			bindings[action] = nil
+			parsed[action] = true
+		}
+		bindings[action] = append(bindings[action], key)
+	}
+
+	// the slices are shared with base, so filter into new ones
+	for action, keys := range bindings {
+		if parsed[action] {
+			continue
+		}
+		bindings[action] = slices.DeleteFunc(slices.Clone(keys), func(key glfw.Key) bool {
+			_, ok := bound[key]
+			return ok
+		})
+	}
+
+	return bindings, nil
+}
+
+// actionFor returns the first action, in Action order, key is bound to.
+func (b Bindings) actionFor(key glfw.Key) (Action, bool) {
+	for action := range Action(len(actionNames)) {
+		if slices.Contains(b[action], key) {
+			return action, true
+		}
+	}
+
+	return 0, false
+}
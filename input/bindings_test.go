@@ -0,0 +1,88 @@
+package input
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-gl/glfw/v3.3/glfw"
+)
+
+func TestParseBindings(t *testing.T) {
+	tests := []struct {
+		name string
+		list string
+		want Bindings
+	}{
+		{
+			name: "replace",
+			list: "pause=p",
+			want: Bindings{ActionPause: {glfw.KeyP}, ActionReset: {glfw.KeyR}},
+		},
+		{
+			name: "add",
+			list: "pause=p,pause=f1",
+			want: Bindings{ActionPause: {glfw.KeyP, glfw.KeyF1}, ActionReset: {glfw.KeyR}},
+		},
+		{
+			// r is taken from reset rather than triggering both
+			name: "steal",
+			list: "pause=r",
+			want: Bindings{ActionPause: {glfw.KeyR}, ActionReset: {}},
+		},
+		{
+			name: "swap",
+			list: "pause=r,reset=space",
+			want: Bindings{ActionPause: {glfw.KeyR}, ActionReset: {glfw.KeySpace}},
+		},
+	}
+
+	base := Bindings{ActionPause: {glfw.KeySpace}, ActionReset: {glfw.KeyR}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := ParseBindings(test.list, base)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("bindings = %v, want %v", got, test.want)
+			}
+		})
+	}
+
+	if !reflect.DeepEqual(base, Bindings{ActionPause: {glfw.KeySpace}, ActionReset: {glfw.KeyR}}) {
+		t.Errorf("base modified to %v", base)
+	}
+}
+
+func TestParseBindingsErrors(t *testing.T) {
+	tests := []struct {
+		list string
+		want string
+	}{
+		{"pause", "not action=key"},
+		{"jump=j", "unknown action"},
+		{"pause=hyper", "unknown key"},
+		{"pause=p,reset=p", "bound to both"},
+	}
+
+	for _, test := range tests {
+		_, err := ParseBindings(test.list, DefaultBindings)
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%q: err = %v, want it to contain %q", test.list, err, test.want)
+		}
+	}
+}
+
+func TestActionFor(t *testing.T) {
+	// a key bound twice resolves to the earlier action every time
+	b := Bindings{ActionReset: {glfw.KeyR}, ActionPause: {glfw.KeyR}, ActionStep: {glfw.KeyN}}
+	for range 10 {
+		if action, ok := b.actionFor(glfw.KeyR); !ok || action != ActionPause {
+			t.Fatalf("actionFor(r) = %v, %t, want pause", action, ok)
+		}
+	}
+	if _, ok := b.actionFor(glfw.KeyQ); ok {
+		t.Error("actionFor(q) found an action")
+	}
+}
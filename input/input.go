@@ -0,0 +1,152 @@
+// Package input turns the GLFW keyboard and mouse callbacks into per frame
+// state and bound actions for the app loop.
+package input
+
+import (
+	"github.com/go-gl/glfw/v3.3/glfw"
+)
+
+type EventKind int
+
+const (
+	EventKey EventKind = iota
+	EventMouseButton
+	EventCursorMove
+	EventScroll
+)
+
+// Event is a callback as received from the window. Which fields are set
+// depends on Kind.
+type Event struct {
+	Kind   EventKind
+	Key    glfw.Key
+	Button glfw.MouseButton
+	Action glfw.Action
+	Mods   glfw.ModifierKey
+	// Cursor is in normalized device coordinates.
+	Cursor [2]float32
+	Scroll float32
+}
+
+// Input queues the events of a window as they arrive and applies them once
+// per frame in Update. The window delivers them from glfw.PollEvents on the
+// locked main thread, so no locking is needed.
+type Input struct {
+	bindings Bindings
+	queue    []Event
+
+	// state as of the last Update
+	events  []Event
+	keys    map[glfw.Key]bool
+	buttons map[glfw.MouseButton]bool
+	cursor  [2]float32
+	actions []Action
+
+	scroll       float32
+	scrollCursor [2]float32
+
+	dragging bool
+	dragFrom [2]float32
+	dragTo   [2]float32
+}
+
+func New(bindings Bindings) *Input {
+	return &Input{
+		bindings: bindings,
+		keys:     map[glfw.Key]bool{},
+		buttons:  map[glfw.MouseButton]bool{},
+	}
+}
+
+// Key implements window.Handler.
+func (in *Input) Key(key glfw.Key, action glfw.Action, mods glfw.ModifierKey) {
+	in.queue = append(in.queue, Event{Kind: EventKey, Key: key, Action: action, Mods: mods})
+}
+
+// MouseButton implements window.Handler.
+func (in *Input) MouseButton(button glfw.MouseButton, action glfw.Action, cursor [2]float32) {
+	in.queue = append(in.queue, Event{Kind: EventMouseButton, Button: button, Action: action, Cursor: cursor})
+}
+
+// CursorMove implements window.Handler.
+func (in *Input) CursorMove(cursor [2]float32) {
+	in.queue = append(in.queue, Event{Kind: EventCursorMove, Cursor: cursor})
+}
+
+// Scroll implements window.Handler.
+func (in *Input) Scroll(offset float32, cursor [2]float32) {
+	in.queue = append(in.queue, Event{Kind: EventScroll, Scroll: offset, Cursor: cursor})
+}
+
+// Update applies the events queued since the previous call. Call it once a
+// frame after polling the window events.
+func (in *Input) Update() {
+	in.events, in.queue = in.queue, in.events[:0]
+	in.actions = in.actions[:0]
+	in.scroll = 0
+	in.dragFrom = in.cursor
+	in.dragTo = in.cursor
+
+	for _, event := range in.events {
+		switch event.Kind {
+		case EventKey:
+			in.keys[event.Key] = event.Action != glfw.Release
+			if event.Action != glfw.Press {
+				continue
+			}
+			if action, ok := in.bindings.actionFor(event.Key); ok {
+				in.actions = append(in.actions, action)
+			}
+		case EventMouseButton:
+			in.buttons[event.Button] = event.Action == glfw.Press
+			in.cursor = event.Cursor
+			if event.Button == glfw.MouseButtonLeft {
+				in.dragging = event.Action == glfw.Press
+				in.dragFrom, in.dragTo = in.cursor, in.cursor
+			}
+		case EventCursorMove:
+			in.cursor = event.Cursor
+			if in.dragging {
+				in.dragTo = in.cursor
+			}
+		case EventScroll:
+			in.scroll += event.Scroll
+			in.scrollCursor = event.Cursor
+		}
+	}
+}
+
+// Events returns the events applied by the last Update, in order.
+func (in *Input) Events() []Event {
+	return in.events
+}
+
+// Actions returns the actions triggered during the last frame, in order.
+func (in *Input) Actions() []Action {
+	return in.actions
+}
+
+func (in *Input) KeyDown(key glfw.Key) bool {
+	return in.keys[key]
+}
+
+func (in *Input) ButtonDown(button glfw.MouseButton) bool {
+	return in.buttons[button]
+}
+
+// Cursor returns the cursor position in normalized device coordinates.
+func (in *Input) Cursor() [2]float32 {
+	return in.cursor
+}
+
+// ScrollOffset returns the vertical scroll offset of the last frame and
+// where the cursor was.
+func (in *Input) ScrollOffset() (float32, [2]float32) {
+	return in.scroll, in.scrollCursor
+}
+
+// Drag returns how far the cursor moved with the left button held during
+// the last frame. from equals to when it did not.
+func (in *Input) Drag() (from, to [2]float32) {
+	return in.dragFrom, in.dragTo
+}
@@ -0,0 +1,281 @@
+// Package layout places Go structs in GLSL std140 and std430 blocks, so that
+// the padding between members is computed instead of written by hand.
+//
+// float32, int32 and uint32 map to scalars and arrays of two to four of them
+// to vectors. Other arrays and nested structs follow the array and struct
+// rules. Field tags refine the mapping:
+//
+//	Transform [4]float32 `layout:"mat2"`  // column major matN
+//	Weights   [4]float32 `layout:"array"` // float[4] rather than vec4
+//	cache     int        `layout:"-"`     // not part of the block
+//
+// Fields named _ are skipped as well.
+package layout
+
+import (
+	"fmt"
+	"reflect"
+	"unsafe"
+)
+
+type Rules int
+
+const (
+	Std140 Rules = iota
+	Std430
+)
+
+// Field is the placement of a top level struct field in the block.
+type Field struct {
+	Name   string
+	Offset int
+	Size   int
+}
+
+// Block is the placement of a struct in a block, independent of its Go type.
+// Size is also the stride of arrays of the struct.
+type Block struct {
+	Name   string
+	Rules  Rules
+	Fields []Field
+	Size   int
+	Align  int
+}
+
+// Layout is the block layout of the Go struct T.
+type Layout[T any] struct {
+	Block
+	copies []span
+}
+
+// span is a run of bytes copied between the Go value and the block.
+type span struct {
+	src uintptr
+	dst int
+	n   int
+}
+
+type kind int
+
+const (
+	scalar kind = iota
+	vector
+	array
+	structure
+)
+
+type member struct {
+	name     string
+	goOffset uintptr
+	offset   int
+	node     *node
+}
+
+type node struct {
+	kind  kind
+	align int
+	size  int
+
+	// vectors and arrays
+	length   int
+	goStride uintptr
+	stride   int
+	element  *node
+
+	members []member
+}
+
+func roundUp(n, alignment int) int {
+	return (n + alignment - 1) / alignment * alignment
+}
+
+func New[T any](rules Rules) (*Layout[T], error) {
+	t := reflect.TypeFor[T]()
+	if t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("%s is not a struct", t)
+	}
+
+	root, err := build(rules, t, "")
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", t, err)
+	}
+	if root.size == 0 {
+		return nil, fmt.Errorf("%s has no block members", t)
+	}
+
+	l := &Layout[T]{Block: Block{Name: t.String(), Rules: rules, Size: root.size, Align: root.align}}
+	for _, m := range root.members {
+		l.Fields = append(l.Fields, Field{Name: m.name, Offset: m.offset, Size: m.node.size})
+	}
+	l.copies = emit(nil, root, 0, 0)
+
+	return l, nil
+}
+
+// Must is New for layouts of fixed types declared at package level.
+func Must[T any](rules Rules) *Layout[T] {
+	l, err := New[T](rules)
+	if err != nil {
+		panic(err)
+	}
+
+	return l
+}
+
+func isScalar(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Float32, reflect.Int32, reflect.Uint32:
+		return true
+	}
+
+	return false
+}
+
+func build(rules Rules, t reflect.Type, tag string) (*node, error) {
+	switch tag {
+	case "mat2", "mat3", "mat4":
+		return buildMatrix(rules, t, int(tag[3]-'0'))
+	case "", "array":
+	default:
+		return nil, fmt.Errorf("unknown layout tag %q", tag)
+	}
+
+	switch {
+	case isScalar(t):
+		return &node{kind: scalar, align: 4, size: 4}, nil
+	case t.Kind() == reflect.Array && isScalar(t.Elem()) && t.Len() >= 2 && t.Len() <= 4 && tag != "array":
+		// vec3 is aligned like vec4 but only takes up three components
+		align := 16
+		if t.Len() == 2 {
+			align = 8
+		}
+		return &node{kind: vector, align: align, size: 4 * t.Len(), length: t.Len(), goStride: 4}, nil
+	case t.Kind() == reflect.Array:
+		element, err := build(rules, t.Elem(), "")
+		if err != nil {
+			return nil, err
+		}
+		return newArray(rules, element, t.Len(), t.Elem().Size()), nil
+	case t.Kind() == reflect.Struct:
+		return buildStruct(rules, t)
+	}
+
+	return nil, fmt.Errorf("unsupported type %s", t)
+}
+
+func newArray(rules Rules, element *node, length int, goStride uintptr) *node {
+	align := element.align
+	if rules == Std140 {
+		align = roundUp(align, 16)
+	}
+	stride := roundUp(element.size, align)
+
+	return &node{kind: array, align: align, size: length * stride, length: length, goStride: goStride, stride: stride, element: element}
+}
+
+// buildMatrix lays out a matrix as an array of column vectors. The Go value
+// is either [n*n]float32 or [n][n]float32, column major.
+func buildMatrix(rules Rules, t reflect.Type, n int) (*node, error) {
+	flat := t.Kind() == reflect.Array && t.Len() == n*n && t.Elem().Kind() == reflect.Float32
+	nested := t.Kind() == reflect.Array && t.Len() == n && t.Elem().Kind() == reflect.Array &&
+		t.Elem().Len() == n && t.Elem().Elem().Kind() == reflect.Float32
+	if !flat && !nested {
+		return nil, fmt.Errorf("mat%d needs [%d]float32 or [%d][%d]float32, not %s", n, n*n, n, n, t)
+	}
+
+	column, err := build(rules, reflect.ArrayOf(n, reflect.TypeFor[float32]()), "")
+	if err != nil {
+		return nil, err
+	}
+
+	return newArray(rules, column, n, uintptr(4*n)), nil
+}
+
+func buildStruct(rules Rules, t reflect.Type) (*node, error) {
+	s := &node{kind: structure, align: 4}
+	var end int
+	for i := range t.NumField() {
+		field := t.Field(i)
+		tag := field.Tag.Get("layout")
+		if field.Name == "_" || tag == "-" {
+			continue
+		}
+
+		n, err := build(rules, field.Type, tag)
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %w", field.Name, err)
+		}
+		offset := roundUp(end, n.align)
+		s.members = append(s.members, member{name: field.Name, goOffset: field.Offset, offset: offset, node: n})
+		end = offset + n.size
+		s.align = max(s.align, n.align)
+	}
+	if rules == Std140 {
+		s.align = roundUp(s.align, 16)
+	}
+	s.size = roundUp(end, s.align)
+
+	return s, nil
+}
+
+// emit appends the scalar copies of n, merging neighbours that are
+// contiguous on both sides.
+func emit(copies []span, n *node, src uintptr, dst int) []span {
+	switch n.kind {
+	case scalar:
+		if len(copies) > 0 {
+			last := &copies[len(copies)-1]
+			if last.src+uintptr(last.n) == src && last.dst+last.n == dst {
+				last.n += 4
+				return copies
+			}
+		}
+		return append(copies, span{src: src, dst: dst, n: 4})
+	case vector:
+		for i := range n.length {
+			copies = emit(copies, &node{kind: scalar}, src+uintptr(4*i), dst+4*i)
+		}
+	case array:
+		for i := range n.length {
+			copies = emit(copies, n.element, src+uintptr(i)*n.goStride, dst+i*n.stride)
+		}
+	case structure:
+		for _, m := range n.members {
+			copies = emit(copies, m.node, src+m.goOffset, dst+m.offset)
+		}
+	}
+
+	return copies
+}
+
+// Encode returns the values laid out as an array of T. Padding is zero.
+func (l *Layout[T]) Encode(values []T) []byte {
+	buf := make([]byte, len(values)*l.Size)
+	for i := range values {
+		src := unsafe.Pointer(&values[i])
+		dst := buf[i*l.Size:]
+		for _, c := range l.copies {
+			copy(dst[c.dst:c.dst+c.n], unsafe.Slice((*byte)(unsafe.Add(src, c.src)), c.n))
+		}
+	}
+
+	return buf
+}
+
+// Decode reads an array of T from buf, which has to hold whole elements.
+func (l *Layout[T]) Decode(buf []byte) ([]T, error) {
+	if len(buf)%l.Size != 0 {
+		return nil, fmt.Errorf("%d bytes are not a whole number of %d byte elements", len(buf), l.Size)
+	}
+
+	values := make([]T, len(buf)/l.Size)
+	for i := range values {
+		dst := unsafe.Pointer(&values[i])
+		src := buf[i*l.Size:]
+		for _, c := range l.copies {
+			copy(unsafe.Slice((*byte)(unsafe.Add(dst, c.src)), c.n), src[c.dst:c.dst+c.n])
+		}
+	}
+
+	return values, nil
+}
@@ -0,0 +1,295 @@
+package layout
+
+import (
+	"encoding/binary"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type vec3Scalar struct {
+	P [3]float32
+	W float32
+}
+
+type scalarArray struct {
+	A [3]float32 `layout:"array"`
+	B float32
+}
+
+type longArray struct {
+	A [5]uint32
+	B int32
+}
+
+type inner struct {
+	V [2]float32
+}
+
+type nested struct {
+	A  float32
+	In inner
+	B  float32
+}
+
+type nestedArray struct {
+	Items [2]inner
+	N     uint32
+}
+
+type mat2 struct {
+	M [4]float32 `layout:"mat2"`
+	S float32
+}
+
+type mat4 struct {
+	M [16]float32 `layout:"mat4"`
+}
+
+type mat4Nested struct {
+	S float32
+	M [4][4]float32 `layout:"mat4"`
+}
+
+type skipped struct {
+	A     float32
+	_     [3]float32
+	cache int `layout:"-"`
+	D     [2]float32
+}
+
+func TestLayouts(t *testing.T) {
+	tests := []struct {
+		name   string
+		new    func(Rules) (Block, error)
+		rules  Rules
+		fields []Field
+		size   int
+		align  int
+	}{
+		{"vec3 then scalar std140", block[vec3Scalar], Std140, []Field{{"P", 0, 12}, {"W", 12, 4}}, 16, 16},
+		{"vec3 then scalar std430", block[vec3Scalar], Std430, []Field{{"P", 0, 12}, {"W", 12, 4}}, 16, 16},
+		// std140 rounds the stride of scalar arrays up to 16
+		{"scalar array std140", block[scalarArray], Std140, []Field{{"A", 0, 48}, {"B", 48, 4}}, 64, 16},
+		{"scalar array std430", block[scalarArray], Std430, []Field{{"A", 0, 12}, {"B", 12, 4}}, 16, 4},
+		{"long array std140", block[longArray], Std140, []Field{{"A", 0, 80}, {"B", 80, 4}}, 96, 16},
+		{"long array std430", block[longArray], Std430, []Field{{"A", 0, 20}, {"B", 20, 4}}, 24, 4},
+		{"nested struct std140", block[nested], Std140, []Field{{"A", 0, 4}, {"In", 16, 16}, {"B", 32, 4}}, 48, 16},
+		{"nested struct std430", block[nested], Std430, []Field{{"A", 0, 4}, {"In", 8, 8}, {"B", 16, 4}}, 24, 8},
+		{"struct array std140", block[nestedArray], Std140, []Field{{"Items", 0, 32}, {"N", 32, 4}}, 48, 16},
+		{"struct array std430", block[nestedArray], Std430, []Field{{"Items", 0, 16}, {"N", 16, 4}}, 24, 8},
+		// mat2 columns are vec2, padded to vec4 by std140
+		{"mat2 std140", block[mat2], Std140, []Field{{"M", 0, 32}, {"S", 32, 4}}, 48, 16},
+		{"mat2 std430", block[mat2], Std430, []Field{{"M", 0, 16}, {"S", 16, 4}}, 24, 8},
+		{"mat4 std140", block[mat4], Std140, []Field{{"M", 0, 64}}, 64, 16},
+		{"mat4 std430", block[mat4], Std430, []Field{{"M", 0, 64}}, 64, 16},
+		{"nested mat4 std430", block[mat4Nested], Std430, []Field{{"S", 0, 4}, {"M", 16, 64}}, 80, 16},
+		{"skipped fields std140", block[skipped], Std140, []Field{{"A", 0, 4}, {"D", 8, 8}}, 16, 16},
+		{"skipped fields std430", block[skipped], Std430, []Field{{"A", 0, 4}, {"D", 8, 8}}, 16, 8},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b, err := test.new(test.rules)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(b.Fields, test.fields) {
+				t.Errorf("fields = %v, want %v", b.Fields, test.fields)
+			}
+			if b.Size != test.size {
+				t.Errorf("size = %d, want %d", b.Size, test.size)
+			}
+			if b.Align != test.align {
+				t.Errorf("align = %d, want %d", b.Align, test.align)
+			}
+		})
+	}
+}
+
+func block[T any](rules Rules) (Block, error) {
+	l, err := New[T](rules)
+	if err != nil {
+		return Block{}, err
+	}
+
+	return l.Block, nil
+}
+
+func TestArrayStride(t *testing.T) {
+	tests := []struct {
+		name   string
+		new    func(Rules) (*node, error)
+		rules  Rules
+		stride int
+	}{
+		{"float[3] std140", elementNode[scalarArray], Std140, 16},
+		{"float[3] std430", elementNode[scalarArray], Std430, 4},
+		{"inner[2] std140", elementNode[nestedArray], Std140, 16},
+		{"inner[2] std430", elementNode[nestedArray], Std430, 8},
+		{"mat2 std140", elementNode[mat2], Std140, 16},
+		{"mat2 std430", elementNode[mat2], Std430, 8},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			n, err := test.new(test.rules)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n.stride != test.stride {
+				t.Errorf("stride = %d, want %d", n.stride, test.stride)
+			}
+		})
+	}
+}
+
+// elementNode returns the node of the first member of T.
+func elementNode[T any](rules Rules) (*node, error) {
+	t := reflect.TypeFor[T]()
+	root, err := build(rules, t, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return root.members[0].node, nil
+}
+
+type float64Field struct{ F float64 }
+type stringField struct{ S string }
+type boolField struct{ B bool }
+type noMembers struct {
+	_ float32
+}
+type unknownTag struct {
+	M [4]float32 `layout:"mat5"`
+}
+type badMatrix struct {
+	M [9]float32 `layout:"mat2"`
+}
+type badNested struct {
+	In float64Field
+}
+
+func TestUnsupported(t *testing.T) {
+	tests := []struct {
+		name string
+		new  func() error
+		want string
+	}{
+		{"not a struct", func() error { _, err := New[float32](Std140); return err }, "not a struct"},
+		{"float64", func() error { _, err := New[float64Field](Std140); return err }, "unsupported type float64"},
+		{"string", func() error { _, err := New[stringField](Std430); return err }, "unsupported type string"},
+		{"bool", func() error { _, err := New[boolField](Std430); return err }, "unsupported type bool"},
+		{"no members", func() error { _, err := New[noMembers](Std140); return err }, "no block members"},
+		{"unknown tag", func() error { _, err := New[unknownTag](Std140); return err }, "unknown layout tag"},
+		{"bad matrix", func() error { _, err := New[badMatrix](Std140); return err }, "mat2 needs"},
+		{"nested", func() error { _, err := New[badNested](Std140); return err }, "field In: field F"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.new()
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("err = %v, want it to contain %q", err, test.want)
+			}
+		})
+	}
+}
+
+func TestMustPanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("Must did not panic")
+		}
+	}()
+	Must[stringField](Std140)
+}
+
+// checkRoundTrip encodes values, checks that every byte not covered by a
+// member is zero and decodes them back.
+func checkRoundTrip[T any](t *testing.T, rules Rules, values []T) {
+	t.Helper()
+
+	l, err := New[T](rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := l.Encode(values)
+	if len(buf) != len(values)*l.Size {
+		t.Fatalf("encoded %d bytes, want %d", len(buf), len(values)*l.Size)
+	}
+
+	covered := make([]bool, l.Size)
+	for _, c := range l.copies {
+		for i := range c.n {
+			covered[c.dst+i] = true
+		}
+	}
+	for i, b := range buf {
+		if !covered[i%l.Size] && b != 0 {
+			t.Errorf("padding byte %d of element %d is %#x", i%l.Size, i/l.Size, b)
+		}
+	}
+
+	decoded, err := l.Decode(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, values) {
+		t.Errorf("decoded %v, want %v", decoded, values)
+	}
+
+	if _, err := l.Decode(buf[:len(buf)-1]); err == nil {
+		t.Error("decoding a partial element did not fail")
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, rules := range []Rules{Std140, Std430} {
+		checkRoundTrip(t, rules, []vec3Scalar{{P: [3]float32{1, 2, 3}, W: 4}, {P: [3]float32{-1, -2, -3}, W: -4}})
+		checkRoundTrip(t, rules, []scalarArray{{A: [3]float32{1, 2, 3}, B: 4}})
+		checkRoundTrip(t, rules, []longArray{{A: [5]uint32{1, 2, 3, 4, 5}, B: -6}})
+		checkRoundTrip(t, rules, []nested{{A: 1, In: inner{V: [2]float32{2, 3}}, B: 4}, {A: 5, B: 6}})
+		checkRoundTrip(t, rules, []nestedArray{{Items: [2]inner{{V: [2]float32{1, 2}}, {V: [2]float32{3, 4}}}, N: 5}})
+		checkRoundTrip(t, rules, []mat2{{M: [4]float32{1, 2, 3, 4}, S: 5}})
+		checkRoundTrip(t, rules, []mat4{{M: [16]float32{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}}})
+		checkRoundTrip(t, rules, []mat4Nested{{S: 1, M: [4][4]float32{{1, 2, 3, 4}, {5, 6, 7, 8}, {9, 10, 11, 12}, {13, 14, 15, 16}}}})
+		// skipped fields are neither written nor read back
+		checkRoundTrip(t, rules, []skipped{{A: 1, D: [2]float32{2, 3}}})
+	}
+}
+
+func TestEncodeSkipsFields(t *testing.T) {
+	l := Must[skipped](Std430)
+	buf := l.Encode([]skipped{{A: 1, cache: 99, D: [2]float32{2, 3}}})
+	decoded, err := l.Decode(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded[0].cache != 0 {
+		t.Errorf("cache = %d, want it skipped", decoded[0].cache)
+	}
+}
+
+func TestMatrixColumns(t *testing.T) {
+	// std140 places the mat2 columns 16 bytes apart, std430 8 bytes apart
+	tests := []struct {
+		rules   Rules
+		offsets []int
+	}{
+		{Std140, []int{0, 4, 16, 20}},
+		{Std430, []int{0, 4, 8, 12}},
+	}
+
+	for _, test := range tests {
+		buf := Must[mat2](test.rules).Encode([]mat2{{M: [4]float32{1, 2, 3, 4}}})
+		for i, offset := range test.offsets {
+			got := math.Float32frombits(binary.NativeEndian.Uint32(buf[offset:]))
+			if got != float32(i+1) {
+				t.Errorf("rules %d: float at %d = %g, want %d", test.rules, offset, got, i+1)
+			}
+		}
+	}
+}
@@ -1,16 +1,490 @@
 package main
 
 import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
 	"game/app"
+	"game/bench"
+	"game/gravity"
+	"game/input"
+	"game/swapchain"
+	"io"
+	"os"
 	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/goki/vulkan"
+)
+
+const (
+	exitOK      = 0
+	exitFailure = 1
+	exitUsage   = 2
 )
 
+var errUsage = errors.New("usage error")
+
+type command struct {
+	name        string
+	description string
+	run         func(args []string) error
+}
+
+var commands []command
+
 func init() {
 	runtime.LockOSThread()
+
+	commands = []command{
+		{"run", "simulate interactively in a window (default)", runCommand},
+		{"headless", "simulate without a window and print the final state", headlessCommand},
+		{"render", "render frames offscreen into numbered PNG files", renderCommand},
+		{"bench", "measure simulation throughput", benchCommand},
+		{"export", "simulate without a window and write trajectories as CSV", exportCommand},
+		{"devices", "list physical devices with their limits, queue families and memory heaps", devicesCommand},
+	}
 }
 
 func main() {
-	app := app.New()
+	os.Exit(runCLI(os.Args[1:]))
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: %s <command> [flags]\n\ncommands:\n", os.Args[0])
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", cmd.name, cmd.description)
+	}
+	fmt.Fprintf(os.Stderr, "\nrun '%s <command> -h' for the flags of a command\n", os.Args[0])
+}
+
+func runCLI(args []string) int {
+	name := "run"
+	if len(args) > 0 && args[0] != "" && args[0][0] != '-' {
+		name, args = args[0], args[1:]
+	}
+
+	if name == "help" {
+		usage()
+		return exitOK
+	}
+
+	for _, cmd := range commands {
+		if cmd.name != name {
+			continue
+		}
+
+		err := cmd.run(args)
+		switch {
+		case err == nil, errors.Is(err, flag.ErrHelp):
+			return exitOK
+		case errors.Is(err, errUsage):
+			return exitUsage
+		default:
+			fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+			return exitFailure
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+	usage()
+	return exitUsage
+}
+
+// newFlagSet registers the flags shared by every command.
+func newFlagSet(name string, config *app.Config) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	*config = app.DefaultConfig
+	fs.StringVar(&config.Scene, "scene", "", "catalog file (Horizons vector table or CSV) to load bodies from")
+	fs.IntVar(&config.Bodies, "bodies", 0, "generate a random scene with this many bodies")
+	fs.Int64Var(&config.Seed, "seed", 1, "seed for generated scenes")
+	fs.Func("steps", fmt.Sprintf("number of simulation steps (default %d for headless and export, no limit otherwise)", defaultSteps), func(value string) error {
+		steps, err := strconv.Atoi(value)
+		// zero is left to mean the default of the command
+		if err == nil && steps < 1 {
+			err = errors.New("must be positive")
+		}
+		config.Steps = steps
+		return err
+	})
+	fs.Func("dt", fmt.Sprintf("simulated time per step (default %g)", config.Gravity.Dt), func(value string) error {
+		dt, err := strconv.ParseFloat(value, 32)
+		if err == nil && dt <= 0 {
+			err = errors.New("must be positive")
+		}
+		config.Gravity.Dt = float32(dt)
+		return err
+	})
+	fs.Func("softening", "Plummer softening length instead of the close encounter cutoff", func(value string) error {
+		softening, err := strconv.ParseFloat(value, 32)
+		config.Gravity.Softening = float32(softening)
+		return err
+	})
+	fs.Func("integrator", "integrator compiled into the gravity shader: symplectic or explicit (default symplectic)", func(value string) error {
+		integrator, err := gravity.ParseIntegrator(value)
+		config.Gravity.Integrator = integrator
+		return err
+	})
+	fs.StringVar(&config.Follow, "follow", "", "keep the camera on none, com (centre of mass) or a body index or name")
+	fs.BoolVar(&config.CoRotating, "co-rotating", false, "rotate the view with the two heaviest bodies")
+	fs.Func("bind", "comma separated action=key bindings for pause, step, speed-up, slow-down, reset and screenshot (default pause=space,step=period,speed-up=equal,slow-down=minus,reset=r,screenshot=f12)", func(value string) error {
+		bindings, err := input.ParseBindings(value, config.Bindings)
+		config.Bindings = bindings
+		return err
+	})
+	fs.Func("trails", "comma separated body indices or names that draw a trail, hiding the others (default every body the catalog does not hide)", func(value string) error {
+		config.Trails = strings.Split(value, ",")
+		return nil
+	})
+	fs.IntVar(&config.Gravity.TrailLength, "trail-length", config.Gravity.TrailLength, "frames of history kept per body for its trail, 0 disables trails")
+	fs.Func("device", "physical device index or name substring, see the devices command (default: best by type)", func(value string) error {
+		if idx, err := strconv.Atoi(value); err == nil {
+			config.Device.DeviceIndex = idx
+			return nil
+		}
+		config.Device.DeviceName = value
+		return nil
+	})
+	fs.BoolVar(&config.Device.Validation, "validation", config.Device.Validation, "enable Vulkan validation layers")
+	fs.BoolVar(&config.Device.FailOnValidationError, "strict-validation", false, "fail Vulkan calls that trigger validation errors")
+	fs.BoolVar(&config.Device.PipelineCache, "pipeline-cache", config.Device.PipelineCache, "keep compiled pipelines in the user cache directory")
+	fs.Func("present-mode", "comma separated present mode preference: mailbox, fifo (vsync), fifo-relaxed or immediate (default mailbox,fifo)", func(value string) error {
+		modes, err := swapchain.ParsePresentModes(value)
+		config.Swapchain.PresentModes = modes
+		return err
+	})
+	fs.Func("surface-format", "comma separated surface format preference: srgb, hdr10 or scrgb, falling back to srgb when not offered (default srgb)", func(value string) error {
+		formats, err := swapchain.ParseFormats(value)
+		config.Swapchain.Formats = formats
+		return err
+	})
+	fs.Func("frames-in-flight", fmt.Sprintf("frames recorded ahead of the GPU (default %d)", config.Swapchain.FramesInFlight), func(value string) error {
+		frames, err := strconv.Atoi(value)
+		// the integration steps ping-pong between the mass buffers of
+		// the frames
+		if err == nil && frames < 2 {
+			err = errors.New("must be at least 2")
+		}
+		config.Swapchain.FramesInFlight = frames
+		return err
+	})
+	fs.IntVar(&config.Swapchain.Samples, "msaa", config.Swapchain.Samples, "MSAA sample count, limited by the device, 1 disables it")
+	fs.BoolVar(&config.Profile, "profile", false, "log GPU pass timings and device memory usage")
+	fs.StringVar(&config.ShaderDir, "shader-dir", "", "load SPIR-V from this directory instead of the embedded shaders")
+	fs.BoolVar(&config.WatchShaders, "watch-shaders", false, "recompile and reload shaders when their sources change")
+
+	return fs
+}
+
+// defaultSteps is how far headless and export simulate without -steps.
+const defaultSteps = 10000
+
+// fixedSteps returns the number of steps headless and export simulate,
+// -steps or defaultSteps when it is not given.
+func fixedSteps(config app.Config) int {
+	if config.Steps == 0 {
+		return defaultSteps
+	}
+
+	return config.Steps
+}
+
+func parse(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return errUsage
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintf(os.Stderr, "unexpected arguments: %v\n", fs.Args())
+		return errUsage
+	}
+
+	return nil
+}
+
+func runCommand(args []string) error {
+	var config app.Config
+	fs := newFlagSet("run", &config)
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+
+	app, err := app.New(config)
+	if err != nil {
+		return err
+	}
+	defer app.Close()
+
+	return app.Run()
+}
+
+func headlessCommand(args []string) error {
+	var config app.Config
+	fs := newFlagSet("headless", &config)
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+
+	app, err := app.NewHeadless(config)
+	if err != nil {
+		return err
+	}
+	defer app.Close()
+
+	bodies, err := app.Simulate(fixedSteps(config), config.Gravity.Dt)
+	if err != nil {
+		return err
+	}
+
+	w := csv.NewWriter(os.Stdout)
+	w.Write([]string{"id", "name", "mass", "x", "y", "vx", "vy"})
+	for _, body := range bodies {
+		w.Write([]string{
+			strconv.Itoa(body.ID),
+			body.Name,
+			formatFloat(body.Mass),
+			formatFloat(body.Position[0]),
+			formatFloat(body.Position[1]),
+			formatFloat(body.Velocity[0]),
+			formatFloat(body.Velocity[1]),
+		})
+	}
+	w.Flush()
+
+	return w.Error()
+}
+
+func renderCommand(args []string) error {
+	var config app.Config
+	fs := newFlagSet("render", &config)
+	frames := fs.Int("frames", 600, "number of frames to render")
+	frameDt := fs.Float64("frame-dt", 1.0/60, "simulated time per frame")
+	width := fs.Int("width", 1920, "frame width in pixels")
+	height := fs.Int("height", 1080, "frame height in pixels")
+	out := fs.String("out", "frames", "directory for the numbered PNG files")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	if *width <= 0 || *height <= 0 {
+		fmt.Fprintln(os.Stderr, "frame size must be positive")
+		return errUsage
+	}
+
+	app, err := app.NewOffscreen(config, vulkan.Extent2D{Width: uint32(*width), Height: uint32(*height)})
+	if err != nil {
+		return err
+	}
+	defer app.Close()
+
+	return app.Render(*frames, float32(*frameDt), *out)
+}
+
+func benchCommand(args []string) error {
+	var config app.Config
+	fs := newFlagSet("bench", &config)
+	sizes := fs.String("sizes", joinInts(bench.StandardSizes), "comma-separated body counts of the generated scenes")
+	stepsPerFrame := fs.Int("steps-per-frame", 16, "simulation steps submitted per frame")
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+
+	options := bench.Options{
+		Config:        config,
+		StepsPerFrame: *stepsPerFrame,
+	}
+	if config.Scene == "" && config.Bodies > 0 {
+		options.Sizes = []int{config.Bodies}
+	} else {
+		var err error
+		if options.Sizes, err = parseInts(*sizes); err != nil {
+			fmt.Fprintf(os.Stderr, "invalid -sizes: %v\n", err)
+			return errUsage
+		}
+	}
+	if *stepsPerFrame <= 0 {
+		fmt.Fprintln(os.Stderr, "-steps-per-frame must be positive")
+		return errUsage
+	}
+
+	report, err := bench.Run(options)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	}
+
+	fmt.Printf("device: %s\n", report.Device)
+	if report.Revision != "" {
+		fmt.Printf("revision: %s\n", report.Revision)
+	}
+	fmt.Printf("%-10s %8s %8s %12s %16s %10s %10s %10s %10s\n",
+		"scene", "bodies", "frames", "steps/s", "interactions/s", "p50 ms", "p90 ms", "p99 ms", "max ms")
+	for _, r := range report.Results {
+		fmt.Printf("%-10s %8d %8d %12.0f %16.3g %10.3f %10.3f %10.3f %10.3f\n",
+			r.Scene, r.Bodies, r.Frames, r.StepsPerSecond, r.InteractionsPerSecond,
+			r.FrameTimeMs.P50, r.FrameTimeMs.P90, r.FrameTimeMs.P99, r.FrameTimeMs.Max)
+	}
+
+	return nil
+}
+
+func exportCommand(args []string) error {
+	var config app.Config
+	fs := newFlagSet("export", &config)
+	every := fs.Int("every", 100, "record the state every this many steps")
+	out := fs.String("out", "-", "output CSV file, - for stdout")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	if *every <= 0 {
+		fmt.Fprintln(os.Stderr, "-every must be positive")
+		return errUsage
+	}
+
+	var output io.Writer = os.Stdout
+	if *out != "-" {
+		file, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		output = file
+	}
+
+	w := csv.NewWriter(output)
+	w.Write([]string{"step", "time", "id", "name", "x", "y", "vx", "vy"})
+	write := func(n int, bodies []app.BodyState) {
+		for _, body := range bodies {
+			w.Write([]string{
+				strconv.Itoa(n),
+				strconv.FormatFloat(float64(n)*float64(config.Gravity.Dt), 'g', -1, 32),
+				strconv.Itoa(body.ID),
+				body.Name,
+				formatFloat(body.Position[0]),
+				formatFloat(body.Position[1]),
+				formatFloat(body.Velocity[0]),
+				formatFloat(body.Velocity[1]),
+			})
+		}
+	}
+
+	app, err := app.NewHeadless(config)
+	if err != nil {
+		return err
+	}
 	defer app.Close()
-	app.Run()
+
+	bodies, err := app.Simulate(0, config.Gravity.Dt)
+	if err != nil {
+		return err
+	}
+	write(0, bodies)
+	steps := fixedSteps(config)
+	for done := 0; done < steps; {
+		batch := min(*every, steps-done)
+		if bodies, err = app.Simulate(batch, config.Gravity.Dt); err != nil {
+			return err
+		}
+		done += batch
+		write(done, bodies)
+	}
+	w.Flush()
+
+	return w.Error()
+}
+
+func devicesCommand(args []string) error {
+	fs := flag.NewFlagSet("devices", flag.ContinueOnError)
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+
+	infos, err := app.Devices()
+	if err != nil {
+		return err
+	}
+
+	for _, info := range infos {
+		fmt.Printf("[%d] %s\n", info.Index, info.Name)
+		fmt.Printf("    type:            %s (score %d)\n", info.Type, info.Score)
+		fmt.Printf("    api:             %s, driver %#x\n", info.APIVersion, info.DriverVersion)
+		fmt.Printf("    ids:             vendor %#04x, device %#04x\n", info.VendorID, info.DeviceID)
+		fmt.Printf("    swapchain:       %t\n", info.Swapchain)
+
+		limits := info.Limits
+		fmt.Println("    limits:")
+		fmt.Printf("      max image 2D:               %d\n", limits.MaxImageDimension2D)
+		fmt.Printf("      max storage buffer range:   %d\n", limits.MaxStorageBufferRange)
+		fmt.Printf("      max push constants:         %d\n", limits.MaxPushConstantsSize)
+		fmt.Printf("      max bound descriptor sets:  %d\n", limits.MaxBoundDescriptorSets)
+		fmt.Printf("      max compute shared memory:  %d\n", limits.MaxComputeSharedMemorySize)
+		fmt.Printf("      max workgroup count:        %v\n", limits.MaxComputeWorkGroupCount)
+		fmt.Printf("      max workgroup size:         %v\n", limits.MaxComputeWorkGroupSize)
+		fmt.Printf("      max workgroup invocations:  %d\n", limits.MaxComputeWorkGroupInvocations)
+		fmt.Printf("      timestamp period:           %gns\n", limits.TimestampPeriod)
+
+		fmt.Println("    queue families:")
+		for i, family := range info.QueueFamilies {
+			var caps []string
+			if family.Graphics {
+				caps = append(caps, "graphics")
+			}
+			if family.Compute {
+				caps = append(caps, "compute")
+			}
+			if family.Transfer {
+				caps = append(caps, "transfer")
+			}
+			fmt.Printf("      [%d] %d queues, %s, timestamp bits %d\n", i, family.Count, strings.Join(caps, "|"), family.TimestampValidBits)
+		}
+
+		fmt.Println("    memory heaps:")
+		for i, heap := range info.MemoryHeaps {
+			local := ""
+			if heap.DeviceLocal {
+				local = ", device local"
+			}
+			fmt.Printf("      [%d] %d MiB%s, %d memory types\n", i, heap.Size>>20, local, len(heap.MemoryTypes))
+		}
+	}
+
+	return nil
+}
+
+func parseInts(s string) ([]int, error) {
+	var values []int
+	for _, field := range strings.Split(s, ",") {
+		v, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil {
+			return nil, err
+		}
+		if v <= 0 {
+			return nil, fmt.Errorf("%d is not positive", v)
+		}
+		values = append(values, v)
+	}
+
+	return values, nil
+}
+
+func joinInts(values []int) string {
+	fields := make([]string, len(values))
+	for i, v := range values {
+		fields[i] = strconv.Itoa(v)
+	}
+
+	return strings.Join(fields, ",")
+}
+
+func formatFloat(v float32) string {
+	return strconv.FormatFloat(float64(v), 'g', -1, 32)
 }
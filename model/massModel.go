@@ -2,6 +2,9 @@ package model
 
 type MassModel struct {
 	ID       int
+	Name     string
 	Velocity [2]float32
 	Mass     float32
+	// HideTrail leaves the body out when trails are drawn.
+	HideTrail bool
 }
@@ -2,6 +2,7 @@ package model
 
 import (
 	"game/device"
+	"game/transfer"
 	"unsafe"
 
 	"github.com/goki/vulkan"
@@ -11,7 +12,7 @@ type Model struct {
 	vertexCount uint32
 	device      *device.Device
 	buffer      vulkan.Buffer
-	memory      vulkan.DeviceMemory
+	memory      *device.Allocation
 }
 
 type Position struct {
@@ -47,40 +48,84 @@ var VertexAttributeDescription = []vulkan.VertexInputAttributeDescription{
 	},
 }
 
-func New(device *device.Device, vertices []Vertex) *Model {
+// Instance is the per-instance vertex input of the draw pipeline. Positions
+// and forces are read from the simulation buffers instead.
+type Instance struct {
+	Transformation [4]float32
+	Offset         [2]float32
+	Color          [3]float32
+}
+
+var InstanceBindingDescription = []vulkan.VertexInputBindingDescription{
+	{
+		Binding:   1,
+		Stride:    uint32(unsafe.Sizeof(Instance{})),
+		InputRate: vulkan.VertexInputRateInstance,
+	},
+}
+
+var InstanceAttributeDescription = []vulkan.VertexInputAttributeDescription{
+	{
+		Binding:  1,
+		Location: 2,
+		Format:   vulkan.FormatR32g32b32a32Sfloat,
+		Offset:   uint32(unsafe.Offsetof(Instance{}.Transformation)),
+	},
+	{
+		Binding:  1,
+		Location: 3,
+		Format:   vulkan.FormatR32g32Sfloat,
+		Offset:   uint32(unsafe.Offsetof(Instance{}.Offset)),
+	},
+	{
+		Binding:  1,
+		Location: 4,
+		Format:   vulkan.FormatR32g32b32Sfloat,
+		Offset:   uint32(unsafe.Offsetof(Instance{}.Color)),
+	},
+}
+
+func New(d *device.Device, t *transfer.Manager, vertices []Vertex) (*Model, error) {
 	size := vulkan.DeviceSize(len(vertices) * int(unsafe.Sizeof(Vertex{})))
 
-	buffer, memory := device.CreateBuffer(
+	buffer, memory, err := d.CreateBuffer(
 		size,
-		vulkan.BufferUsageFlags(vulkan.BufferUsageVertexBufferBit),
-		vulkan.MemoryPropertyFlags(vulkan.MemoryPropertyHostVisibleBit|vulkan.MemoryPropertyHostCoherentBit),
+		vulkan.BufferUsageFlags(vulkan.BufferUsageVertexBufferBit|vulkan.BufferUsageTransferDstBit),
+		vulkan.MemoryPropertyFlags(vulkan.MemoryPropertyDeviceLocalBit),
 	)
-
-	var data unsafe.Pointer
-	if err := vulkan.Error(vulkan.MapMemory(device.LogicalDevice, memory, 0, size, 0, &data)); err != nil {
-		panic("failed to map buffer memory: " + err.Error())
+	if err != nil {
+		return nil, err
 	}
-	slice := unsafe.Slice((*Vertex)(data), len(vertices))
-	copy(slice, vertices)
-	vulkan.UnmapMemory(device.LogicalDevice, memory)
-
-	return &Model{
+	m := &Model{
 		vertexCount: uint32(len(vertices)),
-		device:      device,
+		device:      d,
 		buffer:      buffer,
 		memory:      memory,
 	}
+
+	// the copy completes asynchronously, callers wait on the manager before
+	// the first draw
+	if err := transfer.Upload(t, vertices, buffer); err != nil {
+		m.Close()
+		return nil, err
+	}
+
+	return m, nil
 }
 
 func (m *Model) Bind(commandBuffer vulkan.CommandBuffer) {
 	vulkan.CmdBindVertexBuffers(commandBuffer, 0, 1, []vulkan.Buffer{m.buffer}, []vulkan.DeviceSize{0})
 }
 
+func (m *Model) VertexCount() uint32 {
+	return m.vertexCount
+}
+
 func (m *Model) Draw(commandBuffer vulkan.CommandBuffer) {
 	vulkan.CmdDraw(commandBuffer, m.vertexCount, 1, 0, 0)
 }
 
 func (m *Model) Close() {
 	vulkan.DestroyBuffer(m.device.LogicalDevice, m.buffer, nil)
-	vulkan.FreeMemory(m.device.LogicalDevice, m.memory, nil)
+	m.device.Free(m.memory)
 }
@@ -2,9 +2,7 @@ package object
 
 import (
 	"game/model"
-	"game/pipeline"
 	"math"
-	"time"
 
 	"gonum.org/v1/gonum/mat"
 )
@@ -15,7 +13,6 @@ type GameObject struct {
 	color           [3]float32
 	transformations mat.Dense
 	offset          mat.Vector
-	onFrame         func(g *GameObject, since time.Duration)
 	Mass            *model.MassModel
 	Field           *model.FieldModel
 }
@@ -68,12 +65,6 @@ func (g *GameObject) WithInitialTranforms(initialTransforms []Transform) *GameOb
 	return g
 }
 
-func (g *GameObject) WithOnFrame(onFrame func(g *GameObject, since time.Duration)) *GameObject {
-	g.onFrame = onFrame
-
-	return g
-}
-
 func (g *GameObject) Position() Position {
 	return Position{
 		X: g.offset.AtVec(0),
@@ -107,7 +98,6 @@ func (r Rotate) Transform(g *GameObject) *GameObject {
 		Model:           g.Model,
 		transformations: result,
 		offset:          g.offset,
-		onFrame:         g.onFrame,
 		color:           g.color,
 		Mass:            g.Mass,
 		Field:           g.Field,
@@ -138,7 +128,6 @@ func (s Scale) Transform(g *GameObject) *GameObject {
 		Model:           g.Model,
 		transformations: result,
 		offset:          g.offset,
-		onFrame:         g.onFrame,
 		color:           g.color,
 		Mass:            g.Mass,
 		Field:           g.Field,
@@ -167,42 +156,22 @@ func (t Transition) Transform(g *GameObject) *GameObject {
 		Model:           g.Model,
 		transformations: g.transformations,
 		offset:          &result,
-		onFrame:         g.onFrame,
 		color:           g.color,
 		Mass:            g.Mass,
 		Field:           g.Field,
 	}
 }
 
-func (g *GameObject) ToPushData(since time.Duration) *pipeline.PushData {
-	if g.onFrame != nil {
-		g.onFrame(g, since)
-	}
-
+// ToInstance returns the per-instance data the object is drawn with.
+func (g *GameObject) ToInstance() model.Instance {
 	transformation := [4]float32{}
 	for i, v := range g.transformations.RawMatrix().Data {
 		transformation[i] = float32(v)
 	}
 
-	offset := [2]float32{}
-	offset[0] = float32(g.offset.AtVec(0))
-	offset[1] = float32(g.offset.AtVec(1))
-
-	isField := uint32(0)
-	id := g.ID
-	if g.Field != nil {
-		isField = 1
-		id = g.Field.ID
-	} else {
-		isField = 0
-		id = g.Mass.ID
-	}
-
-	return &pipeline.PushData{
+	return model.Instance{
 		Transformation: transformation,
-		Offset:         offset,
+		Offset:         g.GetPosition(),
 		Color:          g.color,
-		IsField:        isField,
-		Index:          uint32(id),
 	}
 }
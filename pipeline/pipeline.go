@@ -1,58 +1,221 @@
 package pipeline
 
 import (
+	"fmt"
+	"game/camera"
 	"game/device"
 	"game/model"
 	"game/shader"
+	"reflect"
+	"runtime"
+	"slices"
 	"unsafe"
 
 	"github.com/goki/vulkan"
 )
 
+// SPIR-V files the pipelines are built from.
+const (
+	VertexShader        = "vert.spv"
+	FragmentShader      = "frag.spv"
+	TrailVertexShader   = "trail.vert.spv"
+	TrailFragmentShader = "trail.frag.spv"
+)
+
+// Spec describes a pipeline drawn in the scene render pass.
+type Spec struct {
+	Name           string
+	VertexShader   string
+	FragmentShader string
+	Topology       vulkan.PrimitiveTopology
+	Bindings       []vulkan.VertexInputBindingDescription
+	Attributes     []vulkan.VertexInputAttributeDescription
+	// PushConstants is a value of the Go struct pushed to the vertex stage.
+	PushConstants any
+	// Overlay blends by alpha and neither tests nor writes depth.
+	Overlay bool
+}
+
+// Scene draws the models of the masses and field arrows.
+var Scene = Spec{
+	Name:           "draw",
+	VertexShader:   VertexShader,
+	FragmentShader: FragmentShader,
+	Topology:       vulkan.PrimitiveTopologyTriangleList,
+	Bindings:       slices.Concat(model.VertexBindingDescription, model.InstanceBindingDescription),
+	Attributes:     slices.Concat(model.VertexAttributeDescription, model.InstanceAttributeDescription),
+	PushConstants:  PushData{},
+}
+
+// Trails draws a line strip per body from the trail ring buffers, colored
+// by the instance colors of the bodies.
+var Trails = Spec{
+	Name:           "trails",
+	VertexShader:   TrailVertexShader,
+	FragmentShader: TrailFragmentShader,
+	Topology:       vulkan.PrimitiveTopologyLineStrip,
+	Bindings:       model.InstanceBindingDescription,
+	Attributes: []vulkan.VertexInputAttributeDescription{
+		{
+			Binding:  1,
+			Location: 4,
+			Format:   vulkan.FormatR32g32b32Sfloat,
+			Offset:   uint32(unsafe.Offsetof(model.Instance{}.Color)),
+		},
+	},
+	PushConstants: TrailPushData{},
+	Overlay:       true,
+}
+
+// UsesShader reports whether the pipeline is built from the SPIR-V file.
+func (s Spec) UsesShader(name string) bool {
+	return name == s.VertexShader || name == s.FragmentShader
+}
+
+// CheckLayouts compares the push constant block and the camera uniform
+// buffer of the vertex stage with their Go structs.
+func (s Spec) CheckLayouts() error {
+	module, err := shader.Reflect(s.VertexShader)
+	if err != nil {
+		return err
+	}
+	if err := module.CheckPushConstants(s.PushConstants); err != nil {
+		return fmt.Errorf("%s does not match %T: %w", s.VertexShader, s.PushConstants, err)
+	}
+	if err := camera.CheckLayout(module); err != nil {
+		return fmt.Errorf("%s: %w", s.VertexShader, err)
+	}
+
+	return nil
+}
+
 type Pipeline struct {
 	device        *device.Device
+	spec          Spec
+	renderPass    vulkan.RenderPass
+	samples       vulkan.SampleCountFlagBits
+	transfer      outputTransfer
 	shaderModules []vulkan.ShaderModule
 	pipeline      vulkan.Pipeline
 	Layout        vulkan.PipelineLayout
 }
 
+// PushData selects between the mass and field arrow draws. Everything else
+// comes from the instance and storage buffers.
 type PushData struct {
-	Transformation [4]float32
-	Offset         [2]float32
-	IsField        uint32
-	Index          uint32
-	Color          [3]float32
+	IsField uint32
 }
 
+// TrailPushData selects the slots of the trail ring buffers to draw.
+type TrailPushData struct {
+	Head   uint32
+	Length uint32
+	Count  uint32
+}
+
+// outputTransfer matches the outputTransfer specialization constant of the
+// fragment shaders.
+type outputTransfer uint32
+
+const (
+	transferSRGB outputTransfer = iota
+	transferPQ
+	transferLinear
+)
+
+var fragmentSpecialization = []vulkan.SpecializationMapEntry{
+	{ConstantID: 0, Offset: 0, Size: 4},
+}
+
+// New creates a pipeline whose fragment shader encodes its colors for the
+// color space of the render pass target.
 func New(
-	device *device.Device,
+	d *device.Device,
 	renderPass vulkan.RenderPass,
-	descriptorsLayout vulkan.DescriptorSetLayout,
-) *Pipeline {
-	vertModule := shader.CreateShaderModule("shaders/vert.spv", device.LogicalDevice)
-	fragModule := shader.CreateShaderModule("shaders/frag.spv", device.LogicalDevice)
+	samples vulkan.SampleCountFlagBits,
+	colorSpace vulkan.ColorSpace,
+	descriptorsLayouts []vulkan.DescriptorSetLayout,
+	spec Spec,
+) (*Pipeline, error) {
+	p := &Pipeline{device: d, spec: spec, renderPass: renderPass, samples: samples}
+	switch colorSpace {
+	case vulkan.ColorSpaceHdr10St2084:
+		p.transfer = transferPQ
+	case vulkan.ColorSpaceExtendedSrgbLinear:
+		p.transfer = transferLinear
+	}
 
 	var layout vulkan.PipelineLayout
-	if err := vulkan.Error(vulkan.CreatePipelineLayout(device.LogicalDevice, &vulkan.PipelineLayoutCreateInfo{
+	if err := device.Check(vulkan.CreatePipelineLayout(d.LogicalDevice, &vulkan.PipelineLayoutCreateInfo{
 		SType:                  vulkan.StructureTypePipelineLayoutCreateInfo,
 		PushConstantRangeCount: 1,
 		PPushConstantRanges: []vulkan.PushConstantRange{
 			{
-				StageFlags: vulkan.ShaderStageFlags(vulkan.ShaderStageVertexBit | vulkan.ShaderStageFragmentBit),
+				StageFlags: vulkan.ShaderStageFlags(vulkan.ShaderStageVertexBit),
 				Offset:     0,
-				Size:       uint32(unsafe.Sizeof(PushData{})),
+				Size:       uint32(reflect.TypeOf(spec.PushConstants).Size()),
 			},
 		},
-		SetLayoutCount: 1,
-		PSetLayouts: []vulkan.DescriptorSetLayout{
-			descriptorsLayout,
-		},
-	}, nil, &layout)); err != nil {
-		panic("failed to create pipeline layout: " + err.Error())
+		SetLayoutCount: uint32(len(descriptorsLayouts)),
+		PSetLayouts:    descriptorsLayouts,
+	}, nil, &layout), "create pipeline layout"); err != nil {
+		p.Close()
+		return nil, err
+	}
+	p.Layout = layout
+
+	var err error
+	if p.shaderModules, p.pipeline, err = p.create(); err != nil {
+		p.Close()
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// create builds the graphics pipeline from the current SPIR-V files.
+func (p *Pipeline) create() ([]vulkan.ShaderModule, vulkan.Pipeline, error) {
+	d := p.device
+
+	if err := p.spec.CheckLayouts(); err != nil {
+		return nil, nil, err
+	}
+
+	vertModule, err := shader.CreateShaderModule(p.spec.VertexShader, d.LogicalDevice)
+	if err != nil {
+		return nil, nil, err
+	}
+	fragModule, err := shader.CreateShaderModule(p.spec.FragmentShader, d.LogicalDevice)
+	if err != nil {
+		vulkan.DestroyShaderModule(d.LogicalDevice, vertModule, nil)
+		return nil, nil, err
+	}
+	modules := []vulkan.ShaderModule{vertModule, fragModule}
+
+	transfer := p.transfer
+	var pinner runtime.Pinner
+	pinner.Pin(&transfer)
+	defer pinner.Unpin()
+
+	blend := vulkan.PipelineColorBlendAttachmentState{
+		ColorWriteMask:      vulkan.ColorComponentFlags(vulkan.ColorComponentRBit | vulkan.ColorComponentGBit | vulkan.ColorComponentBBit | vulkan.ColorComponentABit),
+		SrcColorBlendFactor: vulkan.BlendFactorOne,
+		DstColorBlendFactor: vulkan.BlendFactorZero,
+		ColorBlendOp:        vulkan.BlendOpAdd,
+		SrcAlphaBlendFactor: vulkan.BlendFactorOne,
+		DstAlphaBlendFactor: vulkan.BlendFactorZero,
+		AlphaBlendOp:        vulkan.BlendOpAdd,
+	}
+	depthTest := vulkan.Bool32(vulkan.True)
+	if p.spec.Overlay {
+		blend.BlendEnable = vulkan.True
+		blend.SrcColorBlendFactor = vulkan.BlendFactorSrcAlpha
+		blend.DstColorBlendFactor = vulkan.BlendFactorOneMinusSrcAlpha
+		depthTest = vulkan.False
 	}
 
 	pipeline := make([]vulkan.Pipeline, 1)
-	if err := vulkan.Error(vulkan.CreateGraphicsPipelines(device.LogicalDevice, vulkan.NullPipelineCache, 1, []vulkan.GraphicsPipelineCreateInfo{
+	if err := device.Check(vulkan.CreateGraphicsPipelines(d.LogicalDevice, d.PipelineCache, 1, []vulkan.GraphicsPipelineCreateInfo{
 		{
 			SType:      vulkan.StructureTypeGraphicsPipelineCreateInfo,
 			StageCount: 2,
@@ -68,18 +231,26 @@ func New(
 					Stage:  vulkan.ShaderStageFragmentBit,
 					Module: fragModule,
 					PName:  "main\x00",
+					PSpecializationInfo: []vulkan.SpecializationInfo{
+						{
+							MapEntryCount: uint32(len(fragmentSpecialization)),
+							PMapEntries:   fragmentSpecialization,
+							DataSize:      uint64(unsafe.Sizeof(transfer)),
+							PData:         unsafe.Pointer(&transfer),
+						},
+					},
 				},
 			},
 			PVertexInputState: &vulkan.PipelineVertexInputStateCreateInfo{
 				SType:                           vulkan.StructureTypePipelineVertexInputStateCreateInfo,
-				VertexAttributeDescriptionCount: uint32(len(model.VertexAttributeDescription)),
-				VertexBindingDescriptionCount:   uint32(len(model.VertexBindingDescription)),
-				PVertexBindingDescriptions:      model.VertexBindingDescription,
-				PVertexAttributeDescriptions:    model.VertexAttributeDescription,
+				VertexAttributeDescriptionCount: uint32(len(p.spec.Attributes)),
+				VertexBindingDescriptionCount:   uint32(len(p.spec.Bindings)),
+				PVertexBindingDescriptions:      p.spec.Bindings,
+				PVertexAttributeDescriptions:    p.spec.Attributes,
 			},
 			PInputAssemblyState: &vulkan.PipelineInputAssemblyStateCreateInfo{
 				SType:    vulkan.StructureTypePipelineInputAssemblyStateCreateInfo,
-				Topology: vulkan.PrimitiveTopologyTriangleList,
+				Topology: p.spec.Topology,
 			},
 			PViewportState: &vulkan.PipelineViewportStateCreateInfo{
 				SType:         vulkan.StructureTypePipelineViewportStateCreateInfo,
@@ -95,29 +266,19 @@ func New(
 			},
 			PMultisampleState: &vulkan.PipelineMultisampleStateCreateInfo{
 				SType:                vulkan.StructureTypePipelineMultisampleStateCreateInfo,
-				RasterizationSamples: vulkan.SampleCount1Bit,
+				RasterizationSamples: p.samples,
 				MinSampleShading:     1.0,
 			},
 			PColorBlendState: &vulkan.PipelineColorBlendStateCreateInfo{
 				SType:           vulkan.StructureTypePipelineColorBlendStateCreateInfo,
 				LogicOp:         vulkan.LogicOpCopy,
 				AttachmentCount: 1,
-				PAttachments: []vulkan.PipelineColorBlendAttachmentState{
-					{
-						ColorWriteMask:      vulkan.ColorComponentFlags(vulkan.ColorComponentRBit | vulkan.ColorComponentGBit | vulkan.ColorComponentBBit | vulkan.ColorComponentABit),
-						SrcColorBlendFactor: vulkan.BlendFactorOne,
-						DstColorBlendFactor: vulkan.BlendFactorZero,
-						ColorBlendOp:        vulkan.BlendOpAdd,
-						SrcAlphaBlendFactor: vulkan.BlendFactorOne,
-						DstAlphaBlendFactor: vulkan.BlendFactorZero,
-						AlphaBlendOp:        vulkan.BlendOpAdd,
-					},
-				},
+				PAttachments:    []vulkan.PipelineColorBlendAttachmentState{blend},
 			},
 			PDepthStencilState: &vulkan.PipelineDepthStencilStateCreateInfo{
 				SType:            vulkan.StructureTypePipelineDepthStencilStateCreateInfo,
-				DepthTestEnable:  vulkan.True,
-				DepthWriteEnable: vulkan.True,
+				DepthTestEnable:  depthTest,
+				DepthWriteEnable: depthTest,
 				DepthCompareOp:   vulkan.CompareOpLess,
 				MinDepthBounds:   0,
 				MaxDepthBounds:   1,
@@ -130,33 +291,51 @@ func New(
 					vulkan.DynamicStateScissor,
 				},
 			},
-			Layout:             layout,
-			RenderPass:         renderPass,
+			Layout:             p.Layout,
+			RenderPass:         p.renderPass,
 			Subpass:            0,
 			BasePipelineIndex:  -1,
 			BasePipelineHandle: vulkan.NullPipeline,
 		},
-	}, nil, pipeline)); err != nil {
-		panic("failed to create pipeline: " + err.Error())
+	}, nil, pipeline), "create pipeline"); err != nil {
+		for _, module := range modules {
+			vulkan.DestroyShaderModule(d.LogicalDevice, module, nil)
+		}
+		return nil, nil, err
 	}
+	d.NamePipeline(pipeline[0], p.spec.Name)
 
-	return &Pipeline{
-		device:        device,
-		pipeline:      pipeline[0],
-		Layout:        layout,
-		shaderModules: []vulkan.ShaderModule{vertModule, fragModule},
+	return modules, pipeline[0], nil
+}
+
+// Reload recreates the pipeline from the SPIR-V files on disk. The current
+// pipeline is kept when that fails. The device must be idle.
+func (p *Pipeline) Reload() error {
+	modules, pipeline, err := p.create()
+	if err != nil {
+		return err
 	}
+
+	p.destroyPipeline()
+	p.shaderModules, p.pipeline = modules, pipeline
+
+	return nil
 }
 
-func (p *Pipeline) Bind(commandBuffer vulkan.CommandBuffer, descriptors vulkan.DescriptorSet) {
+// Bind binds the pipeline and descriptors, in set order starting at set 0.
+func (p *Pipeline) Bind(commandBuffer vulkan.CommandBuffer, descriptors []vulkan.DescriptorSet) {
 	vulkan.CmdBindPipeline(commandBuffer, vulkan.PipelineBindPointGraphics, p.pipeline)
-	vulkan.CmdBindDescriptorSets(commandBuffer, vulkan.PipelineBindPointGraphics, p.Layout, 0, 1, []vulkan.DescriptorSet{descriptors}, 0, nil)
+	vulkan.CmdBindDescriptorSets(commandBuffer, vulkan.PipelineBindPointGraphics, p.Layout, 0, uint32(len(descriptors)), descriptors, 0, nil)
 }
 
-func (p *Pipeline) Close() {
+func (p *Pipeline) destroyPipeline() {
 	for _, module := range p.shaderModules {
 		vulkan.DestroyShaderModule(p.device.LogicalDevice, module, nil)
 	}
-	vulkan.DestroyPipelineLayout(p.device.LogicalDevice, p.Layout, nil)
 	vulkan.DestroyPipeline(p.device.LogicalDevice, p.pipeline, nil)
 }
+
+func (p *Pipeline) Close() {
+	p.destroyPipeline()
+	vulkan.DestroyPipelineLayout(p.device.LogicalDevice, p.Layout, nil)
+}
@@ -0,0 +1,148 @@
+package renderer
+
+import (
+	"errors"
+	"game/device"
+	"image"
+	"unsafe"
+
+	"github.com/goki/vulkan"
+)
+
+// capture is a host visible copy of a swapchain image.
+type capture struct {
+	buffer vulkan.Buffer
+	memory *device.Allocation
+	extent vulkan.Extent2D
+	bgra   bool
+}
+
+// CheckScreenshot reports why the swapchain images cannot be captured, if
+// they cannot.
+func (r *Renderer) CheckScreenshot() error {
+	if !r.swapchainFactory.Swapchain.Copyable {
+		return errors.New("the surface does not allow copying swapchain images")
+	}
+	switch r.swapchainFactory.Format().Format {
+	case vulkan.FormatB8g8r8a8Srgb, vulkan.FormatB8g8r8a8Unorm, vulkan.FormatR8g8b8a8Srgb, vulkan.FormatR8g8b8a8Unorm:
+		return nil
+	}
+
+	return errors.New("screenshots need an 8 bit RGBA or BGRA surface format")
+}
+
+// recordCapture records a copy of the swapchain image of the frame, after
+// the render pass has left it ready to present.
+func (r *Renderer) recordCapture(cb vulkan.CommandBuffer) (*capture, error) {
+	s := r.swapchainFactory.Swapchain
+	format := r.swapchainFactory.Format().Format
+	c := &capture{
+		extent: s.Extent,
+		bgra:   format == vulkan.FormatB8g8r8a8Srgb || format == vulkan.FormatB8g8r8a8Unorm,
+	}
+
+	var err error
+	c.buffer, c.memory, err = r.device.CreateBuffer(
+		vulkan.DeviceSize(4*c.extent.Width*c.extent.Height),
+		vulkan.BufferUsageFlags(vulkan.BufferUsageTransferDstBit),
+		vulkan.MemoryPropertyFlags(vulkan.MemoryPropertyHostVisibleBit|vulkan.MemoryPropertyHostCoherentBit),
+	)
+	if err != nil {
+		return nil, err
+	}
+	r.device.NameBuffer(c.buffer, "screenshot")
+
+	src := s.Images[r.imageIdx]
+	subresource := vulkan.ImageSubresourceRange{
+		AspectMask: vulkan.ImageAspectFlags(vulkan.ImageAspectColorBit),
+		LevelCount: 1,
+		LayerCount: 1,
+	}
+	vulkan.CmdPipelineBarrier(
+		cb,
+		vulkan.PipelineStageFlags(vulkan.PipelineStageColorAttachmentOutputBit),
+		vulkan.PipelineStageFlags(vulkan.PipelineStageTransferBit),
+		0, 0, nil, 0, nil,
+		1,
+		[]vulkan.ImageMemoryBarrier{
+			{
+				SType:               vulkan.StructureTypeImageMemoryBarrier,
+				SrcAccessMask:       vulkan.AccessFlags(vulkan.AccessColorAttachmentWriteBit),
+				DstAccessMask:       vulkan.AccessFlags(vulkan.AccessTransferReadBit),
+				OldLayout:           vulkan.ImageLayoutPresentSrc,
+				NewLayout:           vulkan.ImageLayoutTransferSrcOptimal,
+				SrcQueueFamilyIndex: vulkan.QueueFamilyIgnored,
+				DstQueueFamilyIndex: vulkan.QueueFamilyIgnored,
+				Image:               src,
+				SubresourceRange:    subresource,
+			},
+		},
+	)
+	vulkan.CmdCopyImageToBuffer(cb, src, vulkan.ImageLayoutTransferSrcOptimal, c.buffer, 1, []vulkan.BufferImageCopy{
+		{
+			ImageSubresource: vulkan.ImageSubresourceLayers{
+				AspectMask: vulkan.ImageAspectFlags(vulkan.ImageAspectColorBit),
+				LayerCount: 1,
+			},
+			ImageExtent: vulkan.Extent3D{
+				Width:  c.extent.Width,
+				Height: c.extent.Height,
+				Depth:  1,
+			},
+		},
+	})
+	vulkan.CmdPipelineBarrier(
+		cb,
+		vulkan.PipelineStageFlags(vulkan.PipelineStageTransferBit),
+		vulkan.PipelineStageFlags(vulkan.PipelineStageBottomOfPipeBit|vulkan.PipelineStageHostBit),
+		0,
+		1,
+		[]vulkan.MemoryBarrier{
+			{
+				SType:         vulkan.StructureTypeMemoryBarrier,
+				SrcAccessMask: vulkan.AccessFlags(vulkan.AccessTransferWriteBit),
+				DstAccessMask: vulkan.AccessFlags(vulkan.AccessHostReadBit),
+			},
+		},
+		0, nil,
+		1,
+		[]vulkan.ImageMemoryBarrier{
+			{
+				SType:               vulkan.StructureTypeImageMemoryBarrier,
+				SrcAccessMask:       vulkan.AccessFlags(vulkan.AccessTransferReadBit),
+				OldLayout:           vulkan.ImageLayoutTransferSrcOptimal,
+				NewLayout:           vulkan.ImageLayoutPresentSrc,
+				SrcQueueFamilyIndex: vulkan.QueueFamilyIgnored,
+				DstQueueFamilyIndex: vulkan.QueueFamilyIgnored,
+				Image:               src,
+				SubresourceRange:    subresource,
+			},
+		},
+	)
+
+	return c, nil
+}
+
+// read returns the copied image once the frame has finished. The
+// presentation engine ignores alpha, so the image is made opaque.
+func (c *capture) read(d *device.Device) (*image.RGBA, error) {
+	data, err := d.Map(c.memory)
+	if err != nil {
+		return nil, err
+	}
+	img := image.NewRGBA(image.Rect(0, 0, int(c.extent.Width), int(c.extent.Height)))
+	copy(img.Pix, unsafe.Slice((*byte)(data), len(img.Pix)))
+	for i := 0; i < len(img.Pix); i += 4 {
+		if c.bgra {
+			img.Pix[i], img.Pix[i+2] = img.Pix[i+2], img.Pix[i]
+		}
+		img.Pix[i+3] = 0xff
+	}
+
+	return img, nil
+}
+
+func (c *capture) close(d *device.Device) {
+	vulkan.DestroyBuffer(d.LogicalDevice, c.buffer, nil)
+	d.Free(c.memory)
+}
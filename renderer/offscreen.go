@@ -0,0 +1,429 @@
+package renderer
+
+import (
+	"game/device"
+	"game/swapchain"
+	"image"
+	"unsafe"
+
+	"github.com/goki/vulkan"
+)
+
+const offscreenFormat = vulkan.FormatR8g8b8a8Srgb
+
+// Offscreen renders into a color and depth image that are not tied to a
+// swapchain and copies every finished frame back to host memory.
+type Offscreen struct {
+	RenderPass     vulkan.RenderPass
+	CommandBuffers []MultistageCommandBuffer
+	Extent         vulkan.Extent2D
+	// Samples is the sample count of the render pass attachments.
+	Samples vulkan.SampleCountFlagBits
+
+	device *device.Device
+	// multisampled color target resolved into colorImage, only created
+	// when Samples is above one
+	msaaImage   vulkan.Image
+	msaaMemory  *device.Allocation
+	msaaView    vulkan.ImageView
+	colorImage  vulkan.Image
+	colorMemory *device.Allocation
+	colorView   vulkan.ImageView
+	depthImage  vulkan.Image
+	depthMemory *device.Allocation
+	depthView   vulkan.ImageView
+	framebuffer vulkan.Framebuffer
+	readback    vulkan.Buffer
+	readbackMem *device.Allocation
+	renderFence vulkan.Fence
+	frameIdx    int
+
+	// Profiler, when set, is reset at the start of every frame and times
+	// the draw pass.
+	Profiler *device.Profiler
+}
+
+func createOffscreenRenderPass(d *device.Device, depthFormat vulkan.Format, samples vulkan.SampleCountFlagBits) (vulkan.RenderPass, error) {
+	attachments := []vulkan.AttachmentDescription{
+		{
+			Format:         offscreenFormat,
+			Samples:        vulkan.SampleCount1Bit,
+			LoadOp:         vulkan.AttachmentLoadOpClear,
+			StoreOp:        vulkan.AttachmentStoreOpStore,
+			StencilLoadOp:  vulkan.AttachmentLoadOpDontCare,
+			StencilStoreOp: vulkan.AttachmentStoreOpDontCare,
+			InitialLayout:  vulkan.ImageLayoutUndefined,
+			FinalLayout:    vulkan.ImageLayoutTransferSrcOptimal,
+		},
+		{
+			Format:         depthFormat,
+			Samples:        samples,
+			LoadOp:         vulkan.AttachmentLoadOpClear,
+			StoreOp:        vulkan.AttachmentStoreOpDontCare,
+			StencilLoadOp:  vulkan.AttachmentLoadOpDontCare,
+			StencilStoreOp: vulkan.AttachmentStoreOpDontCare,
+			InitialLayout:  vulkan.ImageLayoutUndefined,
+			FinalLayout:    vulkan.ImageLayoutDepthStencilAttachmentOptimal,
+		},
+	}
+	subpass := vulkan.SubpassDescription{
+		PipelineBindPoint:    vulkan.PipelineBindPointGraphics,
+		ColorAttachmentCount: 1,
+		PColorAttachments: []vulkan.AttachmentReference{
+			{
+				Attachment: 0,
+				Layout:     vulkan.ImageLayoutColorAttachmentOptimal,
+			},
+		},
+		PDepthStencilAttachment: &vulkan.AttachmentReference{
+			Attachment: 1,
+			Layout:     vulkan.ImageLayoutDepthStencilAttachmentOptimal,
+		},
+	}
+
+	// as in the swapchain, multisampled rendering goes to attachment 0 and
+	// is resolved into attachment 2, the image copied to the readback
+	if samples != vulkan.SampleCount1Bit {
+		resolve := attachments[0]
+		resolve.LoadOp = vulkan.AttachmentLoadOpDontCare
+		attachments[0].Samples = samples
+		attachments[0].StoreOp = vulkan.AttachmentStoreOpDontCare
+		attachments[0].FinalLayout = vulkan.ImageLayoutColorAttachmentOptimal
+		attachments = append(attachments, resolve)
+
+		subpass.PResolveAttachments = []vulkan.AttachmentReference{
+			{
+				Attachment: 2,
+				Layout:     vulkan.ImageLayoutColorAttachmentOptimal,
+			},
+		}
+	}
+
+	var renderPass vulkan.RenderPass
+	err := device.Check(vulkan.CreateRenderPass(d.LogicalDevice, &vulkan.RenderPassCreateInfo{
+		SType:           vulkan.StructureTypeRenderPassCreateInfo,
+		AttachmentCount: uint32(len(attachments)),
+		PAttachments:    attachments,
+		SubpassCount:    1,
+		PSubpasses:      []vulkan.SubpassDescription{subpass},
+		DependencyCount: 2,
+		PDependencies: []vulkan.SubpassDependency{
+			{
+				SrcSubpass:    vulkan.SubpassExternal,
+				SrcAccessMask: 0,
+				SrcStageMask:  vulkan.PipelineStageFlags(vulkan.PipelineStageColorAttachmentOutputBit | vulkan.PipelineStageEarlyFragmentTestsBit),
+				DstSubpass:    0,
+				DstStageMask:  vulkan.PipelineStageFlags(vulkan.PipelineStageColorAttachmentOutputBit | vulkan.PipelineStageEarlyFragmentTestsBit),
+				DstAccessMask: vulkan.AccessFlags(vulkan.AccessColorAttachmentWriteBit | vulkan.AccessDepthStencilAttachmentWriteBit),
+			},
+			{ // make the color writes visible to the copy into the readback buffer
+				SrcSubpass:    0,
+				SrcStageMask:  vulkan.PipelineStageFlags(vulkan.PipelineStageColorAttachmentOutputBit),
+				SrcAccessMask: vulkan.AccessFlags(vulkan.AccessColorAttachmentWriteBit),
+				DstSubpass:    vulkan.SubpassExternal,
+				DstStageMask:  vulkan.PipelineStageFlags(vulkan.PipelineStageTransferBit),
+				DstAccessMask: vulkan.AccessFlags(vulkan.AccessTransferReadBit),
+			},
+		},
+	}, nil, &renderPass), "create render pass")
+
+	return renderPass, err
+}
+
+func createImageView(
+	d *device.Device,
+	image vulkan.Image,
+	format vulkan.Format,
+	aspect vulkan.ImageAspectFlagBits,
+) (vulkan.ImageView, error) {
+	var view vulkan.ImageView
+	err := device.Check(vulkan.CreateImageView(d.LogicalDevice, &vulkan.ImageViewCreateInfo{
+		SType:    vulkan.StructureTypeImageViewCreateInfo,
+		Image:    image,
+		ViewType: vulkan.ImageViewType2d,
+		Format:   format,
+		SubresourceRange: vulkan.ImageSubresourceRange{
+			AspectMask:     vulkan.ImageAspectFlags(aspect),
+			BaseMipLevel:   0,
+			LevelCount:     1,
+			BaseArrayLayer: 0,
+			LayerCount:     1,
+		},
+	}, nil, &view), "create image view")
+
+	return view, err
+}
+
+// NewOffscreen creates the render targets of the given size, multisampled
+// with the highest sample count up to samples that the device supports.
+func NewOffscreen(d *device.Device, extent vulkan.Extent2D, framesInFlight, samples int) (*Offscreen, error) {
+	depthFormat, err := swapchain.FindDepthFormat(d)
+	if err != nil {
+		return nil, err
+	}
+
+	o := &Offscreen{
+		Extent:  extent,
+		Samples: swapchain.ChooseSampleCount(d, samples),
+		device:  d,
+	}
+	if o.RenderPass, err = createOffscreenRenderPass(d, depthFormat, o.Samples); err != nil {
+		return nil, err
+	}
+
+	imageInfo := func(format vulkan.Format, usage vulkan.ImageUsageFlagBits, samples vulkan.SampleCountFlagBits) vulkan.ImageCreateInfo {
+		return vulkan.ImageCreateInfo{
+			SType:     vulkan.StructureTypeImageCreateInfo,
+			ImageType: vulkan.ImageType2d,
+			Extent: vulkan.Extent3D{
+				Width:  extent.Width,
+				Height: extent.Height,
+				Depth:  1,
+			},
+			MipLevels:     1,
+			ArrayLayers:   1,
+			Format:        format,
+			Tiling:        vulkan.ImageTilingOptimal,
+			InitialLayout: vulkan.ImageLayoutUndefined,
+			Usage:         vulkan.ImageUsageFlags(usage),
+			Samples:       samples,
+			SharingMode:   vulkan.SharingModeExclusive,
+		}
+	}
+
+	if o.colorImage, o.colorMemory, err = d.CreateImageWithInfo(
+		imageInfo(offscreenFormat, vulkan.ImageUsageColorAttachmentBit|vulkan.ImageUsageTransferSrcBit, vulkan.SampleCount1Bit),
+		vulkan.MemoryPropertyFlags(vulkan.MemoryPropertyDeviceLocalBit),
+	); err != nil {
+		o.Close()
+		return nil, err
+	}
+	if o.colorView, err = createImageView(d, o.colorImage, offscreenFormat, vulkan.ImageAspectColorBit); err != nil {
+		o.Close()
+		return nil, err
+	}
+	attachments := []vulkan.ImageView{o.colorView}
+
+	if o.Samples != vulkan.SampleCount1Bit {
+		if o.msaaImage, o.msaaMemory, err = d.CreateImageWithInfo(
+			imageInfo(offscreenFormat, vulkan.ImageUsageColorAttachmentBit|vulkan.ImageUsageTransientAttachmentBit, o.Samples),
+			vulkan.MemoryPropertyFlags(vulkan.MemoryPropertyDeviceLocalBit),
+		); err != nil {
+			o.Close()
+			return nil, err
+		}
+		if o.msaaView, err = createImageView(d, o.msaaImage, offscreenFormat, vulkan.ImageAspectColorBit); err != nil {
+			o.Close()
+			return nil, err
+		}
+		attachments = []vulkan.ImageView{o.msaaView}
+	}
+
+	if o.depthImage, o.depthMemory, err = d.CreateImageWithInfo(
+		imageInfo(depthFormat, vulkan.ImageUsageDepthStencilAttachmentBit, o.Samples),
+		vulkan.MemoryPropertyFlags(vulkan.MemoryPropertyDeviceLocalBit),
+	); err != nil {
+		o.Close()
+		return nil, err
+	}
+	if o.depthView, err = createImageView(d, o.depthImage, depthFormat, vulkan.ImageAspectDepthBit); err != nil {
+		o.Close()
+		return nil, err
+	}
+	attachments = append(attachments, o.depthView)
+	if o.Samples != vulkan.SampleCount1Bit {
+		attachments = append(attachments, o.colorView)
+	}
+
+	if err := device.Check(vulkan.CreateFramebuffer(d.LogicalDevice, &vulkan.FramebufferCreateInfo{
+		SType:           vulkan.StructureTypeFramebufferCreateInfo,
+		RenderPass:      o.RenderPass,
+		AttachmentCount: uint32(len(attachments)),
+		PAttachments:    attachments,
+		Width:           extent.Width,
+		Height:          extent.Height,
+		Layers:          1,
+	}, nil, &o.framebuffer), "create framebuffer"); err != nil {
+		o.Close()
+		return nil, err
+	}
+
+	if o.readback, o.readbackMem, err = d.CreateBuffer(
+		vulkan.DeviceSize(extent.Width*extent.Height*4),
+		vulkan.BufferUsageFlags(vulkan.BufferUsageTransferDstBit),
+		vulkan.MemoryPropertyFlags(vulkan.MemoryPropertyHostVisibleBit|vulkan.MemoryPropertyHostCoherentBit),
+	); err != nil {
+		o.Close()
+		return nil, err
+	}
+
+	if err := device.Check(vulkan.CreateFence(d.LogicalDevice, &vulkan.FenceCreateInfo{
+		SType: vulkan.StructureTypeFenceCreateInfo,
+	}, nil, &o.renderFence), "create render fence"); err != nil {
+		o.Close()
+		return nil, err
+	}
+
+	if o.CommandBuffers, err = createCommandBuffers(d, framesInFlight); err != nil {
+		o.Close()
+		return nil, err
+	}
+
+	return o, nil
+}
+
+func (o *Offscreen) BeginFrame() (*MultistageCommandBuffer, uint32, error) {
+	cb := o.CommandBuffers[o.frameIdx]
+
+	if err := device.Check(vulkan.BeginCommandBuffer(cb.ComputeCommandBuffer, &vulkan.CommandBufferBeginInfo{
+		SType: vulkan.StructureTypeCommandBufferBeginInfo,
+	}), "begin recording command buffer"); err != nil {
+		return nil, 0, err
+	}
+	o.Profiler.BeginFrame(cb.ComputeCommandBuffer, uint32(o.frameIdx))
+
+	return &cb, uint32(o.frameIdx), nil
+}
+
+func (o *Offscreen) BeginRenderPass() error {
+	cb := o.CommandBuffers[o.frameIdx].GraphicsCommandBuffer
+
+	if err := device.Check(vulkan.BeginCommandBuffer(cb, &vulkan.CommandBufferBeginInfo{
+		SType: vulkan.StructureTypeCommandBufferBeginInfo,
+	}), "begin recording command buffer"); err != nil {
+		return err
+	}
+	o.device.BeginLabel(cb, PassDraw, device.LabelGraphics)
+	o.Profiler.Begin(cb, uint32(o.frameIdx), PassDraw)
+
+	vulkan.CmdBeginRenderPass(cb, &vulkan.RenderPassBeginInfo{
+		SType:       vulkan.StructureTypeRenderPassBeginInfo,
+		RenderPass:  o.RenderPass,
+		Framebuffer: o.framebuffer,
+		RenderArea: vulkan.Rect2D{
+			Offset: vulkan.Offset2D{},
+			Extent: o.Extent,
+		},
+		ClearValueCount: 2,
+		PClearValues: []vulkan.ClearValue{
+			vulkan.NewClearValue([]float32{0.1, 0.1, 0.1, 1.0}),
+			vulkan.NewClearDepthStencil(1.0, 0),
+		},
+	}, vulkan.SubpassContentsInline)
+
+	vulkan.CmdSetViewport(cb, 0, 1, []vulkan.Viewport{
+		{
+			Width:    float32(o.Extent.Width),
+			Height:   float32(o.Extent.Height),
+			MinDepth: 0,
+			MaxDepth: 1,
+		},
+	})
+	vulkan.CmdSetScissor(cb, 0, 1, []vulkan.Rect2D{
+		{
+			Offset: vulkan.Offset2D{},
+			Extent: o.Extent,
+		},
+	})
+
+	return nil
+}
+
+func (o *Offscreen) EndRenderPass() {
+	cb := o.CommandBuffers[o.frameIdx].GraphicsCommandBuffer
+	vulkan.CmdEndRenderPass(cb)
+	o.Profiler.End(cb, uint32(o.frameIdx), PassDraw)
+	o.device.EndLabel(cb)
+}
+
+// EndFrame submits the frame, waits for it to finish and returns the
+// rendered image.
+func (o *Offscreen) EndFrame(computeSemaphore vulkan.Semaphore) (*image.RGBA, error) {
+	cb := o.CommandBuffers[o.frameIdx].GraphicsCommandBuffer
+
+	vulkan.CmdCopyImageToBuffer(cb, o.colorImage, vulkan.ImageLayoutTransferSrcOptimal, o.readback, 1, []vulkan.BufferImageCopy{
+		{
+			ImageSubresource: vulkan.ImageSubresourceLayers{
+				AspectMask: vulkan.ImageAspectFlags(vulkan.ImageAspectColorBit),
+				LayerCount: 1,
+			},
+			ImageExtent: vulkan.Extent3D{
+				Width:  o.Extent.Width,
+				Height: o.Extent.Height,
+				Depth:  1,
+			},
+		},
+	})
+	vulkan.CmdPipelineBarrier(
+		cb,
+		vulkan.PipelineStageFlags(vulkan.PipelineStageTransferBit),
+		vulkan.PipelineStageFlags(vulkan.PipelineStageHostBit),
+		0,
+		1,
+		[]vulkan.MemoryBarrier{
+			{
+				SType:         vulkan.StructureTypeMemoryBarrier,
+				SrcAccessMask: vulkan.AccessFlags(vulkan.AccessTransferWriteBit),
+				DstAccessMask: vulkan.AccessFlags(vulkan.AccessHostReadBit),
+			},
+		},
+		0, nil, 0, nil,
+	)
+
+	if err := device.Check(vulkan.EndCommandBuffer(cb), "end command buffer"); err != nil {
+		return nil, err
+	}
+
+	if err := device.Check(vulkan.QueueSubmit(o.device.Queue, 1, []vulkan.SubmitInfo{
+		{
+			SType:              vulkan.StructureTypeSubmitInfo,
+			WaitSemaphoreCount: 1,
+			PWaitSemaphores: []vulkan.Semaphore{
+				computeSemaphore,
+			},
+			PWaitDstStageMask: []vulkan.PipelineStageFlags{
+				vulkan.PipelineStageFlags(vulkan.PipelineStageDrawIndirectBit | vulkan.PipelineStageVertexShaderBit),
+			},
+			CommandBufferCount: 1,
+			PCommandBuffers:    []vulkan.CommandBuffer{cb},
+		},
+	}, o.renderFence), "submit command buffer to queue"); err != nil {
+		return nil, err
+	}
+
+	if err := device.Check(vulkan.WaitForFences(o.device.LogicalDevice, 1, []vulkan.Fence{o.renderFence}, vulkan.True, swapchain.MaxUint64), "wait for offscreen frame"); err != nil {
+		return nil, err
+	}
+	if err := device.Check(vulkan.ResetFences(o.device.LogicalDevice, 1, []vulkan.Fence{o.renderFence}), "reset render fence"); err != nil {
+		return nil, err
+	}
+
+	size := int(o.Extent.Width * o.Extent.Height * 4)
+	data, err := o.device.Map(o.readbackMem)
+	if err != nil {
+		return nil, err
+	}
+	img := image.NewRGBA(image.Rect(0, 0, int(o.Extent.Width), int(o.Extent.Height)))
+	copy(img.Pix, unsafe.Slice((*byte)(data), size))
+
+	o.frameIdx = (o.frameIdx + 1) % len(o.CommandBuffers)
+
+	return img, nil
+}
+
+func (o *Offscreen) Close() {
+	vulkan.DestroyFence(o.device.LogicalDevice, o.renderFence, nil)
+	vulkan.DestroyBuffer(o.device.LogicalDevice, o.readback, nil)
+	o.device.Free(o.readbackMem)
+	vulkan.DestroyFramebuffer(o.device.LogicalDevice, o.framebuffer, nil)
+	vulkan.DestroyImageView(o.device.LogicalDevice, o.msaaView, nil)
+	vulkan.DestroyImage(o.device.LogicalDevice, o.msaaImage, nil)
+	o.device.Free(o.msaaMemory)
+	vulkan.DestroyImageView(o.device.LogicalDevice, o.colorView, nil)
+	vulkan.DestroyImage(o.device.LogicalDevice, o.colorImage, nil)
+	o.device.Free(o.colorMemory)
+	vulkan.DestroyImageView(o.device.LogicalDevice, o.depthView, nil)
+	vulkan.DestroyImage(o.device.LogicalDevice, o.depthImage, nil)
+	o.device.Free(o.depthMemory)
+	vulkan.DestroyRenderPass(o.device.LogicalDevice, o.RenderPass, nil)
+}
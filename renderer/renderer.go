@@ -1,8 +1,10 @@
 package renderer
 
 import (
+	"fmt"
 	"game/device"
 	"game/swapchain"
+	"image"
 
 	"github.com/goki/vulkan"
 )
@@ -13,84 +15,119 @@ type MultistageCommandBuffer struct {
 }
 
 type Renderer struct {
+	device           *device.Device
 	RenderPass       vulkan.RenderPass
+	Samples          vulkan.SampleCountFlagBits
 	CommandBuffers   []MultistageCommandBuffer
 	swapchainFactory *swapchain.SwapchainFactory
 	imageIdx         int
 	frameIdx         int
+
+	// Profiler, when set, is reset at the start of every frame and times
+	// the draw pass.
+	Profiler *device.Profiler
 }
 
-func createCommandBuffers(device *device.Device) []MultistageCommandBuffer {
-	commandBuffers := make([]vulkan.CommandBuffer, swapchain.MAX_FRAMES_IN_FLIGHT)
-	if err := vulkan.Error(vulkan.AllocateCommandBuffers(device.LogicalDevice, &vulkan.CommandBufferAllocateInfo{
+// PassDraw is the name of the profiled graphics pass.
+const PassDraw = "draw"
+
+func createCommandBuffers(d *device.Device, framesInFlight int) ([]MultistageCommandBuffer, error) {
+	commandBuffers := make([]vulkan.CommandBuffer, framesInFlight)
+	if err := device.Check(vulkan.AllocateCommandBuffers(d.LogicalDevice, &vulkan.CommandBufferAllocateInfo{
 		SType:              vulkan.StructureTypeCommandBufferAllocateInfo,
 		Level:              vulkan.CommandBufferLevelPrimary,
-		CommandPool:        device.Pool,
+		CommandPool:        d.Pool,
 		CommandBufferCount: uint32(len(commandBuffers)),
-	}, commandBuffers)); err != nil {
-		panic("failed to allocate command buffers: " + err.Error())
+	}, commandBuffers), "allocate command buffers"); err != nil {
+		return nil, err
 	}
 
-	computeCommandBuffers := make([]vulkan.CommandBuffer, swapchain.MAX_FRAMES_IN_FLIGHT)
-	if err := vulkan.Error(vulkan.AllocateCommandBuffers(device.LogicalDevice, &vulkan.CommandBufferAllocateInfo{
+	computeCommandBuffers := make([]vulkan.CommandBuffer, framesInFlight)
+	if err := device.Check(vulkan.AllocateCommandBuffers(d.LogicalDevice, &vulkan.CommandBufferAllocateInfo{
 		SType:              vulkan.StructureTypeCommandBufferAllocateInfo,
 		Level:              vulkan.CommandBufferLevelPrimary,
-		CommandPool:        device.ComputePool,
+		CommandPool:        d.ComputePool,
 		CommandBufferCount: uint32(len(computeCommandBuffers)),
-	}, computeCommandBuffers)); err != nil {
-		panic("failed to allocate command buffers: " + err.Error())
+	}, computeCommandBuffers), "allocate command buffers"); err != nil {
+		vulkan.FreeCommandBuffers(d.LogicalDevice, d.Pool, uint32(len(commandBuffers)), commandBuffers)
+		return nil, err
 	}
 
-	multistageCommandBuffers := make([]MultistageCommandBuffer, swapchain.MAX_FRAMES_IN_FLIGHT)
+	multistageCommandBuffers := make([]MultistageCommandBuffer, framesInFlight)
 	for i := range multistageCommandBuffers {
 		multistageCommandBuffers[i].GraphicsCommandBuffer = commandBuffers[i]
 		multistageCommandBuffers[i].ComputeCommandBuffer = computeCommandBuffers[i]
+		d.NameCommandBuffer(commandBuffers[i], fmt.Sprintf("graphics[frame %d]", i))
+		d.NameCommandBuffer(computeCommandBuffers[i], fmt.Sprintf("compute[frame %d]", i))
 	}
 
-	return multistageCommandBuffers
+	return multistageCommandBuffers, nil
 }
 
-func New(device *device.Device, extent vulkan.Extent2D) *Renderer {
-	swapchainFactory := swapchain.New(device, extent)
+func New(d *device.Device, extent vulkan.Extent2D, config swapchain.Config) (*Renderer, error) {
+	swapchainFactory, err := swapchain.New(d, extent, config)
+	if err != nil {
+		return nil, err
+	}
+
+	commandBuffers, err := createCommandBuffers(d, swapchainFactory.FramesInFlight())
+	if err != nil {
+		swapchainFactory.Close()
+		return nil, err
+	}
 
-	commandBuffers := createCommandBuffers(device)
 	return &Renderer{
+		device:           d,
 		RenderPass:       swapchainFactory.RenderPass,
+		Samples:          swapchainFactory.Samples,
 		swapchainFactory: swapchainFactory,
 		CommandBuffers:   commandBuffers,
-	}
+	}, nil
+}
+
+func (r *Renderer) UpdateSwapchain(extent vulkan.Extent2D) error {
+	return r.swapchainFactory.UpdateSwapchain(extent)
+}
+
+// ColorSpace is the color space of the swapchain images.
+func (r *Renderer) ColorSpace() vulkan.ColorSpace {
+	return r.swapchainFactory.Format().ColorSpace
 }
 
-func (r *Renderer) UpdateSwapchain(extent vulkan.Extent2D) {
-	r.swapchainFactory.UpdateSwapchain(extent)
+// Extent is the size of the current swapchain images.
+func (r *Renderer) Extent() vulkan.Extent2D {
+	return r.swapchainFactory.Swapchain.Extent
 }
 
 func (r *Renderer) BeginFrame() (*MultistageCommandBuffer, uint32, error) {
 	var err error
 	r.imageIdx, err = r.swapchainFactory.Swapchain.NextImage(r.frameIdx)
-	if err == swapchain.ErrOutOfDate {
+	if err != nil {
 		return nil, 0, err
 	}
 
 	cb := r.CommandBuffers[r.frameIdx]
 
-	if err := vulkan.Error(vulkan.BeginCommandBuffer(cb.ComputeCommandBuffer, &vulkan.CommandBufferBeginInfo{
+	if err := device.Check(vulkan.BeginCommandBuffer(cb.ComputeCommandBuffer, &vulkan.CommandBufferBeginInfo{
 		SType: vulkan.StructureTypeCommandBufferBeginInfo,
-	})); err != nil {
-		panic("failed to begin recording command buffer:" + err.Error())
+	}), "begin recording command buffer"); err != nil {
+		return nil, 0, err
 	}
+	r.Profiler.BeginFrame(cb.ComputeCommandBuffer, uint32(r.frameIdx))
 
 	return &cb, uint32(r.frameIdx), nil
 }
 
-func (r *Renderer) BeginSwapChainRenderPass() {
+func (r *Renderer) BeginSwapChainRenderPass() error {
 	cb := r.CommandBuffers[r.frameIdx].GraphicsCommandBuffer
 
-	if err := vulkan.Error(vulkan.BeginCommandBuffer(cb, &vulkan.CommandBufferBeginInfo{
+	if err := device.Check(vulkan.BeginCommandBuffer(cb, &vulkan.CommandBufferBeginInfo{
 		SType: vulkan.StructureTypeCommandBufferBeginInfo,
-	})); err != nil {
-		panic("failed to begin recording command buffer:" + err.Error())
+	}), "begin recording command buffer"); err != nil {
+		return err
 	}
+	r.device.BeginLabel(cb, PassDraw, device.LabelGraphics)
+	r.Profiler.Begin(cb, uint32(r.frameIdx), PassDraw)
 
 	vulkan.CmdBeginRenderPass(cb, &vulkan.RenderPassBeginInfo{
 		SType:       vulkan.StructureTypeRenderPassBeginInfo,
@@ -125,23 +162,50 @@ func (r *Renderer) BeginSwapChainRenderPass() {
 		},
 	})
 
+	return nil
 }
 
 func (r *Renderer) EndSwapChainRenderPass() {
-	vulkan.CmdEndRenderPass(r.CommandBuffers[r.frameIdx].GraphicsCommandBuffer)
+	cb := r.CommandBuffers[r.frameIdx].GraphicsCommandBuffer
+	vulkan.CmdEndRenderPass(cb)
+	r.Profiler.End(cb, uint32(r.frameIdx), PassDraw)
+	r.device.EndLabel(cb)
 }
 
-func (r *Renderer) EndFrame(computeSemaphore vulkan.Semaphore) {
-	if err := vulkan.Error(vulkan.EndCommandBuffer(r.CommandBuffers[r.frameIdx].GraphicsCommandBuffer)); err != nil {
-		panic("failed to end command buffer: " + err.Error())
+// EndFrame submits and presents the frame. With screenshot set it also waits
+// for the frame to finish and returns the presented image, which
+// CheckScreenshot has to allow.
+func (r *Renderer) EndFrame(computeSemaphore vulkan.Semaphore, screenshot bool) (*image.RGBA, error) {
+	cb := r.CommandBuffers[r.frameIdx].GraphicsCommandBuffer
+	var c *capture
+	if screenshot {
+		var err error
+		if c, err = r.recordCapture(cb); err != nil {
+			return nil, err
+		}
+		defer c.close(r.device)
+	}
+
+	if err := device.Check(vulkan.EndCommandBuffer(cb), "end command buffer"); err != nil {
+		return nil, err
 	}
-	r.swapchainFactory.Swapchain.SubmitCommandBuffer(
-		r.CommandBuffers[r.frameIdx].GraphicsCommandBuffer,
-		uint32(r.frameIdx),
+	frameIdx := r.frameIdx
+	err := r.swapchainFactory.Swapchain.SubmitCommandBuffer(
+		cb,
+		uint32(frameIdx),
 		uint32(r.imageIdx),
 		computeSemaphore,
 	)
-	r.frameIdx = (r.frameIdx + 1) % swapchain.MAX_FRAMES_IN_FLIGHT
+	r.frameIdx = (r.frameIdx + 1) % len(r.CommandBuffers)
+	if err != nil || c == nil {
+		return nil, err
+	}
+
+	if err := r.swapchainFactory.Swapchain.WaitFrame(frameIdx); err != nil {
+		return nil, err
+	}
+
+	return c.read(r.device)
 }
 
 func (r *Renderer) Close() {
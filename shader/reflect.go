@@ -0,0 +1,511 @@
+package shader
+
+import (
+	"errors"
+	"fmt"
+	"game/layout"
+	"reflect"
+	"strings"
+
+	"github.com/goki/vulkan"
+)
+
+// SPIR-V opcodes, decorations and storage classes used by the reflection.
+const (
+	opName           = 5
+	opMemberName     = 6
+	opEntryPoint     = 15
+	opTypeInt        = 21
+	opTypeFloat      = 22
+	opTypeVector     = 23
+	opTypeMatrix     = 24
+	opTypeArray      = 28
+	opTypeRuntime    = 29
+	opTypeStruct     = 30
+	opTypePointer    = 32
+	opConstant       = 43
+	opVariable       = 59
+	opDecorate       = 71
+	opMemberDecorate = 72
+
+	decorationBufferBlock   = 3
+	decorationArrayStride   = 6
+	decorationMatrixStride  = 7
+	decorationBinding       = 33
+	decorationDescriptorSet = 34
+	decorationOffset        = 35
+
+	storageUniform       = 2
+	storagePushConstant  = 9
+	storageStorageBuffer = 12
+)
+
+// Struct is a block or struct type with its explicit layout. Align is its
+// std430 base alignment; under std140 structs are aligned to 16 bytes.
+type Struct struct {
+	Name    string
+	Members []Member
+	Align   uint32
+}
+
+// Member is a member of a Struct. Size is zero for runtime arrays and does
+// not include the trailing padding of structs. Struct is set for members
+// that are structs or arrays of structs.
+type Member struct {
+	Name        string
+	Offset      uint32
+	Size        uint32
+	ArrayStride uint32
+	Struct      *Struct
+}
+
+// Size is the number of bytes the sized members span, without the padding
+// to the alignment of the struct.
+func (s *Struct) Size() uint32 {
+	var size uint32
+	for _, member := range s.Members {
+		size = max(size, member.Offset+member.Size)
+	}
+
+	return size
+}
+
+// Binding is a buffer bound through a descriptor set.
+type Binding struct {
+	Set     uint32
+	Binding uint32
+	Type    vulkan.DescriptorType
+	Block   *Struct
+}
+
+// Module is the interface of a SPIR-V module as far as the pipelines care.
+type Module struct {
+	EntryPoints   []string
+	Bindings      []Binding
+	PushConstants *Struct
+}
+
+// Binding returns the buffer at set and binding, or nil.
+func (m *Module) Binding(set, binding uint32) *Binding {
+	for i := range m.Bindings {
+		if m.Bindings[i].Set == set && m.Bindings[i].Binding == binding {
+			return &m.Bindings[i]
+		}
+	}
+
+	return nil
+}
+
+// Reflect parses the named SPIR-V file, see CreateShaderModule.
+func Reflect(name string) (*Module, error) {
+	code, err := readFile(name)
+	if err != nil {
+		return nil, err
+	}
+	if err := validate(code); err != nil {
+		return nil, fmt.Errorf("invalid shader %s: %w", name, err)
+	}
+
+	module, err := Parse(code)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reflect shader %s: %w", name, err)
+	}
+
+	return module, nil
+}
+
+type spirvType struct {
+	op      uint32
+	width   uint32
+	count   uint32
+	element uint32
+	length  uint32 // id of the constant holding the array length
+	members []uint32
+}
+
+type decorations struct {
+	bufferBlock  bool
+	set, binding uint32
+	arrayStride  uint32
+}
+
+type memberKey struct {
+	id     uint32
+	member uint32
+}
+
+type memberDecorations struct {
+	offset, matrixStride uint32
+}
+
+type parser struct {
+	names       map[uint32]string
+	memberNames map[memberKey]string
+	types       map[uint32]*spirvType
+	constants   map[uint32]uint32
+	decorations map[uint32]*decorations
+	members     map[memberKey]*memberDecorations
+	variables   [][3]uint32 // type, id, storage class
+	pointers    map[uint32][2]uint32
+	entryPoints []string
+	structs     map[uint32]*Struct
+}
+
+// Parse extracts the entry points, buffer bindings and push constant block
+// of a SPIR-V module.
+func Parse(code []byte) (*Module, error) {
+	if err := validate(code); err != nil {
+		return nil, err
+	}
+	words := sliceUint32(code)
+
+	p := &parser{
+		names:       make(map[uint32]string),
+		memberNames: make(map[memberKey]string),
+		types:       make(map[uint32]*spirvType),
+		constants:   make(map[uint32]uint32),
+		decorations: make(map[uint32]*decorations),
+		members:     make(map[memberKey]*memberDecorations),
+		pointers:    make(map[uint32][2]uint32),
+		structs:     make(map[uint32]*Struct),
+	}
+	for i := 5; i < len(words); {
+		count := int(words[i] >> 16)
+		if count == 0 || i+count > len(words) {
+			return nil, errors.New("truncated instruction")
+		}
+		p.instruction(words[i]&0xffff, words[i+1:i+count])
+		i += count
+	}
+
+	return p.module()
+}
+
+func literalString(operands []uint32) string {
+	var b strings.Builder
+	for _, word := range operands {
+		for shift := 0; shift < 32; shift += 8 {
+			c := byte(word >> shift)
+			if c == 0 {
+				return b.String()
+			}
+			b.WriteByte(c)
+		}
+	}
+
+	return b.String()
+}
+
+func (p *parser) decoration(id uint32) *decorations {
+	if d, ok := p.decorations[id]; ok {
+		return d
+	}
+	d := &decorations{}
+	p.decorations[id] = d
+
+	return d
+}
+
+func (p *parser) instruction(op uint32, operands []uint32) {
+	// every handled instruction has at least two operands
+	if len(operands) < 2 {
+		return
+	}
+
+	switch op {
+	case opName:
+		p.names[operands[0]] = literalString(operands[1:])
+	case opMemberName:
+		if len(operands) > 2 {
+			p.memberNames[memberKey{operands[0], operands[1]}] = literalString(operands[2:])
+		}
+	case opEntryPoint:
+		if len(operands) > 2 {
+			p.entryPoints = append(p.entryPoints, literalString(operands[2:]))
+		}
+	case opTypeInt, opTypeFloat:
+		p.types[operands[0]] = &spirvType{op: op, width: operands[1]}
+	case opTypeVector, opTypeMatrix:
+		if len(operands) > 2 {
+			p.types[operands[0]] = &spirvType{op: op, element: operands[1], count: operands[2]}
+		}
+	case opTypeArray:
+		if len(operands) > 2 {
+			p.types[operands[0]] = &spirvType{op: op, element: operands[1], length: operands[2]}
+		}
+	case opTypeRuntime:
+		p.types[operands[0]] = &spirvType{op: op, element: operands[1]}
+	case opTypeStruct:
+		p.types[operands[0]] = &spirvType{op: op, members: operands[1:]}
+	case opTypePointer:
+		if len(operands) > 2 {
+			p.pointers[operands[0]] = [2]uint32{operands[1], operands[2]}
+		}
+	case opConstant:
+		if len(operands) > 2 {
+			p.constants[operands[1]] = operands[2]
+		}
+	case opVariable:
+		if len(operands) > 2 {
+			p.variables = append(p.variables, [3]uint32{operands[0], operands[1], operands[2]})
+		}
+	case opDecorate:
+		d := p.decoration(operands[0])
+		if operands[1] == decorationBufferBlock {
+			d.bufferBlock = true
+		}
+		if len(operands) < 3 {
+			return
+		}
+		switch operands[1] {
+		case decorationArrayStride:
+			d.arrayStride = operands[2]
+		case decorationBinding:
+			d.binding = operands[2]
+		case decorationDescriptorSet:
+			d.set = operands[2]
+		}
+	case opMemberDecorate:
+		if len(operands) < 4 {
+			return
+		}
+		key := memberKey{operands[0], operands[1]}
+		m, ok := p.members[key]
+		if !ok {
+			m = &memberDecorations{}
+			p.members[key] = m
+		}
+		switch operands[2] {
+		case decorationOffset:
+			m.offset = operands[3]
+		case decorationMatrixStride:
+			m.matrixStride = operands[3]
+		}
+	}
+}
+
+// size returns the size of a type, using the matrix stride of the member
+// it belongs to for matrices.
+func (p *parser) size(id, matrixStride uint32) (uint32, error) {
+	t, ok := p.types[id]
+	if !ok {
+		return 0, fmt.Errorf("unknown type %d", id)
+	}
+
+	switch t.op {
+	case opTypeInt, opTypeFloat:
+		return t.width / 8, nil
+	case opTypeVector:
+		size, err := p.size(t.element, 0)
+		return t.count * size, err
+	case opTypeMatrix:
+		if matrixStride == 0 {
+			column, err := p.size(t.element, 0)
+			return t.count * column, err
+		}
+		return t.count * matrixStride, nil
+	case opTypeArray:
+		return p.constants[t.length] * p.decoration(id).arrayStride, nil
+	case opTypeRuntime:
+		return 0, nil
+	case opTypeStruct:
+		s, err := p.structure(id)
+		if err != nil {
+			return 0, err
+		}
+		return s.Size(), nil
+	}
+
+	return 0, fmt.Errorf("unsupported type %d in block", id)
+}
+
+// align returns the std430 base alignment of a type.
+func (p *parser) align(id uint32) (uint32, error) {
+	t, ok := p.types[id]
+	if !ok {
+		return 0, fmt.Errorf("unknown type %d", id)
+	}
+
+	switch t.op {
+	case opTypeInt, opTypeFloat:
+		return t.width / 8, nil
+	case opTypeVector:
+		scalar, err := p.align(t.element)
+		if t.count == 2 {
+			return 2 * scalar, err
+		}
+		return 4 * scalar, err
+	case opTypeMatrix, opTypeArray, opTypeRuntime:
+		return p.align(t.element)
+	case opTypeStruct:
+		s, err := p.structure(id)
+		if err != nil {
+			return 0, err
+		}
+		return s.Align, nil
+	}
+
+	return 0, fmt.Errorf("unsupported type %d in block", id)
+}
+
+func (p *parser) structure(id uint32) (*Struct, error) {
+	if s, ok := p.structs[id]; ok {
+		return s, nil
+	}
+	t, ok := p.types[id]
+	if !ok || t.op != opTypeStruct {
+		return nil, fmt.Errorf("type %d is not a struct", id)
+	}
+
+	s := &Struct{Name: p.names[id], Align: 1}
+	for i, memberType := range t.members {
+		key := memberKey{id, uint32(i)}
+		decorations := p.members[key]
+		if decorations == nil {
+			decorations = &memberDecorations{}
+		}
+
+		member := Member{Name: p.memberNames[key], Offset: decorations.offset}
+		size, err := p.size(memberType, decorations.matrixStride)
+		if err != nil {
+			return nil, err
+		}
+		member.Size = size
+		align, err := p.align(memberType)
+		if err != nil {
+			return nil, err
+		}
+		s.Align = max(s.Align, align)
+
+		element := memberType
+		if mt := p.types[memberType]; mt != nil && (mt.op == opTypeArray || mt.op == opTypeRuntime) {
+			member.ArrayStride = p.decoration(memberType).arrayStride
+			element = mt.element
+		}
+		if et := p.types[element]; et != nil && et.op == opTypeStruct {
+			if member.Struct, err = p.structure(element); err != nil {
+				return nil, err
+			}
+		}
+		s.Members = append(s.Members, member)
+	}
+	p.structs[id] = s
+
+	return s, nil
+}
+
+func (p *parser) module() (*Module, error) {
+	m := &Module{EntryPoints: p.entryPoints}
+	for _, variable := range p.variables {
+		pointer, ok := p.pointers[variable[0]]
+		if !ok {
+			continue
+		}
+		storage, pointee := pointer[0], pointer[1]
+		if storage != storageUniform && storage != storagePushConstant && storage != storageStorageBuffer {
+			continue
+		}
+
+		block, err := p.structure(pointee)
+		if err != nil {
+			return nil, err
+		}
+		if storage == storagePushConstant {
+			m.PushConstants = block
+			continue
+		}
+
+		binding := Binding{
+			Set:     p.decoration(variable[1]).set,
+			Binding: p.decoration(variable[1]).binding,
+			Type:    vulkan.DescriptorTypeStorageBuffer,
+			Block:   block,
+		}
+		if storage == storageUniform && !p.decoration(pointee).bufferBlock {
+			binding.Type = vulkan.DescriptorTypeUniformBuffer
+		}
+		m.Bindings = append(m.Bindings, binding)
+	}
+
+	return m, nil
+}
+
+// CheckStruct compares the members of s with the fields of the Go struct
+// value v, skipping blank padding fields. Offsets and sizes must match in
+// order, names are not compared.
+func CheckStruct(s *Struct, v any) error {
+	t := reflect.TypeOf(v)
+	block := layout.Block{Name: t.String(), Size: int(t.Size())}
+	for i := range t.NumField() {
+		if field := t.Field(i); field.Name != "_" {
+			block.Fields = append(block.Fields, layout.Field{Name: field.Name, Offset: int(field.Offset), Size: int(field.Type.Size())})
+		}
+	}
+
+	return checkMembers(s, &block, false)
+}
+
+// CheckBlock compares the members of s with a block layout computed on the
+// Go side. The size of nested struct members is padded to their alignment
+// under the rules of block, as the Go side does.
+func CheckBlock(s *Struct, block *layout.Block) error {
+	return checkMembers(s, block, true)
+}
+
+func checkMembers(s *Struct, block *layout.Block, padded bool) error {
+	if len(block.Fields) != len(s.Members) {
+		return fmt.Errorf("%s has %d members, %s has %d fields", s.Name, len(s.Members), block.Name, len(block.Fields))
+	}
+	for i, member := range s.Members {
+		field := block.Fields[i]
+		if padded && member.Struct != nil && member.ArrayStride == 0 {
+			align := member.Struct.Align
+			if block.Rules == layout.Std140 {
+				align = max(align, 16)
+			}
+			member.Size = (member.Size + align - 1) / align * align
+		}
+		if uint32(field.Offset) != member.Offset || uint32(field.Size) != member.Size {
+			return fmt.Errorf("%s.%s is %d bytes at offset %d, %s.%s is %d bytes at offset %d",
+				s.Name, member.Name, member.Size, member.Offset,
+				block.Name, field.Name, field.Size, field.Offset)
+		}
+	}
+
+	return nil
+}
+
+// CheckArray compares the runtime array that ends the buffer at set and
+// binding with an array of block, including its stride.
+func (m *Module) CheckArray(set, binding uint32, block *layout.Block) error {
+	b := m.Binding(set, binding)
+	if b == nil {
+		return fmt.Errorf("no buffer at set %d binding %d", set, binding)
+	}
+	if len(b.Block.Members) == 0 {
+		return fmt.Errorf("%s is empty", b.Block.Name)
+	}
+
+	last := b.Block.Members[len(b.Block.Members)-1]
+	if last.Struct == nil || last.Size != 0 {
+		return fmt.Errorf("%s does not end with a runtime array of structs", b.Block.Name)
+	}
+	if uint32(block.Size) != last.ArrayStride {
+		return fmt.Errorf("%s.%s has a stride of %d bytes, %s is %d bytes", b.Block.Name, last.Name, last.ArrayStride, block.Name, block.Size)
+	}
+
+	return CheckBlock(last.Struct, block)
+}
+
+// CheckPushConstants compares the push constant block with the Go struct
+// value v, which may be larger than the block but not smaller.
+func (m *Module) CheckPushConstants(v any) error {
+	if m.PushConstants == nil {
+		return errors.New("no push constants")
+	}
+	if size := reflect.TypeOf(v).Size(); uint32(size) < m.PushConstants.Size() {
+		return fmt.Errorf("%s is %d bytes, %T is only %d bytes", m.PushConstants.Name, m.PushConstants.Size(), v, size)
+	}
+
+	return CheckStruct(m.PushConstants, v)
+}
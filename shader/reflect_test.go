@@ -0,0 +1,358 @@
+package shader_test
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"game/camera"
+	"game/gravity"
+	"game/layout"
+	"game/pipeline"
+	"game/shader"
+	"game/shaders"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/goki/vulkan"
+)
+
+// compileErr is set when glslc is on the PATH but the shaders do not
+// compile.
+var compileErr error
+
+// TestMain compiles the shaders into a temporary Dir when glslc is on the
+// PATH, so that the layouts are checked against the current sources rather
+// than whatever go generate last embedded.
+func TestMain(m *testing.M) {
+	os.Exit(func() int {
+		glslc, err := exec.LookPath("glslc")
+		if err != nil {
+			return m.Run()
+		}
+
+		dir, err := os.MkdirTemp("", "shaders")
+		if err != nil {
+			compileErr = err
+			return m.Run()
+		}
+		defer os.RemoveAll(dir)
+
+		for source, output := range shader.Sources {
+			out, err := exec.Command(glslc, filepath.Join("..", "shaders", source), "-o", filepath.Join(dir, output)).CombinedOutput()
+			if err != nil {
+				compileErr = fmt.Errorf("glslc %s: %w\n%s", source, err, out)
+				break
+			}
+		}
+		shader.Dir = dir
+
+		return m.Run()
+	}())
+}
+
+// requireSPIRV fails the test when the shaders did not compile and skips it
+// when there is neither glslc nor SPIR-V generated by go generate.
+func requireSPIRV(t *testing.T, names ...string) {
+	t.Helper()
+
+	if compileErr != nil {
+		t.Fatal(compileErr)
+	}
+	if shader.Dir != "" {
+		return
+	}
+	for _, name := range names {
+		if _, err := fs.Stat(shaders.FS, name); errors.Is(err, fs.ErrNotExist) {
+			t.Skipf("glslc is not on the PATH and %s has not been generated", name)
+		}
+	}
+}
+
+func TestComputeLayouts(t *testing.T) {
+	requireSPIRV(t, gravity.GravityShader, gravity.FieldShader)
+
+	if err := gravity.CheckLayouts(); err != nil {
+		t.Error(err)
+	}
+
+	module, err := shader.Reflect(gravity.GravityShader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := module.Binding(0, 0); b == nil || b.Type != vulkan.DescriptorTypeStorageBuffer {
+		t.Errorf("binding 0 of %s is %+v, want a storage buffer", gravity.GravityShader, b)
+	}
+}
+
+func TestGraphicsLayouts(t *testing.T) {
+	for _, spec := range []pipeline.Spec{pipeline.Scene, pipeline.Trails} {
+		t.Run(spec.Name, func(t *testing.T) {
+			requireSPIRV(t, spec.VertexShader)
+
+			if err := spec.CheckLayouts(); err != nil {
+				t.Error(err)
+			}
+
+			module, err := shader.Reflect(spec.VertexShader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if b := module.Binding(camera.Set, 0); b == nil || b.Type != vulkan.DescriptorTypeUniformBuffer {
+				t.Errorf("camera binding of %s is %+v, want a uniform buffer", spec.VertexShader, b)
+			}
+			if len(module.EntryPoints) != 1 || module.EntryPoints[0] != "main" {
+				t.Errorf("entry points = %q, want [main]", module.EntryPoints)
+			}
+		})
+	}
+}
+
+// assembler writes a SPIR-V module a word at a time.
+type assembler []uint32
+
+func newAssembler() assembler {
+	// magic, version 1.0, generator, id bound, schema
+	return assembler{0x07230203, 0x00010000, 0, 64, 0}
+}
+
+func (a *assembler) op(op uint32, operands ...uint32) {
+	*a = append(*a, uint32(len(operands)+1)<<16|op)
+	*a = append(*a, operands...)
+}
+
+// literal encodes a nul terminated string operand.
+func literal(s string) []uint32 {
+	b := make([]byte, (len(s)/4+1)*4)
+	copy(b, s)
+	words := make([]uint32, len(b)/4)
+	for i := range words {
+		words[i] = binary.LittleEndian.Uint32(b[4*i:])
+	}
+
+	return words
+}
+
+func (a assembler) bytes() []byte {
+	b := make([]byte, 4*len(a))
+	for i, word := range a {
+		binary.LittleEndian.PutUint32(b[4*i:], word)
+	}
+
+	return b
+}
+
+// SPIR-V opcodes and enumerants used by the test modules
+const (
+	opName           = 5
+	opMemberName     = 6
+	opEntryPoint     = 15
+	opTypeFloat      = 22
+	opTypeVector     = 23
+	opTypeRuntime    = 29
+	opTypeStruct     = 30
+	opTypePointer    = 32
+	opVariable       = 59
+	opDecorate       = 71
+	opMemberDecorate = 72
+
+	executionGLCompute = 5
+
+	decorationBlock         = 2
+	decorationArrayStride   = 6
+	decorationBinding       = 33
+	decorationDescriptorSet = 34
+	decorationOffset        = 35
+
+	storageUniform       = 2
+	storageStorageBuffer = 12
+)
+
+// nestedModule declares
+//
+//	struct Inner { vecN v; };
+//	layout(set = 0, binding = 0) <storage> Outer { Inner inner; float f; Inner items[]; };
+//
+// with the offsets and stride given.
+func nestedModule(vector, storage, fOffset, itemsOffset, stride uint32) []byte {
+	const (
+		float = iota + 1
+		vec
+		inner
+		items
+		outer
+		pointer
+		variable
+		main
+	)
+
+	a := newAssembler()
+	a.op(opEntryPoint, append([]uint32{executionGLCompute, main}, literal("main")...)...)
+	a.op(opName, append([]uint32{inner}, literal("Inner")...)...)
+	a.op(opMemberName, append([]uint32{inner, 0}, literal("v")...)...)
+	a.op(opName, append([]uint32{outer}, literal("Outer")...)...)
+	a.op(opMemberName, append([]uint32{outer, 0}, literal("inner")...)...)
+	a.op(opMemberName, append([]uint32{outer, 1}, literal("f")...)...)
+	a.op(opMemberName, append([]uint32{outer, 2}, literal("items")...)...)
+	a.op(opMemberDecorate, inner, 0, decorationOffset, 0)
+	a.op(opMemberDecorate, outer, 0, decorationOffset, 0)
+	a.op(opMemberDecorate, outer, 1, decorationOffset, fOffset)
+	a.op(opMemberDecorate, outer, 2, decorationOffset, itemsOffset)
+	a.op(opDecorate, items, decorationArrayStride, stride)
+	a.op(opDecorate, outer, decorationBlock)
+	a.op(opDecorate, variable, decorationDescriptorSet, 0)
+	a.op(opDecorate, variable, decorationBinding, 0)
+	a.op(opTypeFloat, float, 32)
+	a.op(opTypeVector, vec, float, vector)
+	a.op(opTypeStruct, inner, vec)
+	a.op(opTypeRuntime, items, inner)
+	a.op(opTypeStruct, outer, inner, float, items)
+	a.op(opTypePointer, pointer, storage, outer)
+	a.op(opVariable, pointer, variable, storage)
+
+	return a.bytes()
+}
+
+type vec3Inner struct {
+	V [3]float32
+}
+
+type vec3Outer struct {
+	Inner vec3Inner
+	F     float32
+}
+
+type vec2Inner struct {
+	V [2]float32
+}
+
+type vec2Outer struct {
+	Inner vec2Inner
+	F     float32
+}
+
+func TestNestedStructPadding(t *testing.T) {
+	tests := []struct {
+		name  string
+		code  []byte
+		outer *layout.Block
+		inner *layout.Block
+	}{
+		{
+			// struct { vec3 v; } is 12 bytes of members padded to 16
+			name:  "std430 vec3",
+			code:  nestedModule(3, storageStorageBuffer, 16, 32, 16),
+			outer: &layout.Must[vec3Outer](layout.Std430).Block,
+			inner: &layout.Must[vec3Inner](layout.Std430).Block,
+		},
+		{
+			name:  "std430 vec2",
+			code:  nestedModule(2, storageStorageBuffer, 8, 16, 8),
+			outer: &layout.Must[vec2Outer](layout.Std430).Block,
+			inner: &layout.Must[vec2Inner](layout.Std430).Block,
+		},
+		{
+			// std140 aligns structs to 16 whatever their members
+			name:  "std140 vec2",
+			code:  nestedModule(2, storageUniform, 16, 32, 16),
+			outer: &layout.Must[vec2Outer](layout.Std140).Block,
+			inner: &layout.Must[vec2Inner](layout.Std140).Block,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			module, err := shader.Parse(test.code)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b := module.Binding(0, 0)
+			if b == nil {
+				t.Fatal("no binding")
+			}
+
+			// the runtime array is not part of the Go block
+			block := *b.Block
+			block.Members = block.Members[:2]
+			if err := shader.CheckBlock(&block, test.outer); err != nil {
+				t.Error(err)
+			}
+			if err := module.CheckArray(0, 0, test.inner); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestNestedStructMismatch(t *testing.T) {
+	// f placed right after the 12 bytes of vec3 rather than after the
+	// padded struct
+	module, err := shader.Parse(nestedModule(3, storageStorageBuffer, 12, 16, 16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	block := *module.Binding(0, 0).Block
+	block.Members = block.Members[:2]
+	if err := shader.CheckBlock(&block, &layout.Must[vec3Outer](layout.Std430).Block); err == nil {
+		t.Error("no error for a member overlapping the struct padding")
+	}
+}
+
+func TestParseModule(t *testing.T) {
+	module, err := shader.Parse(nestedModule(3, storageUniform, 16, 32, 16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(module.EntryPoints) != 1 || module.EntryPoints[0] != "main" {
+		t.Errorf("entry points = %q, want [main]", module.EntryPoints)
+	}
+	b := module.Binding(0, 0)
+	if b == nil {
+		t.Fatal("no binding")
+	}
+	if b.Type != vulkan.DescriptorTypeUniformBuffer {
+		t.Errorf("type = %v, want a uniform buffer", b.Type)
+	}
+	if b.Block.Name != "Outer" || len(b.Block.Members) != 3 {
+		t.Fatalf("block = %+v", b.Block)
+	}
+	items := b.Block.Members[2]
+	if items.Name != "items" || items.Size != 0 || items.ArrayStride != 16 || items.Struct == nil || items.Struct.Name != "Inner" {
+		t.Errorf("items = %+v", items)
+	}
+	if inner := b.Block.Members[0].Struct; inner == nil || inner.Align != 16 || inner.Size() != 12 {
+		t.Errorf("inner = %+v", inner)
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	valid := nestedModule(3, storageStorageBuffer, 16, 32, 16)
+	badMagic := append([]byte{}, valid...)
+	binary.LittleEndian.PutUint32(badMagic, 0x03022307)
+	zeroCount := append(append([]byte{}, valid...), 0, 0, 0, 0)
+
+	tests := []struct {
+		name string
+		code []byte
+		want string
+	}{
+		{"empty", nil, "too short"},
+		{"header cut short", valid[:16], "too short"},
+		{"odd size", valid[:len(valid)-1], "multiple of 4"},
+		{"bad magic", badMagic, "bad magic"},
+		{"truncated instruction", valid[:len(valid)-4], "truncated"},
+		{"zero word count", zeroCount, "truncated"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := shader.Parse(test.code)
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("err = %v, want it to contain %q", err, test.want)
+			}
+		})
+	}
+}
@@ -2,12 +2,24 @@ package shader
 
 import (
 	"encoding/binary"
-	"io"
+	"errors"
+	"fmt"
+	"game/device"
+	"game/shaders"
+	"io/fs"
 	"os"
+	"path/filepath"
 
 	"github.com/goki/vulkan"
 )
 
+// spirvMagic is the first word of every SPIR-V module.
+const spirvMagic = 0x07230203
+
+// Dir overrides the embedded SPIR-V with the files in a directory, for
+// working on shaders without rebuilding the binary.
+var Dir string
+
 func sliceUint32(raw []byte) []uint32 {
 	const SIZEOF_UINT32 = 4
 
@@ -20,32 +32,62 @@ func sliceUint32(raw []byte) []uint32 {
 	return result
 }
 
-func CreateShaderModule(path string, logicalDevice vulkan.Device) vulkan.ShaderModule {
-	code := readFile(path)
+// validate checks that code looks like a SPIR-V module before it is handed
+// to the driver, which is not required to reject garbage gracefully.
+func validate(code []byte) error {
+	if len(code)%4 != 0 {
+		return fmt.Errorf("size %d is not a multiple of 4", len(code))
+	}
+	// the header alone is five words
+	if len(code) < 20 {
+		return errors.New("too short for a SPIR-V header")
+	}
+	if magic := binary.LittleEndian.Uint32(code); magic != spirvMagic {
+		return fmt.Errorf("bad magic number %#08x", magic)
+	}
+
+	return nil
+}
+
+// CreateShaderModule creates a module from the named SPIR-V file, read from
+// Dir when set and from the embedded shaders otherwise.
+func CreateShaderModule(name string, logicalDevice vulkan.Device) (vulkan.ShaderModule, error) {
+	code, err := readFile(name)
+	if err != nil {
+		return nil, err
+	}
+	if err := validate(code); err != nil {
+		return nil, fmt.Errorf("invalid shader %s: %w", name, err)
+	}
 
 	var shaderModule vulkan.ShaderModule
-	if err := vulkan.Error(vulkan.CreateShaderModule(logicalDevice, &vulkan.ShaderModuleCreateInfo{
+	if err := device.Check(vulkan.CreateShaderModule(logicalDevice, &vulkan.ShaderModuleCreateInfo{
 		SType:    vulkan.StructureTypeShaderModuleCreateInfo,
 		CodeSize: uint64(len(code)),
 		PCode:    sliceUint32(code),
-	}, nil, &shaderModule)); err != nil {
-		panic("failed to create shader module: " + err.Error())
+	}, nil, &shaderModule), "create shader module "+name); err != nil {
+		return nil, err
 	}
 
-	return shaderModule
+	return shaderModule, nil
 }
 
-func readFile(path string) []byte {
-	file, err := os.OpenFile(path, os.O_RDONLY, 0644)
-	if err != nil {
-		panic("failed to open shader file: " + err.Error())
+func readFile(name string) ([]byte, error) {
+	if Dir != "" {
+		buf, err := os.ReadFile(filepath.Join(Dir, name))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read shader file: %w", err)
+		}
+		return buf, nil
 	}
 
-	defer file.Close()
-	buf, err := io.ReadAll(file)
+	buf, err := fs.ReadFile(shaders.FS, name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("shader %s is not embedded, run go generate ./shaders before building", name)
+	}
 	if err != nil {
-		panic("failed to read shader file: " + err.Error())
+		return nil, fmt.Errorf("failed to read shader file: %w", err)
 	}
 
-	return buf
+	return buf, nil
 }
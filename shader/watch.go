@@ -0,0 +1,149 @@
+package shader
+
+import (
+	"log/slog"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Sources maps the GLSL sources in the shaders directory to the SPIR-V files
+// they are compiled to, as in the Makefile.
+var Sources = map[string]string{
+	"simple.vert":  "vert.spv",
+	"simple.frag":  "frag.spv",
+	"field.comp":   "field.comp.spv",
+	"gravity.comp": "gravity.comp.spv",
+	"trail.vert":   "trail.vert.spv",
+	"trail.frag":   "trail.frag.spv",
+}
+
+// Watcher polls a shaders directory and recompiles sources with glslc when
+// they change. Without glslc on the PATH it watches the SPIR-V files
+// instead, so that they can be rebuilt by hand.
+type Watcher struct {
+	dir      string
+	glslc    string
+	modTimes map[string]time.Time
+
+	mu      sync.Mutex
+	changed map[string]bool
+
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+func NewWatcher(dir string, interval time.Duration) *Watcher {
+	w := &Watcher{
+		dir:      dir,
+		modTimes: make(map[string]time.Time),
+		changed:  make(map[string]bool),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	if path, err := exec.LookPath("glslc"); err == nil {
+		w.glslc = path
+	} else {
+		slog.Info("glslc not found, watching compiled shaders only")
+	}
+
+	for _, name := range w.watched() {
+		w.modTimes[name] = modTime(filepath.Join(dir, name))
+	}
+	go w.run(interval)
+
+	return w
+}
+
+func (w *Watcher) watched() []string {
+	if w.glslc != "" {
+		return slices.Collect(maps.Keys(Sources))
+	}
+
+	return slices.Collect(maps.Values(Sources))
+}
+
+func modTime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+
+	return info.ModTime()
+}
+
+func (w *Watcher) run(interval time.Duration) {
+	defer close(w.stopped)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C:
+			w.poll()
+		}
+	}
+}
+
+func (w *Watcher) poll() {
+	for _, name := range w.watched() {
+		path := filepath.Join(w.dir, name)
+		current := modTime(path)
+		if current.Equal(w.modTimes[name]) {
+			continue
+		}
+		w.modTimes[name] = current
+
+		output := name
+		if w.glslc != "" {
+			output = Sources[name]
+			if !w.compile(path, filepath.Join(w.dir, output)) {
+				continue
+			}
+		}
+
+		w.mu.Lock()
+		w.changed[output] = true
+		w.mu.Unlock()
+	}
+}
+
+// compile writes to a temporary file first, so that the SPIR-V in use is
+// only replaced by a successful build.
+func (w *Watcher) compile(source, output string) bool {
+	tmp := output + ".tmp"
+	if out, err := exec.Command(w.glslc, source, "-o", tmp).CombinedOutput(); err != nil {
+		os.Remove(tmp)
+		slog.Error("shader compilation failed", slog.String("shader", source), slog.String("output", string(out)))
+		return false
+	}
+	if err := os.Rename(tmp, output); err != nil {
+		slog.Error("failed to replace shader", slog.String("shader", output), slog.Any("error", err))
+		return false
+	}
+
+	slog.Info("shader recompiled", slog.String("shader", source))
+	return true
+}
+
+// Changed returns the names of the SPIR-V files updated since the last call.
+func (w *Watcher) Changed() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	changed := slices.Sorted(maps.Keys(w.changed))
+	clear(w.changed)
+
+	return changed
+}
+
+func (w *Watcher) Close() {
+	close(w.done)
+	<-w.stopped
+}
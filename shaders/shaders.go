@@ -0,0 +1,19 @@
+// Package shaders holds the GLSL sources of the pipelines and embeds the
+// SPIR-V compiled from them by go generate.
+package shaders
+
+import "embed"
+
+//go:generate glslc simple.vert -o vert.spv
+//go:generate glslc simple.frag -o frag.spv
+//go:generate glslc field.comp -o field.comp.spv
+//go:generate glslc gravity.comp -o gravity.comp.spv
+//go:generate glslc trail.vert -o trail.vert.spv
+//go:generate glslc trail.frag -o trail.frag.spv
+
+// FS embeds the whole directory rather than *.spv so that the tree still
+// builds before go generate has run. Missing SPIR-V is reported when a
+// shader module is created.
+//
+//go:embed *
+var FS embed.FS
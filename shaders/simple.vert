@@ -13,6 +13,13 @@ struct MassObject {
 layout(location = 0) in vec2 inVertexPos;
 layout(location = 1) in vec3 color;
 
+// per instance, see model.Instance
+layout(location = 2) in vec4 inTransform;
+layout(location = 3) in vec2 inOffset;
+layout(location = 4) in vec3 inColor;
+
+layout(location = 0) flat out vec3 outColor;
+
 layout(std140, binding = 1) readonly buffer InMass{
 	MassObject massObjectsIn[];
 };
@@ -21,23 +28,27 @@ layout(std140, binding = 3) readonly buffer InForce{
 	ForceObject forceObjectIn[];
 };
 
+// world to clip space, see camera.Uniform
+layout(std140, set = 1, binding = 0) uniform Camera {
+	mat4 viewProjection;
+} camera;
+
 layout(push_constant) uniform Push {
-	mat2 transform;
-	vec2 offset;
 	uint isField;
-	uint index;
-	vec3 color;
 } push;
 
-void main() {	
+void main() {
+	mat2 transform = mat2(inTransform);
+	outColor = inColor;
+
 	if (push.isField == 0) {
-		MassObject obj = massObjectsIn[push.index];
+		MassObject obj = massObjectsIn[gl_InstanceIndex];
 
 		// Transform vertex by object position (no rotation or scaling here yet)
-		vec2 worldPos = push.transform * inVertexPos + obj.position;
-		gl_Position = vec4(worldPos, 0.0, 1.0);
+		vec2 worldPos = transform * inVertexPos + obj.position;
+		gl_Position = camera.viewProjection * vec4(worldPos, 0.0, 1.0);
 	} else {
-		ForceObject force = forceObjectIn[push.index];
+		ForceObject force = forceObjectIn[gl_InstanceIndex];
 		float forceMag = length(force.force);
 		float angle = atan(force.force[0], force.force[1]);
 
@@ -52,7 +63,7 @@ void main() {
 		vec2 scaledVertex = rotation * mat2(
 			1.0, 0.0,
 			0.0, scale * 200.0
-		) * push.transform * (vec2(0.0, 0.5) + inVertexPos) + push.offset;
-		gl_Position = vec4(scaledVertex, 0.0, 1.0);
+		) * transform * (vec2(0.0, 0.5) + inVertexPos) + inOffset;
+		gl_Position = camera.viewProjection * vec4(scaledVertex, 0.0, 1.0);
 	}
 }
\ No newline at end of file
@@ -0,0 +1,46 @@
+#version 450
+
+layout(location = 0) flat in vec3 color;
+layout(location = 1) in float alpha;
+
+layout (location = 0) out vec4 outColour;
+
+// outputTransfer selects how colors are encoded for the surface color
+// space: 0 leaves it to an sRGB format, 1 encodes HDR10 with the PQ curve
+// and 2 writes linear scRGB.
+layout(constant_id = 0) const uint outputTransfer = 0u;
+
+// BT.709 to BT.2020 primaries, by column
+const mat3 bt709ToBt2020 = mat3(
+	0.6274, 0.0691, 0.0164,
+	0.3293, 0.9195, 0.0880,
+	0.0433, 0.0114, 0.8956
+);
+
+// nits of SDR white in an HDR10 signal, after BT.2408
+const float referenceWhite = 203.0;
+
+// pq encodes linear light in units of 10000 nits with SMPTE ST 2084.
+vec3 pq(vec3 light) {
+	const float m1 = 2610.0 / 16384.0;
+	const float m2 = 2523.0 / 4096.0 * 128.0;
+	const float c1 = 3424.0 / 4096.0;
+	const float c2 = 2413.0 / 4096.0 * 32.0;
+	const float c3 = 2392.0 / 4096.0 * 32.0;
+
+	vec3 p = pow(max(light, vec3(0.0)), vec3(m1));
+	return pow((c1 + c2 * p) / (1.0 + c3 * p), vec3(m2));
+}
+
+vec3 encode(vec3 color) {
+	if (outputTransfer == 1u) {
+		return pq(bt709ToBt2020 * color * (referenceWhite / 10000.0));
+	}
+	// sRGB formats encode on store and scRGB is linear with the same
+	// primaries and white
+	return color;
+}
+
+void main() {
+	outColour = vec4(encode(color), alpha);
+}
\ No newline at end of file
@@ -0,0 +1,36 @@
+#version 450
+
+struct TrailPoint {
+	vec2 position;
+};
+
+layout(location = 4) in vec3 inColor;
+
+layout(location = 0) flat out vec3 outColor;
+layout(location = 1) out float outAlpha;
+
+layout(std430, binding = 5) readonly buffer InTrail{
+	TrailPoint trail[];
+};
+
+// world to clip space, see camera.Uniform
+layout(std140, set = 1, binding = 0) uniform Camera {
+	mat4 viewProjection;
+} camera;
+
+// the ring of the body drawn by this instance starts at
+// gl_InstanceIndex * length, head is the slot written last
+layout(push_constant) uniform Push {
+	uint head;
+	uint length;
+	uint count;
+} push;
+
+void main() {
+	uint age = gl_VertexIndex;
+	uint slot = (push.head + push.length - age) % push.length;
+
+	gl_Position = camera.viewProjection * vec4(trail[gl_InstanceIndex * push.length + slot].position, 0.0, 1.0);
+	outColor = inColor;
+	outAlpha = 1.0 - float(age) / float(push.count);
+}
\ No newline at end of file
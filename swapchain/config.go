@@ -0,0 +1,149 @@
+package swapchain
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/goki/vulkan"
+)
+
+// Config lists the presentation settings in order of preference. The first
+// one the surface supports is used.
+type Config struct {
+	PresentModes []vulkan.PresentMode
+	Formats      []vulkan.SurfaceFormat
+	// FramesInFlight is how many frames are recorded ahead of the GPU.
+	FramesInFlight int
+	// Samples is the requested MSAA sample count. The highest supported
+	// count up to it is used, 1 disables multisampling.
+	Samples int
+}
+
+var DefaultConfig = Config{
+	PresentModes:   []vulkan.PresentMode{vulkan.PresentModeMailbox, vulkan.PresentModeFifo},
+	Formats:        FormatsSRGB,
+	FramesInFlight: 2,
+	Samples:        4,
+}
+
+// Surface formats by the color space the fragment shaders encode for, see
+// pipeline.New. HDR10 and scRGB are only reported by surfaces when
+// VK_EXT_swapchain_colorspace is enabled.
+var (
+	FormatsSRGB = []vulkan.SurfaceFormat{
+		{Format: vulkan.FormatB8g8r8a8Srgb, ColorSpace: vulkan.ColorSpaceSrgbNonlinear},
+		{Format: vulkan.FormatR8g8b8a8Srgb, ColorSpace: vulkan.ColorSpaceSrgbNonlinear},
+	}
+	FormatsHDR10 = []vulkan.SurfaceFormat{
+		{Format: vulkan.FormatA2b10g10r10UnormPack32, ColorSpace: vulkan.ColorSpaceHdr10St2084},
+		{Format: vulkan.FormatA2r10g10b10UnormPack32, ColorSpace: vulkan.ColorSpaceHdr10St2084},
+	}
+	FormatsScRGB = []vulkan.SurfaceFormat{
+		{Format: vulkan.FormatR16g16b16a16Sfloat, ColorSpace: vulkan.ColorSpaceExtendedSrgbLinear},
+	}
+)
+
+var formatGroups = map[string][]vulkan.SurfaceFormat{
+	"srgb":  FormatsSRGB,
+	"hdr10": FormatsHDR10,
+	"scrgb": FormatsScRGB,
+}
+
+var presentModeNames = map[vulkan.PresentMode]string{
+	vulkan.PresentModeImmediate:   "immediate",
+	vulkan.PresentModeMailbox:     "mailbox",
+	vulkan.PresentModeFifo:        "fifo",
+	vulkan.PresentModeFifoRelaxed: "fifo-relaxed",
+}
+
+func presentModeString(mode vulkan.PresentMode) string {
+	if name, ok := presentModeNames[mode]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("PresentMode(%d)", mode)
+}
+
+func formatString(format vulkan.SurfaceFormat) string {
+	for name, group := range formatGroups {
+		for _, f := range group {
+			if f.Format == format.Format && f.ColorSpace == format.ColorSpace {
+				return name
+			}
+		}
+	}
+
+	return fmt.Sprintf("Format(%d) ColorSpace(%d)", format.Format, format.ColorSpace)
+}
+
+// ParsePresentModes reads a comma separated preference list of immediate,
+// mailbox, fifo or fifo-relaxed. vsync is short for fifo.
+func ParsePresentModes(list string) ([]vulkan.PresentMode, error) {
+	var modes []vulkan.PresentMode
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if name == "vsync" {
+			name = "fifo"
+		}
+
+		found := false
+		for mode, modeName := range presentModeNames {
+			if modeName == name {
+				modes = append(modes, mode)
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown present mode %q", name)
+		}
+	}
+
+	return modes, nil
+}
+
+// ParseFormats reads a comma separated preference list of srgb, hdr10 or
+// scrgb.
+func ParseFormats(list string) ([]vulkan.SurfaceFormat, error) {
+	var formats []vulkan.SurfaceFormat
+	for _, name := range strings.Split(list, ",") {
+		group, ok := formatGroups[strings.TrimSpace(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown surface format %q", name)
+		}
+		formats = append(formats, group...)
+	}
+
+	return formats, nil
+}
+
+// chooseSwapSurfaceFormat falls back to sRGB when the surface offers none of
+// the preferred formats, and to its first format after that.
+func chooseSwapSurfaceFormat(preferred, formats []vulkan.SurfaceFormat) vulkan.SurfaceFormat {
+	for i := range formats {
+		formats[i].Deref()
+	}
+	for _, want := range append(slices.Clone(preferred), FormatsSRGB...) {
+		for _, format := range formats {
+			if format.Format == want.Format && format.ColorSpace == want.ColorSpace {
+				return format
+			}
+		}
+	}
+
+	return formats[0]
+}
+
+// chooseSwapPresentMode falls back to FIFO, the only mode every surface
+// supports.
+func chooseSwapPresentMode(preferred, modes []vulkan.PresentMode) vulkan.PresentMode {
+	for _, want := range preferred {
+		for _, mode := range modes {
+			if mode == want {
+				return mode
+			}
+		}
+	}
+
+	return vulkan.PresentModeFifo
+}
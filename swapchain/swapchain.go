@@ -4,6 +4,8 @@ import (
 	"errors"
 	"fmt"
 	"game/device"
+	"log/slog"
+	"slices"
 
 	"github.com/goki/vulkan"
 )
@@ -11,6 +13,10 @@ import (
 type SwapchainFactory struct {
 	Swapchain  *Swapchain
 	RenderPass vulkan.RenderPass
+	// Samples is the sample count of the render pass attachments.
+	Samples vulkan.SampleCountFlagBits
+
+	device *device.Device
 
 	syncObjects []syncObject
 
@@ -20,44 +26,98 @@ type SwapchainFactory struct {
 	swapchainProps device.SwapchainProperties
 }
 
-func New(device *device.Device, windowExtent vulkan.Extent2D) *SwapchainFactory {
-	sp := device.SwapchainSupport()
-	surfaceFormat := chooseSwapSurfaceFormat(sp.Formats)
-	presentMode := chooseSwapPresentMode(sp.Presents)
+func New(d *device.Device, windowExtent vulkan.Extent2D, config Config) (*SwapchainFactory, error) {
+	if config.FramesInFlight < 1 {
+		return nil, fmt.Errorf("invalid number of frames in flight %d", config.FramesInFlight)
+	}
 
-	depthFormat := findDepthFormat(device)
-	renderPass := createRenderPass(device, surfaceFormat.Format, depthFormat)
+	sp, err := d.SwapchainSupport()
+	if err != nil {
+		return nil, err
+	}
+	if len(sp.Formats) == 0 {
+		return nil, errors.New("surface reports no formats")
+	}
 
-	syncObjects := createSyncObjects(device)
+	depthFormat, err := FindDepthFormat(d)
+	if err != nil {
+		return nil, err
+	}
 
-	sf := SwapchainFactory{
-		RenderPass:     renderPass,
-		surfaceFormat:  surfaceFormat,
-		presentMode:    presentMode,
+	sf := &SwapchainFactory{
+		device:         d,
+		surfaceFormat:  chooseSwapSurfaceFormat(config.Formats, sp.Formats),
+		presentMode:    chooseSwapPresentMode(config.PresentModes, sp.Presents),
+		Samples:        ChooseSampleCount(d, config.Samples),
 		depthFormat:    depthFormat,
-		syncObjects:    syncObjects,
 		swapchainProps: sp,
 	}
 
-	sf.Swapchain = sf.newSwapchain(device, windowExtent, sf.syncObjects)
+	if sf.RenderPass, err = createRenderPass(d, sf.surfaceFormat.Format, depthFormat, sf.Samples); err != nil {
+		return nil, err
+	}
+	if sf.syncObjects, err = createSyncObjects(d, config.FramesInFlight); err != nil {
+		sf.Close()
+		return nil, err
+	}
+	if sf.Swapchain, err = sf.newSwapchain(d, windowExtent, nil); err != nil {
+		sf.Close()
+		return nil, err
+	}
 
-	return &sf
+	slog.Info("swapchain",
+		slog.String("present mode", presentModeString(sf.presentMode)),
+		slog.String("format", formatString(sf.surfaceFormat)),
+		slog.Int("images", sf.Swapchain.ImageCount),
+		slog.Int("samples", int(sf.Samples)),
+		slog.Int("frames in flight", config.FramesInFlight),
+	)
+
+	return sf, nil
 }
 
+// Format is the format and color space of the swapchain images.
+func (sf *SwapchainFactory) Format() vulkan.SurfaceFormat {
+	return sf.surfaceFormat
+}
+
+// FramesInFlight returns the number of frames that can be recorded while
+// earlier ones are still rendering.
+func (sf *SwapchainFactory) FramesInFlight() int {
+	return len(sf.syncObjects)
+}
+
+// Close destroys the swapchain and everything shared between its
+// generations. It is safe to call on a partially constructed factory.
 func (sf *SwapchainFactory) Close() {
-	sf.Swapchain.Close()
-	vulkan.DestroyRenderPass(sf.Swapchain.device.LogicalDevice, sf.RenderPass, nil)
+	if sf.Swapchain != nil {
+		sf.Swapchain.Close()
+	}
+	vulkan.DestroyRenderPass(sf.device.LogicalDevice, sf.RenderPass, nil)
 	for _, syncObjects := range sf.syncObjects {
-		vulkan.DestroySemaphore(sf.Swapchain.device.LogicalDevice, syncObjects.imageAvailable, nil)
-		vulkan.DestroySemaphore(sf.Swapchain.device.LogicalDevice, syncObjects.renderFinished, nil)
-		vulkan.DestroyFence(sf.Swapchain.device.LogicalDevice, syncObjects.inFlightFence, nil)
+		vulkan.DestroySemaphore(sf.device.LogicalDevice, syncObjects.imageAvailable, nil)
+		vulkan.DestroySemaphore(sf.device.LogicalDevice, syncObjects.renderFinished, nil)
+		vulkan.DestroyFence(sf.device.LogicalDevice, syncObjects.inFlightFence, nil)
 	}
 }
 
-func (sf *SwapchainFactory) UpdateSwapchain(extent vulkan.Extent2D) {
-	oldSwapchain := sf.Swapchain
-	sf.Swapchain = sf.newSwapchain(sf.Swapchain.device, extent, sf.syncObjects)
-	oldSwapchain.Close()
+// UpdateSwapchain recreates the swapchain for a new window extent. On
+// failure the previous swapchain is kept.
+func (sf *SwapchainFactory) UpdateSwapchain(extent vulkan.Extent2D) error {
+	sp, err := sf.device.SwapchainSupport()
+	if err != nil {
+		return err
+	}
+	sf.swapchainProps = sp
+
+	swapchain, err := sf.newSwapchain(sf.device, extent, sf.Swapchain)
+	if err != nil {
+		return err
+	}
+	sf.Swapchain.Close()
+	sf.Swapchain = swapchain
+
+	return nil
 }
 
 type Swapchain struct {
@@ -65,36 +125,20 @@ type Swapchain struct {
 	device         *device.Device
 	views          []vulkan.ImageView
 	swapchain      vulkan.Swapchain
-	depthResources []depthImage
+	colorResources []attachment
+	depthResources []attachment
 	imagesInFlight []vulkan.Fence
 
 	Extent       vulkan.Extent2D
 	FrameBuffers []vulkan.Framebuffer
+	Images       []vulkan.Image
+	// Copyable is set when the images can be copied from, which surfaces
+	// do not have to support.
+	Copyable bool
 
 	ImageCount int
 }
 
-func chooseSwapSurfaceFormat(formats []vulkan.SurfaceFormat) vulkan.SurfaceFormat {
-	for i := range formats {
-		formats[i].Deref()
-		if formats[i].Format == vulkan.FormatB8g8r8Srgb && formats[i].ColorSpace == vulkan.ColorSpaceSrgbNonlinear {
-			return formats[i]
-		}
-	}
-
-	return formats[0]
-}
-
-func chooseSwapPresentMode(modes []vulkan.PresentMode) vulkan.PresentMode {
-	for _, mode := range modes {
-		if mode == vulkan.PresentModeMailbox {
-			return mode
-		}
-	}
-
-	return vulkan.PresentModeFifo
-}
-
 const MaxUint32 = ^uint32(0)
 const MaxUint64 = ^uint64(0)
 
@@ -110,61 +154,70 @@ func chooseSwapExtent(windowExtent vulkan.Extent2D, caps vulkan.SurfaceCapabilit
 }
 
 func (swapchain *Swapchain) createSwapchain(
-	device *device.Device,
+	d *device.Device,
 	sp device.SwapchainProperties,
 	surfaceFormat vulkan.SurfaceFormat,
 	extent vulkan.Extent2D,
 	presentMode vulkan.PresentMode,
-) []vulkan.Image {
+	old *Swapchain,
+) ([]vulkan.Image, error) {
 	imageCount := sp.Caps.MinImageCount + 1
 	if sp.Caps.MaxImageCount > 0 && imageCount > sp.Caps.MaxImageCount {
 		imageCount = sp.Caps.MaxImageCount
 	}
 
 	var oldSwapchain vulkan.Swapchain
-	if swapchain != nil {
-		oldSwapchain = swapchain.swapchain
+	if old != nil {
+		oldSwapchain = old.swapchain
+	}
+
+	usage := vulkan.ImageUsageFlags(vulkan.ImageUsageColorAttachmentBit)
+	if sp.Caps.SupportedUsageFlags&vulkan.ImageUsageFlags(vulkan.ImageUsageTransferSrcBit) != 0 {
+		usage |= vulkan.ImageUsageFlags(vulkan.ImageUsageTransferSrcBit)
+		swapchain.Copyable = true
 	}
 
 	var newSwapchain vulkan.Swapchain
-	if err := vulkan.Error(vulkan.CreateSwapchain(device.LogicalDevice, &vulkan.SwapchainCreateInfo{
+	if err := device.Check(vulkan.CreateSwapchain(d.LogicalDevice, &vulkan.SwapchainCreateInfo{
 		SType:            vulkan.StructureTypeSwapchainCreateInfo,
-		Surface:          device.Surface,
+		Surface:          d.Surface,
 		OldSwapchain:     oldSwapchain,
 		MinImageCount:    imageCount,
 		ImageFormat:      surfaceFormat.Format,
 		ImageColorSpace:  surfaceFormat.ColorSpace,
 		ImageExtent:      extent,
 		ImageArrayLayers: 1,
-		ImageUsage:       vulkan.ImageUsageFlags(vulkan.ImageUsageColorAttachmentBit),
+		ImageUsage:       usage,
 		ImageSharingMode: vulkan.SharingModeExclusive,
 		PreTransform:     sp.Caps.CurrentTransform,
 		CompositeAlpha:   vulkan.CompositeAlphaOpaqueBit,
 		PresentMode:      presentMode,
 		Clipped:          vulkan.True,
-	}, nil, &newSwapchain)); err != nil {
-		panic("failed creating swapchain: " + err.Error())
+	}, nil, &newSwapchain), "create swapchain"); err != nil {
+		return nil, err
 	}
 	swapchain.swapchain = newSwapchain
 
 	var imagesCount uint32
-	if err := vulkan.Error(vulkan.GetSwapchainImages(device.LogicalDevice, swapchain.swapchain, &imagesCount, nil)); err != nil {
-		panic("failed to get images count: " + err.Error())
+	if err := device.Check(vulkan.GetSwapchainImages(d.LogicalDevice, swapchain.swapchain, &imagesCount, nil), "get swapchain images count"); err != nil {
+		return nil, err
 	}
-	swapchain.ImageCount = int(imageCount)
+	swapchain.ImageCount = int(imagesCount)
 
 	images := make([]vulkan.Image, imagesCount)
-	if err := vulkan.Error(vulkan.GetSwapchainImages(device.LogicalDevice, swapchain.swapchain, &imagesCount, images)); err != nil {
-		panic("failed to get swapchain images: " + err.Error())
+	if err := device.Check(vulkan.GetSwapchainImages(d.LogicalDevice, swapchain.swapchain, &imagesCount, images), "get swapchain images"); err != nil {
+		return nil, err
 	}
 
-	return images
+	return images, nil
 }
 
-func createImageViews(device *device.Device, images []vulkan.Image, format vulkan.Format) []vulkan.ImageView {
+// createImageViews returns the views created so far along with an error, so
+// the caller can release them.
+func createImageViews(d *device.Device, images []vulkan.Image, format vulkan.Format) ([]vulkan.ImageView, error) {
 	views := make([]vulkan.ImageView, len(images))
 	for i, image := range images {
-		if err := vulkan.Error(vulkan.CreateImageView(device.LogicalDevice, &vulkan.ImageViewCreateInfo{
+		if err := device.Check(vulkan.CreateImageView(d.LogicalDevice, &vulkan.ImageViewCreateInfo{
 			SType:    vulkan.StructureTypeImageViewCreateInfo,
 			Image:    image,
 			ViewType: vulkan.ImageViewType2d,
@@ -176,66 +229,85 @@ func createImageViews(device *device.Device, images []vulkan.Image, format vulka
 				BaseArrayLayer: 0,
 				LayerCount:     1,
 			},
-		}, nil, &views[i])); err != nil {
-			panic(fmt.Sprintf("failed to create image view (%d): %s", i, err))
+		}, nil, &views[i]), fmt.Sprintf("create image view (%d)", i)); err != nil {
+			return views, err
 		}
 	}
 
-	return views
+	return views, nil
 }
 
-func findDepthFormat(device *device.Device) vulkan.Format {
-	return device.FindSupportedFormat([]vulkan.Format{
+func FindDepthFormat(d *device.Device) (vulkan.Format, error) {
+	return d.FindSupportedFormat([]vulkan.Format{
 		vulkan.FormatD32Sfloat,
 		vulkan.FormatD32SfloatS8Uint,
 		vulkan.FormatD24UnormS8Uint,
 	}, vulkan.ImageTilingOptimal, vulkan.FormatFeatureFlags(vulkan.FormatFeatureDepthStencilAttachmentBit))
 }
 
-func createRenderPass(device *device.Device, surfaceFormat vulkan.Format, depthFormat vulkan.Format) vulkan.RenderPass {
-	var renderPass vulkan.RenderPass
-	if err := vulkan.Error(vulkan.CreateRenderPass(device.LogicalDevice, &vulkan.RenderPassCreateInfo{
-		SType:           vulkan.StructureTypeRenderPassCreateInfo,
-		AttachmentCount: 2,
-		PAttachments: []vulkan.AttachmentDescription{
-			{
-				Format:         surfaceFormat,
-				Samples:        vulkan.SampleCount1Bit,
-				LoadOp:         vulkan.AttachmentLoadOpClear,
-				StoreOp:        vulkan.AttachmentStoreOpStore,
-				StencilLoadOp:  vulkan.AttachmentLoadOpDontCare,
-				StencilStoreOp: vulkan.AttachmentStoreOpDontCare,
-				InitialLayout:  vulkan.ImageLayoutUndefined,
-				FinalLayout:    vulkan.ImageLayoutPresentSrc,
-			},
+func createRenderPass(d *device.Device, surfaceFormat, depthFormat vulkan.Format, samples vulkan.SampleCountFlagBits) (vulkan.RenderPass, error) {
+	attachments := []vulkan.AttachmentDescription{
+		{
+			Format:         surfaceFormat,
+			Samples:        vulkan.SampleCount1Bit,
+			LoadOp:         vulkan.AttachmentLoadOpClear,
+			StoreOp:        vulkan.AttachmentStoreOpStore,
+			StencilLoadOp:  vulkan.AttachmentLoadOpDontCare,
+			StencilStoreOp: vulkan.AttachmentStoreOpDontCare,
+			InitialLayout:  vulkan.ImageLayoutUndefined,
+			FinalLayout:    vulkan.ImageLayoutPresentSrc,
+		},
+		{
+			Format:         depthFormat,
+			Samples:        samples,
+			LoadOp:         vulkan.AttachmentLoadOpClear,
+			StoreOp:        vulkan.AttachmentStoreOpDontCare,
+			StencilLoadOp:  vulkan.AttachmentLoadOpDontCare,
+			StencilStoreOp: vulkan.AttachmentStoreOpDontCare,
+			InitialLayout:  vulkan.ImageLayoutUndefined,
+			FinalLayout:    vulkan.ImageLayoutDepthStencilAttachmentOptimal,
+		},
+	}
+	subpass := vulkan.SubpassDescription{
+		PipelineBindPoint:    vulkan.PipelineBindPointGraphics,
+		ColorAttachmentCount: 1,
+		PColorAttachments: []vulkan.AttachmentReference{
 			{
-				Format:         depthFormat,
-				Samples:        vulkan.SampleCount1Bit,
-				LoadOp:         vulkan.AttachmentLoadOpClear,
-				StoreOp:        vulkan.AttachmentStoreOpDontCare,
-				StencilLoadOp:  vulkan.AttachmentLoadOpDontCare,
-				StencilStoreOp: vulkan.AttachmentStoreOpDontCare,
-				InitialLayout:  vulkan.ImageLayoutUndefined,
-				FinalLayout:    vulkan.ImageLayoutDepthStencilAttachmentOptimal,
+				Attachment: 0,
+				Layout:     vulkan.ImageLayoutColorAttachmentOptimal,
 			},
 		},
-		SubpassCount: 1,
-		PSubpasses: []vulkan.SubpassDescription{
+		PDepthStencilAttachment: &vulkan.AttachmentReference{
+			Attachment: 1,
+			Layout:     vulkan.ImageLayoutDepthStencilAttachmentOptimal,
+		},
+	}
+
+	// multisampled rendering goes to attachment 0 and is resolved into the
+	// swapchain image, attachment 2
+	if samples != vulkan.SampleCount1Bit {
+		resolve := attachments[0]
+		resolve.LoadOp = vulkan.AttachmentLoadOpDontCare
+		attachments[0].Samples = samples
+		attachments[0].StoreOp = vulkan.AttachmentStoreOpDontCare
+		attachments[0].FinalLayout = vulkan.ImageLayoutColorAttachmentOptimal
+		attachments = append(attachments, resolve)
+
+		subpass.PResolveAttachments = []vulkan.AttachmentReference{
 			{
-				PipelineBindPoint:    vulkan.PipelineBindPointGraphics,
-				ColorAttachmentCount: 1,
-				PColorAttachments: []vulkan.AttachmentReference{
-					{
-						Attachment: 0,
-						Layout:     vulkan.ImageLayoutColorAttachmentOptimal,
-					},
-				},
-				PDepthStencilAttachment: &vulkan.AttachmentReference{
-					Attachment: 1,
-					Layout:     vulkan.ImageLayoutDepthStencilAttachmentOptimal,
-				},
+				Attachment: 2,
+				Layout:     vulkan.ImageLayoutColorAttachmentOptimal,
 			},
-		},
+		}
+	}
+
+	var renderPass vulkan.RenderPass
+	err := device.Check(vulkan.CreateRenderPass(d.LogicalDevice, &vulkan.RenderPassCreateInfo{
+		SType:           vulkan.StructureTypeRenderPassCreateInfo,
+		AttachmentCount: uint32(len(attachments)),
+		PAttachments:    attachments,
+		SubpassCount:    1,
+		PSubpasses:      []vulkan.SubpassDescription{subpass},
 		DependencyCount: 1,
 		PDependencies: []vulkan.SubpassDependency{
 			{
@@ -247,28 +319,45 @@ func createRenderPass(device *device.Device, surfaceFormat vulkan.Format, depthF
 				DstAccessMask: vulkan.AccessFlags(vulkan.AccessColorAttachmentWriteBit | vulkan.AccessDepthStencilAttachmentWriteBit),
 			},
 		},
-	}, nil, &renderPass)); err != nil {
-		panic("failed to create render pass: " + err.Error())
+	}, nil, &renderPass), "create render pass")
+
+	return renderPass, err
+}
+
+// ChooseSampleCount returns the highest sample count up to requested that
+// color and depth attachments both support.
+func ChooseSampleCount(d *device.Device, requested int) vulkan.SampleCountFlagBits {
+	limits := d.Limits()
+	supported := limits.FramebufferColorSampleCounts & limits.FramebufferDepthSampleCounts
+	for samples := vulkan.SampleCount64Bit; samples > vulkan.SampleCount1Bit; samples >>= 1 {
+		if int(samples) <= requested && supported&vulkan.SampleCountFlags(samples) != 0 {
+			return samples
+		}
 	}
 
-	return renderPass
+	return vulkan.SampleCount1Bit
 }
 
-type depthImage struct {
+// attachment is an image the swapchain renders into besides its own images.
+type attachment struct {
 	image  vulkan.Image
-	memory vulkan.DeviceMemory
+	memory *device.Allocation
 	view   vulkan.ImageView
 }
 
-func createDepthResources(
-	device *device.Device,
-	depthFormat vulkan.Format,
+func createAttachments(
+	d *device.Device,
+	format vulkan.Format,
+	usage vulkan.ImageUsageFlagBits,
+	aspect vulkan.ImageAspectFlagBits,
+	samples vulkan.SampleCountFlagBits,
 	extent vulkan.Extent2D,
-	images []vulkan.Image,
-) []depthImage {
-	depthImages := make([]depthImage, len(images))
-	for i := range depthImages {
-		depthImages[i].image, depthImages[i].memory = device.CreateImageWithInfo(vulkan.ImageCreateInfo{
+	count int,
+) ([]attachment, error) {
+	attachments := make([]attachment, count)
+	for i := range attachments {
+		var err error
+		attachments[i].image, attachments[i].memory, err = d.CreateImageWithInfo(vulkan.ImageCreateInfo{
 			SType:     vulkan.StructureTypeImageCreateInfo,
 			ImageType: vulkan.ImageType2d,
 			Extent: vulkan.Extent3D{
@@ -278,65 +367,68 @@ func createDepthResources(
 			},
 			MipLevels:     1,
 			ArrayLayers:   1,
-			Format:        depthFormat,
+			Format:        format,
 			Tiling:        vulkan.ImageTilingOptimal,
 			InitialLayout: vulkan.ImageLayoutUndefined,
-			Usage:         vulkan.ImageUsageFlags(vulkan.ImageUsageDepthStencilAttachmentBit),
-			Samples:       vulkan.SampleCount1Bit,
+			Usage:         vulkan.ImageUsageFlags(usage),
+			Samples:       samples,
 			SharingMode:   vulkan.SharingModeExclusive,
 		}, vulkan.MemoryPropertyFlags(vulkan.MemoryPropertyDeviceLocalBit))
+		if err != nil {
+			return attachments, err
+		}
 
-		if err := vulkan.Error(vulkan.CreateImageView(device.LogicalDevice, &vulkan.ImageViewCreateInfo{
+		if err := device.Check(vulkan.CreateImageView(d.LogicalDevice, &vulkan.ImageViewCreateInfo{
 			SType:    vulkan.StructureTypeImageViewCreateInfo,
-			Image:    depthImages[i].image,
+			Image:    attachments[i].image,
 			ViewType: vulkan.ImageViewType2d,
-			Format:   depthFormat,
+			Format:   format,
 			SubresourceRange: vulkan.ImageSubresourceRange{
-				AspectMask:     vulkan.ImageAspectFlags(vulkan.ImageAspectDepthBit),
+				AspectMask:     vulkan.ImageAspectFlags(aspect),
 				BaseMipLevel:   0,
 				LevelCount:     1,
 				BaseArrayLayer: 0,
 				LayerCount:     1,
 			},
-		}, nil, &depthImages[i].view)); err != nil {
-			panic("failed to create depth image view: " + err.Error())
+		}, nil, &attachments[i].view), "create attachment image view"); err != nil {
+			return attachments, err
 		}
 	}
 
-	return depthImages
+	return attachments, nil
 }
 
 func createFrameBuffers(
-	device *device.Device,
-	images []vulkan.Image,
+	d *device.Device,
 	imageViews []vulkan.ImageView,
-	depthImages []depthImage,
+	colorImages []attachment,
+	depthImages []attachment,
 	extent vulkan.Extent2D,
 	renderPass vulkan.RenderPass,
-) []vulkan.Framebuffer {
-	framebuffers := make([]vulkan.Framebuffer, len(images))
+) ([]vulkan.Framebuffer, error) {
+	framebuffers := make([]vulkan.Framebuffer, len(imageViews))
 	for i := range framebuffers {
-		if err := vulkan.Error(vulkan.CreateFramebuffer(device.LogicalDevice, &vulkan.FramebufferCreateInfo{
+		attachments := []vulkan.ImageView{imageViews[i], depthImages[i].view}
+		if colorImages != nil {
+			attachments = []vulkan.ImageView{colorImages[i].view, depthImages[i].view, imageViews[i]}
+		}
+
+		if err := device.Check(vulkan.CreateFramebuffer(d.LogicalDevice, &vulkan.FramebufferCreateInfo{
 			SType:           vulkan.StructureTypeFramebufferCreateInfo,
 			RenderPass:      renderPass,
-			AttachmentCount: 2,
-			PAttachments: []vulkan.ImageView{
-				imageViews[i],
-				depthImages[i].view,
-			},
-			Width:  extent.Width,
-			Height: extent.Height,
-			Layers: 1,
-		}, nil, &framebuffers[i])); err != nil {
-			panic("failed to create framebuffer: " + err.Error())
+			AttachmentCount: uint32(len(attachments)),
+			PAttachments:    attachments,
+			Width:           extent.Width,
+			Height:          extent.Height,
+			Layers:          1,
+		}, nil, &framebuffers[i]), "create framebuffer"); err != nil {
+			return framebuffers, err
 		}
 	}
 
-	return framebuffers
+	return framebuffers, nil
 }
 
-const MAX_FRAMES_IN_FLIGHT = 2
-
 type syncObject struct {
 	imageAvailable vulkan.Semaphore
 	renderFinished vulkan.Semaphore
@@ -344,64 +436,91 @@ type syncObject struct {
 }
 
 func createSyncObjects(
-	device *device.Device,
-) []syncObject {
-	syncObjects := make([]syncObject, MAX_FRAMES_IN_FLIGHT)
+	d *device.Device,
+	framesInFlight int,
+) ([]syncObject, error) {
+	syncObjects := make([]syncObject, framesInFlight)
 	for i := range syncObjects {
-		if err := vulkan.Error(vulkan.CreateSemaphore(device.LogicalDevice, &vulkan.SemaphoreCreateInfo{
+		if err := device.Check(vulkan.CreateSemaphore(d.LogicalDevice, &vulkan.SemaphoreCreateInfo{
 			SType: vulkan.StructureTypeSemaphoreCreateInfo,
-		}, nil, &syncObjects[i].imageAvailable)); err != nil {
-			panic("failed to create image semaphore: " + err.Error())
+		}, nil, &syncObjects[i].imageAvailable), "create image semaphore"); err != nil {
+			return syncObjects, err
 		}
 
-		if err := vulkan.Error(vulkan.CreateSemaphore(device.LogicalDevice, &vulkan.SemaphoreCreateInfo{
+		if err := device.Check(vulkan.CreateSemaphore(d.LogicalDevice, &vulkan.SemaphoreCreateInfo{
 			SType: vulkan.StructureTypeSemaphoreCreateInfo,
-		}, nil, &syncObjects[i].renderFinished)); err != nil {
-			panic("failed to create render semaphore: " + err.Error())
+		}, nil, &syncObjects[i].renderFinished), "create render semaphore"); err != nil {
+			return syncObjects, err
 		}
+		d.NameSemaphore(syncObjects[i].imageAvailable, fmt.Sprintf("imageAvailable[frame %d]", i))
+		d.NameSemaphore(syncObjects[i].renderFinished, fmt.Sprintf("renderFinished[frame %d]", i))
 
-		if err := vulkan.Error(vulkan.CreateFence(device.LogicalDevice, &vulkan.FenceCreateInfo{
+		if err := device.Check(vulkan.CreateFence(d.LogicalDevice, &vulkan.FenceCreateInfo{
 			SType: vulkan.StructureTypeFenceCreateInfo,
 			Flags: vulkan.FenceCreateFlags(vulkan.FenceCreateSignaledBit),
-		}, nil, &syncObjects[i].inFlightFence)); err != nil {
-			panic("failed to create inflight fence: " + err.Error())
+		}, nil, &syncObjects[i].inFlightFence), "create inflight fence"); err != nil {
+			return syncObjects, err
 		}
 	}
 
-	return syncObjects
+	return syncObjects, nil
 }
 
 func (sf *SwapchainFactory) newSwapchain(
-	device *device.Device,
+	d *device.Device,
 	windowExtent vulkan.Extent2D,
-	syncObjects []syncObject,
-) *Swapchain {
+	old *Swapchain,
+) (*Swapchain, error) {
 	extent := chooseSwapExtent(windowExtent, sf.swapchainProps.Caps)
 	swapchain := &Swapchain{
-		device:      device,
+		device:      d,
 		Extent:      extent,
-		syncObjects: syncObjects,
+		syncObjects: sf.syncObjects,
 	}
 
-	images := swapchain.createSwapchain(device, sf.swapchainProps, sf.surfaceFormat, extent, sf.presentMode)
-	views := createImageViews(device, images, sf.surfaceFormat.Format)
-	depthResources := createDepthResources(device, sf.depthFormat, extent, images)
-	frameBuffers := createFrameBuffers(device, images, views, depthResources, extent, sf.RenderPass)
-
-	swapchain.views = views
-	swapchain.depthResources = depthResources
-	swapchain.FrameBuffers = frameBuffers
+	images, err := swapchain.createSwapchain(d, sf.swapchainProps, sf.surfaceFormat, extent, sf.presentMode, old)
+	if err != nil {
+		swapchain.Close()
+		return nil, err
+	}
+	swapchain.Images = images
+	if swapchain.views, err = createImageViews(d, images, sf.surfaceFormat.Format); err != nil {
+		swapchain.Close()
+		return nil, err
+	}
+	if sf.Samples != vulkan.SampleCount1Bit {
+		if swapchain.colorResources, err = createAttachments(
+			d, sf.surfaceFormat.Format,
+			vulkan.ImageUsageColorAttachmentBit|vulkan.ImageUsageTransientAttachmentBit, vulkan.ImageAspectColorBit,
+			sf.Samples, extent, len(images),
+		); err != nil {
+			swapchain.Close()
+			return nil, err
+		}
+	}
+	if swapchain.depthResources, err = createAttachments(
+		d, sf.depthFormat,
+		vulkan.ImageUsageDepthStencilAttachmentBit, vulkan.ImageAspectDepthBit,
+		sf.Samples, extent, len(images),
+	); err != nil {
+		swapchain.Close()
+		return nil, err
+	}
+	if swapchain.FrameBuffers, err = createFrameBuffers(d, swapchain.views, swapchain.colorResources, swapchain.depthResources, extent, sf.RenderPass); err != nil {
+		swapchain.Close()
+		return nil, err
+	}
 	swapchain.imagesInFlight = make([]vulkan.Fence, len(images))
 
-	return swapchain
+	return swapchain, nil
 }
 
 var ErrOutOfDate = errors.New("error out of date")
 
 func (s *Swapchain) NextImage(currentFrame int) (int, error) {
-	vulkan.WaitForFences(s.device.LogicalDevice, 1, []vulkan.Fence{
-		s.syncObjects[currentFrame].inFlightFence,
-	}, vulkan.True, MaxUint64)
+	if err := s.WaitFrame(currentFrame); err != nil {
+		return 0, err
+	}
 
 	var imageIndex uint32
 
@@ -409,31 +528,42 @@ func (s *Swapchain) NextImage(currentFrame int) (int, error) {
 	if result == vulkan.ErrorOutOfDate {
 		return 0, ErrOutOfDate
 	}
-	if err := vulkan.Error(result); err != nil && result != vulkan.Suboptimal {
-		panic("failed to get new image: " + err.Error())
+	if result != vulkan.Suboptimal {
+		if err := device.Check(result, "get new image"); err != nil {
+			return 0, err
+		}
 	}
 
 	return int(imageIndex), nil
 }
 
+// WaitFrame blocks until the last submission of currentFrame has finished.
+func (s *Swapchain) WaitFrame(currentFrame int) error {
+	return device.Check(vulkan.WaitForFences(s.device.LogicalDevice, 1, []vulkan.Fence{
+		s.syncObjects[currentFrame].inFlightFence,
+	}, vulkan.True, MaxUint64), "wait for frame fence")
+}
+
 func (s *Swapchain) SubmitCommandBuffer(
 	buffers vulkan.CommandBuffer,
 	currentFrame uint32,
 	imageIndex uint32,
 	computeSemaphore vulkan.Semaphore,
-) {
+) error {
 	if s.imagesInFlight[imageIndex] != vulkan.Fence(vulkan.NullHandle) {
-		vulkan.WaitForFences(s.device.LogicalDevice, 1, []vulkan.Fence{
+		if err := device.Check(vulkan.WaitForFences(s.device.LogicalDevice, 1, []vulkan.Fence{
 			s.imagesInFlight[imageIndex],
-		}, vulkan.True, MaxUint64)
+		}, vulkan.True, MaxUint64), "wait for image fence"); err != nil {
+			return err
+		}
 	}
 	s.imagesInFlight[imageIndex] = s.syncObjects[currentFrame].inFlightFence
 
-	if err := vulkan.Error(vulkan.ResetFences(s.device.LogicalDevice, 1, []vulkan.Fence{s.syncObjects[currentFrame].inFlightFence})); err != nil {
-		panic("failed to reset render fence: " + err.Error())
+	if err := device.Check(vulkan.ResetFences(s.device.LogicalDevice, 1, []vulkan.Fence{s.syncObjects[currentFrame].inFlightFence}), "reset render fence"); err != nil {
+		return err
 	}
 
-	if err := vulkan.Error(vulkan.QueueSubmit(s.device.Queue, 1, []vulkan.SubmitInfo{
+	if err := device.Check(vulkan.QueueSubmit(s.device.Queue, 1, []vulkan.SubmitInfo{
 		{
 			SType:              vulkan.StructureTypeSubmitInfo,
 			WaitSemaphoreCount: 2,
@@ -443,7 +573,7 @@ func (s *Swapchain) SubmitCommandBuffer(
 			},
 			PWaitDstStageMask: []vulkan.PipelineStageFlags{
 				vulkan.PipelineStageFlags(vulkan.PipelineStageColorAttachmentOutputBit),
-				vulkan.PipelineStageFlags(vulkan.PipelineStageComputeShaderBit),
+				vulkan.PipelineStageFlags(vulkan.PipelineStageDrawIndirectBit | vulkan.PipelineStageVertexShaderBit),
 			},
 			CommandBufferCount:   1,
 			PCommandBuffers:      []vulkan.CommandBuffer{buffers},
@@ -452,8 +582,8 @@ func (s *Swapchain) SubmitCommandBuffer(
 				s.syncObjects[currentFrame].renderFinished,
 			},
 		},
-	}, s.syncObjects[currentFrame].inFlightFence)); err != nil {
-		panic("failed to submit command buffer to queue: " + err.Error())
+	}, s.syncObjects[currentFrame].inFlightFence), "submit command buffer to queue"); err != nil {
+		return err
 	}
 
 	result := vulkan.QueuePresent(s.device.Queue, &vulkan.PresentInfo{
@@ -470,14 +600,10 @@ func (s *Swapchain) SubmitCommandBuffer(
 	})
 
 	if result == vulkan.ErrorOutOfDate || result == vulkan.Suboptimal {
-		return
-	}
-
-	if err := vulkan.Error(result); err != nil {
-		panic("failed to submit command buffer to queue: " + err.Error())
+		return nil
 	}
 
-	// s.currentFrame = (s.currentFrame + 1) % MAX_FRAMES_IN_FLIGHT
+	return device.Check(result, "present image")
 }
 
 func (s *Swapchain) Close() {
@@ -485,10 +611,10 @@ func (s *Swapchain) Close() {
 		vulkan.DestroyImageView(s.device.LogicalDevice, image, nil)
 	}
 	vulkan.DestroySwapchain(s.device.LogicalDevice, s.swapchain, nil)
-	for _, depthResource := range s.depthResources {
-		vulkan.DestroyImageView(s.device.LogicalDevice, depthResource.view, nil)
-		vulkan.DestroyImage(s.device.LogicalDevice, depthResource.image, nil)
-		vulkan.FreeMemory(s.device.LogicalDevice, depthResource.memory, nil)
+	for _, attachment := range slices.Concat(s.colorResources, s.depthResources) {
+		vulkan.DestroyImageView(s.device.LogicalDevice, attachment.view, nil)
+		vulkan.DestroyImage(s.device.LogicalDevice, attachment.image, nil)
+		s.device.Free(attachment.memory)
 	}
 
 	for _, framebuffer := range s.FrameBuffers {
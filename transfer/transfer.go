@@ -0,0 +1,287 @@
+package transfer
+
+import (
+	"game/device"
+	"unsafe"
+
+	"github.com/goki/vulkan"
+)
+
+// DefaultRingSize is the size of the staging ring used by the app.
+const DefaultRingSize = 8 << 20
+
+// ringAlignment keeps every staged region suitably aligned for any element
+// type and for buffer copy offsets.
+const ringAlignment = 16
+
+type bufferCopy struct {
+	dst    vulkan.Buffer
+	region vulkan.BufferCopy
+}
+
+// batch is a submitted command buffer and the ring position it retires.
+type batch struct {
+	commandBuffer vulkan.CommandBuffer
+	fence         vulkan.Fence
+	written       vulkan.DeviceSize
+}
+
+// Manager stages uploads in a persistently mapped ring buffer and copies
+// them to their destinations in batches on the transfer queue. Space in the
+// ring is reclaimed once the fence of the batch that used it signals, so
+// uploads only block when the ring is full.
+type Manager struct {
+	device     *device.Device
+	ring       vulkan.Buffer
+	ringMemory *device.Allocation
+	data       unsafe.Pointer
+	size       vulkan.DeviceSize
+
+	// written and retired count bytes ever staged and ever released, their
+	// difference is the part of the ring in use
+	written vulkan.DeviceSize
+	retired vulkan.DeviceSize
+
+	pending  []bufferCopy
+	inFlight []batch
+	// retired batches kept for reuse
+	spare []batch
+}
+
+func New(d *device.Device, size vulkan.DeviceSize) (*Manager, error) {
+	size = alignUp(size)
+	ring, memory, err := d.CreateBuffer(
+		size,
+		vulkan.BufferUsageFlags(vulkan.BufferUsageTransferSrcBit),
+		vulkan.MemoryPropertyFlags(vulkan.MemoryPropertyHostVisibleBit|vulkan.MemoryPropertyHostCoherentBit),
+	)
+	if err != nil {
+		return nil, err
+	}
+	d.NameBuffer(ring, "staging ring")
+
+	m := &Manager{
+		device:     d,
+		ring:       ring,
+		ringMemory: memory,
+		size:       size,
+	}
+	if m.data, err = d.Map(memory); err != nil {
+		m.Close()
+		return nil, err
+	}
+
+	return m, nil
+}
+
+func alignUp(size vulkan.DeviceSize) vulkan.DeviceSize {
+	return (size + ringAlignment - 1) / ringAlignment * ringAlignment
+}
+
+// Upload stages data and queues a copy of it to the start of every dst
+// buffer. The copies are submitted by Flush or once the ring fills up.
+// Nothing is staged when there is no data or no dst.
+func Upload[T any](m *Manager, data []T, dst ...vulkan.Buffer) error {
+	// staging without a copy would hold ring space no batch ever retires
+	if len(data) == 0 || len(dst) == 0 {
+		return nil
+	}
+
+	bytes := unsafe.Slice((*byte)(unsafe.Pointer(unsafe.SliceData(data))), len(data)*int(unsafe.Sizeof(data[0])))
+	return m.upload(bytes, dst)
+}
+
+func (m *Manager) upload(bytes []byte, dst []vulkan.Buffer) error {
+	// uploads larger than the ring are split into chunks
+	chunk := int(m.size)
+	for offset := 0; offset < len(bytes); offset += chunk {
+		part := bytes[offset:min(offset+chunk, len(bytes))]
+		staged, err := m.stage(vulkan.DeviceSize(len(part)))
+		if err != nil {
+			return err
+		}
+		copy(unsafe.Slice((*byte)(unsafe.Add(m.data, staged)), len(part)), part)
+
+		for _, buffer := range dst {
+			m.pending = append(m.pending, bufferCopy{
+				dst: buffer,
+				region: vulkan.BufferCopy{
+					SrcOffset: staged,
+					DstOffset: vulkan.DeviceSize(offset),
+					Size:      vulkan.DeviceSize(len(part)),
+				},
+			})
+		}
+	}
+
+	return nil
+}
+
+// stage reserves size bytes of the ring and returns their offset, waiting
+// for earlier batches to finish when there is not enough room.
+func (m *Manager) stage(size vulkan.DeviceSize) (vulkan.DeviceSize, error) {
+	for {
+		position := m.written % m.size
+		reserved := alignUp(size)
+		// regions never wrap, the tail of the ring is skipped instead
+		if position+reserved > m.size {
+			reserved += m.size - position
+			position = 0
+		}
+		if m.size-(m.written-m.retired) >= reserved {
+			m.written += reserved
+			return position, nil
+		}
+
+		if m.written == m.retired {
+			// nothing is in use, start over at the beginning of the ring
+			m.written += m.size - position
+			m.retired = m.written
+			continue
+		}
+		if len(m.inFlight) == 0 {
+			if err := m.Flush(); err != nil {
+				return 0, err
+			}
+		}
+		if err := m.retire(true); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// Flush submits all queued copies as one batch without waiting for it.
+func (m *Manager) Flush() error {
+	if len(m.pending) == 0 {
+		return nil
+	}
+	if err := m.retire(false); err != nil {
+		return err
+	}
+
+	b, err := m.newBatch()
+	if err != nil {
+		return err
+	}
+
+	if err := device.Check(vulkan.BeginCommandBuffer(b.commandBuffer, &vulkan.CommandBufferBeginInfo{
+		SType: vulkan.StructureTypeCommandBufferBeginInfo,
+		Flags: vulkan.CommandBufferUsageFlags(vulkan.CommandBufferUsageOneTimeSubmitBit),
+	}), "begin recording command buffer"); err != nil {
+		m.spare = append(m.spare, b)
+		return err
+	}
+	for _, c := range m.pending {
+		vulkan.CmdCopyBuffer(b.commandBuffer, m.ring, c.dst, 1, []vulkan.BufferCopy{c.region})
+	}
+	if err := device.Check(vulkan.EndCommandBuffer(b.commandBuffer), "end command buffer"); err != nil {
+		m.spare = append(m.spare, b)
+		return err
+	}
+
+	if err := device.Check(vulkan.QueueSubmit(m.device.TransferQueue, 1, []vulkan.SubmitInfo{
+		{
+			SType:              vulkan.StructureTypeSubmitInfo,
+			CommandBufferCount: 1,
+			PCommandBuffers:    []vulkan.CommandBuffer{b.commandBuffer},
+		},
+	}, b.fence), "submit transfer command buffer"); err != nil {
+		m.spare = append(m.spare, b)
+		return err
+	}
+
+	b.written = m.written
+	m.inFlight = append(m.inFlight, b)
+	m.pending = m.pending[:0]
+
+	return nil
+}
+
+// Wait submits the queued copies and blocks until every batch is done, after
+// which the destination buffers may be used from any queue.
+func (m *Manager) Wait() error {
+	if err := m.Flush(); err != nil {
+		return err
+	}
+	for len(m.inFlight) > 0 {
+		if err := m.retire(true); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *Manager) newBatch() (batch, error) {
+	if n := len(m.spare); n > 0 {
+		b := m.spare[n-1]
+		m.spare = m.spare[:n-1]
+		return b, nil
+	}
+
+	b := batch{
+		commandBuffer: vulkan.CommandBuffer(vulkan.NullHandle),
+		fence:         vulkan.NullFence,
+	}
+	commandBuffers := make([]vulkan.CommandBuffer, 1)
+	if err := device.Check(vulkan.AllocateCommandBuffers(m.device.LogicalDevice, &vulkan.CommandBufferAllocateInfo{
+		SType:              vulkan.StructureTypeCommandBufferAllocateInfo,
+		Level:              vulkan.CommandBufferLevelPrimary,
+		CommandPool:        m.device.TransferPool,
+		CommandBufferCount: 1,
+	}, commandBuffers), "allocate command buffers"); err != nil {
+		return b, err
+	}
+	b.commandBuffer = commandBuffers[0]
+
+	if err := device.Check(vulkan.CreateFence(m.device.LogicalDevice, &vulkan.FenceCreateInfo{
+		SType: vulkan.StructureTypeFenceCreateInfo,
+	}, nil, &b.fence), "create transfer fence"); err != nil {
+		vulkan.FreeCommandBuffers(m.device.LogicalDevice, m.device.TransferPool, 1, commandBuffers)
+		return b, err
+	}
+
+	return b, nil
+}
+
+// retire releases the ring space of finished batches in submission order.
+// With block set it waits for at least the oldest batch.
+func (m *Manager) retire(block bool) error {
+	for len(m.inFlight) > 0 {
+		b := m.inFlight[0]
+		if block {
+			if err := device.Check(vulkan.WaitForFences(m.device.LogicalDevice, 1, []vulkan.Fence{b.fence}, vulkan.True, vulkan.MaxUint64), "wait for transfer fence"); err != nil {
+				return err
+			}
+			block = false
+		} else if vulkan.GetFenceStatus(m.device.LogicalDevice, b.fence) != vulkan.Success {
+			return nil
+		}
+
+		if err := device.Check(vulkan.ResetFences(m.device.LogicalDevice, 1, []vulkan.Fence{b.fence}), "reset transfer fence"); err != nil {
+			return err
+		}
+		if err := device.Check(vulkan.ResetCommandBuffer(b.commandBuffer, 0), "reset transfer command buffer"); err != nil {
+			return err
+		}
+		m.retired = b.written
+		m.inFlight = m.inFlight[1:]
+		m.spare = append(m.spare, b)
+	}
+
+	return nil
+}
+
+func (m *Manager) Close() {
+	// best effort, the device is usually idle by now
+	if len(m.inFlight) > 0 {
+		vulkan.QueueWaitIdle(m.device.TransferQueue)
+	}
+
+	for _, b := range append(m.inFlight, m.spare...) {
+		vulkan.DestroyFence(m.device.LogicalDevice, b.fence, nil)
+		vulkan.FreeCommandBuffers(m.device.LogicalDevice, m.device.TransferPool, 1, []vulkan.CommandBuffer{b.commandBuffer})
+	}
+	vulkan.DestroyBuffer(m.device.LogicalDevice, m.ring, nil)
+	m.device.Free(m.ringMemory)
+}
@@ -1,6 +1,8 @@
 package window
 
 import (
+	"fmt"
+
 	"github.com/go-gl/glfw/v3.3/glfw"
 	"github.com/goki/vulkan"
 )
@@ -11,15 +13,16 @@ type Window struct {
 	SizeChanged bool
 }
 
-func New() Window {
+func New() (Window, error) {
 	if err := glfw.Init(); err != nil {
-		panic("failed to initialize GLFW: " + err.Error())
+		return Window{}, fmt.Errorf("failed to initialize GLFW: %w", err)
 	}
 	glfw.WindowHint(glfw.ClientAPI, glfw.NoAPI)
 	glfw.WindowHint(glfw.Resizable, glfw.True)
 	window, err := glfw.CreateWindow(800, 600, "Game App", nil, nil)
 	if err != nil {
-		panic(err)
+		glfw.Terminate()
+		return Window{}, fmt.Errorf("failed to create window: %w", err)
 	}
 
 	w := Window{
@@ -36,7 +39,7 @@ func New() Window {
 		w.SizeChanged = true
 	})
 
-	return w
+	return w, nil
 }
 
 func (w *Window) Close() {
@@ -44,17 +47,21 @@ func (w *Window) Close() {
 	glfw.Terminate()
 }
 
+func (w *Window) SetTitle(title string) {
+	w.window.SetTitle(title)
+}
+
 func (w *Window) ShouldClose() bool {
 	return w.window.ShouldClose()
 }
 
-func (w *Window) CreateSurface(instance vulkan.Instance) vulkan.Surface {
+func (w *Window) CreateSurface(instance vulkan.Instance) (vulkan.Surface, error) {
 	surface, err := w.window.CreateWindowSurface(instance, nil)
 	if err != nil {
-		panic("failed to create window surface: " + err.Error())
+		return vulkan.NullSurface, fmt.Errorf("failed to create window surface: %w", err)
 	}
 
-	return vulkan.SurfaceFromPointer(surface)
+	return vulkan.SurfaceFromPointer(surface), nil
 }
 
 func (w *Window) GetRequiredInstanceExtensions() []string {
@@ -65,3 +72,41 @@ func (w *Window) GetRequiredInstanceExtensions() []string {
 
 	return result
 }
+
+// Handler receives the keyboard and mouse events of a window. Cursor
+// positions are in normalized device coordinates, which like Vulkan have y
+// pointing down. GLFW calls it from PollEvents and WaitEvents, on the
+// thread that created the window.
+type Handler interface {
+	Key(key glfw.Key, action glfw.Action, mods glfw.ModifierKey)
+	MouseButton(button glfw.MouseButton, action glfw.Action, cursor [2]float32)
+	CursorMove(cursor [2]float32)
+	Scroll(offset float32, cursor [2]float32)
+}
+
+// SetHandler routes the keyboard and mouse events to h.
+func (w *Window) SetHandler(h Handler) {
+	w.window.SetKeyCallback(func(_ *glfw.Window, key glfw.Key, _ int, action glfw.Action, mods glfw.ModifierKey) {
+		h.Key(key, action, mods)
+	})
+	w.window.SetMouseButtonCallback(func(window *glfw.Window, button glfw.MouseButton, action glfw.Action, _ glfw.ModifierKey) {
+		h.MouseButton(button, action, w.toNDC(window.GetCursorPos()))
+	})
+	w.window.SetCursorPosCallback(func(_ *glfw.Window, x, y float64) {
+		h.CursorMove(w.toNDC(x, y))
+	})
+	w.window.SetScrollCallback(func(window *glfw.Window, _, yoff float64) {
+		h.Scroll(float32(yoff), w.toNDC(window.GetCursorPos()))
+	})
+}
+
+// toNDC maps a cursor position in screen coordinates to normalized device
+// coordinates.
+func (w *Window) toNDC(x, y float64) [2]float32 {
+	width, height := w.window.GetSize()
+
+	return [2]float32{
+		float32(2*x/float64(max(width, 1)) - 1),
+		float32(2*y/float64(max(height, 1)) - 1),
+	}
+}